@@ -1,8 +1,193 @@
 package main
 
-import "github.com/medhir/yaml-api/server"
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/config"
+	"github.com/medhir/yaml-api/logging"
+	"github.com/medhir/yaml-api/server"
+	"github.com/medhir/yaml-api/storage"
+	"github.com/medhir/yaml-api/webhook"
+	"gopkg.in/yaml.v2"
+)
+
+// storageReadyTimeout is how long /readyz waits for the storage to serve reads before reporting it unavailable
+const storageReadyTimeout = time.Second
 
 func main() {
-	server := server.NewServer(":1111")
-	server.Start()
+	err := run()
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		exit(err)
+	}
+}
+
+// run starts the server with the configuration from the command line and environment, and returns once it stops.
+// Errors are returned rather than exiting, so that deferred cleanup, such as closing the log file, still runs.
+func run() error {
+	cfg, options, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		return err
+	}
+	// an invalid configuration is still printed, to help find where a setting came from
+	if options.PrintConfig && cfg != nil {
+		data, marshalErr := yaml.Marshal(cfg)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		fmt.Print(string(data))
+		if err == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	level, err := logging.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	logOutput := os.Stdout
+	if cfg.Log.File != "" {
+		logOutput, err = os.OpenFile(cfg.Log.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer logOutput.Close()
+	}
+
+	logger := logging.New(logOutput, level)
+
+	authenticators := auth.Chain{}
+	var tlsConfig *tls.Config
+	if cfg.TLSEnabled() {
+		tlsConfig, err = server.LoadTLSConfig(server.TLSFiles{
+			CertFile:          cfg.TLS.CertFile,
+			KeyFile:           cfg.TLS.KeyFile,
+			ClientCAFile:      cfg.TLS.ClientCAFile,
+			RequireClientCert: cfg.TLS.RequireClientCert,
+		}, logger)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.TLS.ClientCAFile != "" {
+		certConfig := auth.CertConfig{IdentityScopes: map[string][]auth.Scope{}}
+		for identity, scopes := range cfg.TLS.ClientScopes {
+			certConfig.IdentityScopes[identity], err = parseScopes(scopes)
+			if err != nil {
+				return err
+			}
+		}
+		certConfig.DefaultScopes, err = parseScopes(cfg.TLS.ClientDefaultScopes)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, auth.NewCertAuthenticator(certConfig))
+	}
+	if cfg.Auth.KeysFile != "" {
+		keys, err := auth.LoadKeyStore(cfg.Auth.KeysFile)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, keys)
+	}
+	if cfg.JWTEnabled() {
+		jwtConfig := auth.JWTConfig{
+			JWKSFile:    cfg.Auth.JWT.JWKSFile,
+			Issuer:      cfg.Auth.JWT.Issuer,
+			Audience:    cfg.Auth.JWT.Audience,
+			EmailClaim:  cfg.Auth.JWT.EmailClaim,
+			GroupsClaim: cfg.Auth.JWT.GroupsClaim,
+			GroupScopes: map[string][]auth.Scope{},
+		}
+		if cfg.Auth.JWT.SecretFile != "" {
+			secret, err := ioutil.ReadFile(cfg.Auth.JWT.SecretFile)
+			if err != nil {
+				return err
+			}
+			jwtConfig.HMACSecret = []byte(strings.TrimSpace(string(secret)))
+		}
+		for group, scopes := range cfg.Auth.JWT.GroupScopes {
+			jwtConfig.GroupScopes[group], err = parseScopes(scopes)
+			if err != nil {
+				return err
+			}
+		}
+		jwt, err := auth.NewJWTAuthenticator(jwtConfig)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+
+	store := storage.NewStorage(
+		storage.WithAnalyzer(cfg.StorageAnalyzer()),
+		storage.WithMXCheck(cfg.Validation.CheckMX),
+	)
+	webhookOptions := webhook.DefaultOptions
+	webhookOptions.Workers = cfg.Limits.WebhookWorkers
+	webhookOptions.MaxAttempts = cfg.Limits.WebhookMaxAttempts
+	webhookOptions.AllowInternalURLs = cfg.Limits.WebhookAllowInternal
+	serverOptions := []server.Option{
+		server.WithStorage(store),
+		server.WithStrictYAML(cfg.Validation.StrictYAML),
+		server.WithAnonymousReads(cfg.Auth.AnonymousReads),
+		server.WithLogger(logger),
+		server.WithReadinessCheck("storage", func() error { return store.Ready(storageReadyTimeout) }),
+		server.WithEventBufferSize(cfg.Limits.EventBuffer),
+		server.WithWebhookOptions(webhookOptions),
+		server.WithMaxBodyBytes(int64(cfg.Limits.MaxBodyBytes)),
+		server.WithMaxImportBytes(int64(cfg.Limits.MaxImportBytes)),
+		server.WithRateLimits(
+			server.RateLimit{RequestsPerMinute: cfg.Limits.ReadRate, Burst: cfg.Limits.ReadBurst},
+			server.RateLimit{RequestsPerMinute: cfg.Limits.WriteRate, Burst: cfg.Limits.WriteBurst},
+		),
+		server.WithDrainDelay(cfg.Timeouts.DrainDelay),
+		server.WithTimeouts(server.Timeouts{
+			ReadHeader: cfg.Timeouts.ReadHeader,
+			Read:       cfg.Timeouts.Read,
+			Write:      cfg.Timeouts.Write,
+			Idle:       cfg.Timeouts.Idle,
+			Shutdown:   cfg.Timeouts.Shutdown,
+		}),
+	}
+	if tlsConfig != nil {
+		serverOptions = append(serverOptions, server.WithTLSConfig(tlsConfig))
+	}
+	if len(authenticators) > 0 {
+		serverOptions = append(serverOptions, server.WithAuthenticator(authenticators))
+	}
+	server := server.NewServer(cfg.Addr, serverOptions...)
+	return server.Start()
+}
+
+// parseScopes parses scopes that have already been validated with the configuration
+func parseScopes(names []string) ([]auth.Scope, error) {
+	scopes := []auth.Scope{}
+	for _, name := range names {
+		scope, err := auth.ParseScope(name)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// exit logs an error that stopped the server from starting or running to stderr, and exits with a failure status.
+// It is only called from main, once run has returned and its deferred cleanup has run.
+func exit(err error) {
+	logging.New(os.Stderr, logging.LevelError).Error("fatal error", "error", err)
+	os.Exit(1)
 }
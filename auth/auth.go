@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Scope is a permission granted to a principal
+type Scope string
+
+const (
+	// ScopeRead allows searching and fetching metadata
+	ScopeRead = Scope("read")
+	// ScopeWrite allows submitting and modifying metadata, and implies ScopeRead
+	ScopeWrite = Scope("write")
+	// ScopeAdmin allows every operation, and implies every other scope
+	ScopeAdmin = Scope("admin")
+)
+
+// ParseScope converts the name of a scope into a Scope, failing for unknown scope names
+func ParseScope(name string) (Scope, error) {
+	switch scope := Scope(name); scope {
+	case ScopeRead, ScopeWrite, ScopeAdmin:
+		return scope, nil
+	default:
+		return "", errors.New("unknown scope " + name + ", must be one of read, write or admin")
+	}
+}
+
+// Method names the kind of authenticator that identified a principal
+type Method string
+
+const (
+	// MethodAPIKey identifies principals authenticated by an API key
+	MethodAPIKey = Method("key")
+	// MethodJWT identifies principals authenticated by a bearer token
+	MethodJWT = Method("jwt")
+	// MethodCert identifies principals authenticated by a client certificate
+	MethodCert = Method("cert")
+)
+
+// Principal describes the authenticated caller of a request
+type Principal struct {
+	Name   string  `json:"name"`
+	Email  string  `json:"email,omitempty"`
+	Method Method  `json:"method,omitempty"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// ID identifies the principal across authenticators, which may each know a different caller by the same name
+func (p *Principal) ID() string {
+	if p.Method == "" {
+		return p.Name
+	}
+	return string(p.Method) + ":" + p.Name
+}
+
+// HasScope reports whether the principal has been granted a scope, either directly or through a broader scope
+func (p *Principal) HasScope(scope Scope) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+		if granted == ScopeWrite && scope == ScopeRead {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when a request carries none of the credentials it understands
+	ErrNoCredentials = errors.New("no credentials provided")
+	// ErrInvalidCredentials is returned by an Authenticator when a request carries credentials that cannot be verified
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator identifies the principal making a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each authenticator in order, returning the first principal found.
+// Requests are only reported as missing credentials when no authenticator finds any.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return principal, err
+	}
+	return nil, ErrNoCredentials
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal carried by ctx, or nil for anonymous requests
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextKey{}).(*Principal)
+	return principal
+}
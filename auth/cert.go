@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/x509"
+	"net/http"
+)
+
+// CertConfig describes how verified client certificates map to a principal
+type CertConfig struct {
+	// IdentityScopes grants scopes to certificates by identity. A certificate's identities are its subject common
+	// name, and its DNS, email and URI subject alternative names.
+	IdentityScopes map[string][]Scope
+	// DefaultScopes are granted to every client with a verified certificate
+	DefaultScopes []Scope
+}
+
+// CertAuthenticator authenticates requests made over mutual TLS. Certificates must already have been verified
+// against trusted client CAs during the TLS handshake, so requests without a verified certificate have no
+// credentials.
+type CertAuthenticator struct {
+	config CertConfig
+}
+
+// NewCertAuthenticator creates an authenticator for verified client certificates
+func NewCertAuthenticator(config CertConfig) *CertAuthenticator {
+	return &CertAuthenticator{config: config}
+}
+
+// Authenticate implements Authenticator
+func (a *CertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	identities := CertIdentities(cert)
+	if len(identities) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	principal := &Principal{Name: identities[0], Method: MethodCert}
+	if len(cert.EmailAddresses) > 0 {
+		principal.Email = cert.EmailAddresses[0]
+	}
+	granted := map[Scope]bool{}
+	grant := func(scopes []Scope) {
+		for _, scope := range scopes {
+			if !granted[scope] {
+				granted[scope] = true
+				principal.Scopes = append(principal.Scopes, scope)
+			}
+		}
+	}
+	grant(a.config.DefaultScopes)
+	for _, identity := range identities {
+		grant(a.config.IdentityScopes[identity])
+	}
+	return principal, nil
+}
+
+// CertIdentities returns the subject common name of a certificate, followed by its DNS, email and URI subject
+// alternative names
+func CertIdentities(cert *x509.Certificate) []string {
+	identities := []string{}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
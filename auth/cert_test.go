@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CertAuthenticator(t *testing.T) {
+	authenticator := NewCertAuthenticator(CertConfig{
+		IdentityScopes: map[string][]Scope{
+			"ci-pipeline":                   {ScopeWrite},
+			"spiffe://example.com/platform": {ScopeAdmin},
+		},
+		DefaultScopes: []Scope{ScopeRead},
+	})
+	authenticate := func(cert *x509.Certificate) (*Principal, error) {
+		r := httptest.NewRequest("GET", "/metadata", nil)
+		if cert != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		return authenticator.Authenticate(r)
+	}
+	platform, err := url.Parse("spiffe://example.com/platform")
+	assert.NoError(t, err)
+
+	t.Run("Verified certificates map their subject and SANs to a principal", func(t *testing.T) {
+		principal, err := authenticate(&x509.Certificate{
+			Subject:        pkix.Name{CommonName: "ci-pipeline"},
+			EmailAddresses: []string{"ci@example.com"},
+			URIs:           []*url.URL{platform},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, &Principal{Name: "ci-pipeline", Email: "ci@example.com", Method: MethodCert, Scopes: []Scope{ScopeRead, ScopeWrite, ScopeAdmin}}, principal)
+
+		principal, err = authenticate(&x509.Certificate{DNSNames: []string{"deploy.example.com"}})
+		assert.NoError(t, err)
+		assert.Equal(t, &Principal{Name: "deploy.example.com", Method: MethodCert, Scopes: []Scope{ScopeRead}}, principal)
+	})
+
+	t.Run("Requests without a verified certificate have no credentials", func(t *testing.T) {
+		_, err := authenticate(nil)
+		assert.Equal(t, ErrNoCredentials, err)
+	})
+
+	t.Run("Certificates without an identity are rejected", func(t *testing.T) {
+		_, err := authenticate(&x509.Certificate{})
+		assert.Equal(t, ErrInvalidCredentials, err)
+	})
+}
@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTConfig describes how bearer tokens are verified, and how their claims map to a principal
+type JWTConfig struct {
+	// JWKSFile is the path to a JSON Web Key Set holding the RSA and EC public keys used to verify RS256 and ES256
+	// tokens. The file is reloaded whenever it changes.
+	JWKSFile string
+	// HMACSecret is the shared secret used to verify HS256 tokens
+	HMACSecret []byte
+	// Issuer and Audience are compared to the iss and aud claims, when set
+	Issuer   string
+	Audience string
+	// NameClaim, EmailClaim and GroupsClaim name the claims used to build a principal.
+	// They default to sub, email and groups.
+	NameClaim   string
+	EmailClaim  string
+	GroupsClaim string
+	// GroupScopes grants scopes to principals that are members of a group
+	GroupScopes map[string][]Scope
+	// DefaultScopes are granted to every principal with a valid token
+	DefaultScopes []Scope
+	// Leeway allows for clock skew when checking the exp and nbf claims
+	Leeway time.Duration
+}
+
+// JWTAuthenticator authenticates requests carrying a JSON Web Token in an `Authorization: Bearer` header.
+// Tokens are verified entirely offline, using local keys.
+type JWTAuthenticator struct {
+	config JWTConfig
+	keys   *keySet
+	now    func() time.Time
+}
+
+// NewJWTAuthenticator creates an authenticator verifying tokens with the configured keys
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	if config.JWKSFile == "" && len(config.HMACSecret) == 0 {
+		return nil, errors.New("a JWKS file or an HMAC secret is required to verify tokens")
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = "sub"
+	}
+	if config.EmailClaim == "" {
+		config.EmailClaim = "email"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	authenticator := &JWTAuthenticator{config: config, now: time.Now}
+	if config.JWKSFile != "" {
+		authenticator.keys = &keySet{path: config.JWKSFile}
+		_, err := authenticator.keys.current()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return authenticator, nil
+}
+
+// Authenticate implements Authenticator
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	claims, err := a.verify(strings.TrimSpace(header[len("Bearer "):]))
+	if err != nil {
+		return nil, err
+	}
+	return a.principal(claims)
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// verify checks the signature and standard claims of a token, returning its claims
+func (a *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token must have a header, payload and signature")
+	}
+	header := jwtHeader{}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("token header is malformed: %s", err.Error())
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("token signature is malformed")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Algorithm {
+	case "HS256":
+		err = a.verifyHMAC(signed, signature)
+	case "RS256", "ES256":
+		err = a.verifyPublicKey(header, signed, signature)
+	default:
+		err = fmt.Errorf("token signing algorithm %q is not supported", header.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("token payload is malformed: %s", err.Error())
+	}
+	return claims, a.validateClaims(claims)
+}
+
+func (a *JWTAuthenticator) verifyHMAC(signed, signature []byte) error {
+	if len(a.config.HMACSecret) == 0 {
+		return errors.New("HS256 tokens are not accepted")
+	}
+	mac := hmac.New(sha256.New, a.config.HMACSecret)
+	mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return errors.New("token signature is invalid")
+	}
+	return nil
+}
+
+func (a *JWTAuthenticator) verifyPublicKey(header jwtHeader, signed, signature []byte) error {
+	if a.keys == nil {
+		return fmt.Errorf("%s tokens are not accepted", header.Algorithm)
+	}
+	keys, err := a.keys.current()
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(signed)
+	for kid, key := range keys {
+		if header.KeyID != "" && kid != header.KeyID {
+			continue
+		}
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if header.Algorithm == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if header.Algorithm == "ES256" && len(signature) == 64 {
+				r := new(big.Int).SetBytes(signature[:32])
+				s := new(big.Int).SetBytes(signature[32:])
+				if ecdsa.Verify(key, digest[:], r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("token signature is invalid")
+}
+
+func (a *JWTAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := a.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token must have an exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(a.config.Leeway)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return errors.New("token was not issued by " + a.config.Issuer)
+	}
+	if a.config.Audience != "" && !containsString(stringsClaim(claims["aud"]), a.config.Audience) {
+		return errors.New("token is not intended for " + a.config.Audience)
+	}
+	return nil
+}
+
+// principal maps the claims of a verified token to a principal
+func (a *JWTAuthenticator) principal(claims map[string]interface{}) (*Principal, error) {
+	name, _ := claims[a.config.NameClaim].(string)
+	email, _ := claims[a.config.EmailClaim].(string)
+	if name == "" {
+		name = email
+	}
+	if name == "" {
+		return nil, fmt.Errorf("token must have a %s or %s claim", a.config.NameClaim, a.config.EmailClaim)
+	}
+	principal := &Principal{Name: name, Email: email, Method: MethodJWT, Scopes: append([]Scope{}, a.config.DefaultScopes...)}
+	for _, group := range stringsClaim(claims[a.config.GroupsClaim]) {
+		principal.Scopes = append(principal.Scopes, a.config.GroupScopes[group]...)
+	}
+	return principal, nil
+}
+
+// stringsClaim reads a claim holding either a single string, a space separated list, or an array of strings
+func stringsClaim(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []interface{}:
+		values := []string{}
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// keySet holds the public keys loaded from a JWKS file, reloading them when the file changes
+type keySet struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	keys    map[string]crypto.PublicKey
+}
+
+// current returns the keys in the JWKS file, reloading the file if it has been modified since it was last read.
+// If a modified file cannot be loaded, for example while it is being rewritten, the previous keys are kept.
+func (ks *keySet) current() (map[string]crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		if ks.keys != nil {
+			return ks.keys, nil
+		}
+		return nil, fmt.Errorf("unable to read JWKS file: %s", err.Error())
+	}
+	if ks.keys != nil && info.ModTime().Equal(ks.modTime) && info.Size() == ks.size {
+		return ks.keys, nil
+	}
+	keys, err := loadJWKS(ks.path)
+	if err != nil {
+		if ks.keys != nil {
+			return ks.keys, nil
+		}
+		return nil, err
+	}
+	ks.keys, ks.modTime, ks.size = keys, info.ModTime(), info.Size()
+	return ks.keys, nil
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWKS file: %s", err.Error())
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS file %s is not valid: %s", path, err.Error())
+	}
+	keys := map[string]crypto.PublicKey{}
+	for i, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		kid := key.KeyID
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %s in %s: %s", kid, path, err.Error())
+		}
+		keys[kid] = publicKey
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, errors.New("RSA key must have a valid modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("RSA key must have a valid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("EC curve %q is not supported", k.Curve)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("EC key must have valid coordinates")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC key is not on the P-256 curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("key type %q is not supported", k.KeyType)
+	}
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signToken creates a JWT for the given claims, signed with an *rsa.PrivateKey, *ecdsa.PrivateKey or HMAC secret
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		assert.NoError(t, err)
+		signature = make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + b64(signature)
+}
+
+func writeJWKS(t *testing.T, path string, keys map[string]interface{}) {
+	set := []map[string]string{}
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			set = append(set, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   b64(key.N.Bytes()),
+				"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+			})
+		case *ecdsa.PrivateKey:
+			set = append(set, map[string]string{
+				"kty": "EC",
+				"kid": kid,
+				"crv": "P-256",
+				"x":   b64(key.X.Bytes()),
+				"y":   b64(key.Y.Bytes()),
+			})
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": set})
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(path, data, 0600)
+	assert.NoError(t, err)
+}
+
+func Test_JWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	secret := []byte("shared secret")
+
+	dir, err := ioutil.TempDir("", "jwks")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	jwksPath := dir + "/jwks.json"
+	writeJWKS(t, jwksPath, map[string]interface{}{"rsa": rsaKey, "ec": ecKey})
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		JWKSFile:      jwksPath,
+		HMACSecret:    secret,
+		Issuer:        "https://sso.example.com",
+		Audience:      "yaml-api",
+		GroupScopes:   map[string][]Scope{"platform": {ScopeAdmin}},
+		DefaultScopes: []Scope{ScopeRead},
+	})
+	assert.NoError(t, err)
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":    "bill",
+			"email":  "bill@gmail.com",
+			"groups": []string{"platform"},
+			"iss":    "https://sso.example.com",
+			"aud":    []string{"yaml-api", "other"},
+			"exp":    now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	authenticate := func(token string) (*Principal, error) {
+		r := httptest.NewRequest("GET", "/metadata", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return authenticator.Authenticate(r)
+	}
+
+	t.Run("Tokens signed with trusted keys map their claims to a principal", func(t *testing.T) {
+		tokens := map[string]string{
+			"RS256": signToken(t, "RS256", "rsa", rsaKey, claims(nil)),
+			"ES256": signToken(t, "ES256", "ec", ecKey, claims(nil)),
+			"HS256": signToken(t, "HS256", "", secret, claims(nil)),
+		}
+		for alg, token := range tokens {
+			principal, err := authenticate(token)
+			if assert.NoError(t, err, alg) {
+				assert.Equal(t, &Principal{Name: "bill", Email: "bill@gmail.com", Method: MethodJWT, Scopes: []Scope{ScopeRead, ScopeAdmin}}, principal, alg)
+			}
+		}
+	})
+
+	t.Run("Tokens that cannot be verified are rejected", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			token      string
+			errMessage string
+		}{
+			{"untrusted key", signToken(t, "RS256", "", otherKey, claims(nil)), "token signature is invalid"},
+			{"mismatched key ID", signToken(t, "RS256", "ec", rsaKey, claims(nil)), "token signature is invalid"},
+			{"wrong secret", signToken(t, "HS256", "", []byte("guess"), claims(nil)), "token signature is invalid"},
+			{"unsigned", signToken(t, "none", "", nil, claims(nil)), `token signing algorithm "none" is not supported`},
+			{"expired", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), "token has expired"},
+			{"no expiry", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": nil})), "token must have an exp claim"},
+			{"not yet valid", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})), "token is not valid yet"},
+			{"other issuer", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "https://evil.example.com"})), "token was not issued by https://sso.example.com"},
+			{"other audience", signToken(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"})), "token is not intended for yaml-api"},
+			{"malformed", "not-a-token", "token must have a header, payload and signature"},
+		}
+		for _, tt := range tests {
+			_, err := authenticate(tt.token)
+			if assert.Error(t, err, tt.name) {
+				assert.Equal(t, tt.errMessage, err.Error(), tt.name)
+			}
+		}
+	})
+
+	t.Run("Requests without a bearer token have no credentials", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/metadata", nil)
+		r.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+		_, err := authenticator.Authenticate(r)
+		assert.Equal(t, ErrNoCredentials, err)
+	})
+
+	t.Run("Keys are reloaded when the JWKS file changes", func(t *testing.T) {
+		token := signToken(t, "RS256", "rotated", otherKey, claims(nil))
+		_, err := authenticate(token)
+		assert.Error(t, err)
+		writeJWKS(t, jwksPath, map[string]interface{}{"rotated": otherKey})
+		later := time.Now().Add(time.Minute)
+		assert.NoError(t, os.Chtimes(jwksPath, later, later))
+		_, err = authenticate(token)
+		assert.NoError(t, err)
+		_, err = authenticate(signToken(t, "RS256", "rsa", rsaKey, claims(nil)))
+		assert.Error(t, err)
+	})
+}
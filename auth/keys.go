@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// APIKeyHeader is the request header carrying an API key
+const APIKeyHeader = "X-API-Key"
+
+const hashPrefix = "sha256:"
+
+// KeyStore authenticates requests by API key. Keys are only ever stored as hashes.
+type KeyStore struct {
+	principals map[string]*Principal
+}
+
+// keysFile describes the YAML file API keys are loaded from, for example:
+//
+//	keys:
+//	- name: ci-pipeline
+//	  email: ci@example.com
+//	  hash: sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	  scopes: [read, write]
+type keysFile struct {
+	Keys []struct {
+		Name   string   `yaml:"name"`
+		Email  string   `yaml:"email"`
+		Hash   string   `yaml:"hash"`
+		Scopes []string `yaml:"scopes"`
+	} `yaml:"keys"`
+}
+
+// LoadKeyStore reads the API keys file at path
+func LoadKeyStore(path string) (*KeyStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read keys file: %s", err.Error())
+	}
+	file := keysFile{}
+	err = yaml.UnmarshalStrict(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("keys file %s is not valid: %s", path, err.Error())
+	}
+	store := &KeyStore{principals: map[string]*Principal{}}
+	for i, key := range file.Keys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("key %d in %s must have a name", i, path)
+		}
+		hash := strings.ToLower(strings.TrimPrefix(key.Hash, hashPrefix))
+		decoded, err := hex.DecodeString(hash)
+		if err != nil || len(decoded) != sha256.Size || !strings.HasPrefix(key.Hash, hashPrefix) {
+			return nil, fmt.Errorf("key %s in %s must have a hash formatted as sha256:<hex digest>", key.Name, path)
+		}
+		if _, ok := store.principals[hash]; ok {
+			return nil, fmt.Errorf("key %s in %s has the same hash as another key", key.Name, path)
+		}
+		if len(key.Scopes) == 0 {
+			return nil, fmt.Errorf("key %s in %s must have at least one scope", key.Name, path)
+		}
+		principal := &Principal{Name: key.Name, Email: key.Email, Method: MethodAPIKey}
+		for _, name := range key.Scopes {
+			scope, err := ParseScope(name)
+			if err != nil {
+				return nil, fmt.Errorf("key %s in %s: %s", key.Name, path, err.Error())
+			}
+			principal.Scopes = append(principal.Scopes, scope)
+		}
+		store.principals[hash] = principal
+	}
+	return store, nil
+}
+
+// Authenticate implements Authenticator, using the API key in the X-API-Key header
+func (ks *KeyStore) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	principal, ok := ks.principals[strings.TrimPrefix(HashKey(key), hashPrefix)]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return principal, nil
+}
+
+// HashKey returns the hash of an API key, as it is written to the keys file
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// GenerateKey returns a new random API key
+func GenerateKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.New("unable to generate API key: " + err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
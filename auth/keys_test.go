@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeKeysFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "keys")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "keys.yaml")
+	err = ioutil.WriteFile(path, []byte(contents), 0600)
+	assert.NoError(t, err)
+	return path
+}
+
+func Test_LoadKeyStore(t *testing.T) {
+	tests := []struct {
+		name       string
+		contents   string
+		errMessage string
+	}{
+		{
+			name:       "Loading fails when a key has no name",
+			contents:   "keys:\n- hash: " + HashKey("secret") + "\n  scopes: [read]\n",
+			errMessage: "key 0 in %s must have a name",
+		},
+		{
+			name:       "Loading fails when a key is stored in plain text",
+			contents:   "keys:\n- name: ci\n  hash: secret\n  scopes: [read]\n",
+			errMessage: "key ci in %s must have a hash formatted as sha256:<hex digest>",
+		},
+		{
+			name:       "Loading fails when a key has no scopes",
+			contents:   "keys:\n- name: ci\n  hash: " + HashKey("secret") + "\n",
+			errMessage: "key ci in %s must have at least one scope",
+		},
+		{
+			name:       "Loading fails when a key has an unknown scope",
+			contents:   "keys:\n- name: ci\n  hash: " + HashKey("secret") + "\n  scopes: [delete]\n",
+			errMessage: "key ci in %s: unknown scope delete, must be one of read, write or admin",
+		},
+		{
+			name:       "Loading fails when two keys share a hash",
+			contents:   "keys:\n- name: ci\n  hash: " + HashKey("secret") + "\n  scopes: [read]\n- name: cd\n  hash: " + HashKey("secret") + "\n  scopes: [read]\n",
+			errMessage: "key cd in %s has the same hash as another key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeKeysFile(t, tt.contents)
+			_, err := LoadKeyStore(path)
+			if assert.Error(t, err) {
+				assert.Equal(t, fmt.Sprintf(tt.errMessage, path), err.Error())
+			}
+		})
+	}
+}
+
+func Test_KeyStoreAuthenticate(t *testing.T) {
+	key, err := GenerateKey()
+	assert.NoError(t, err)
+	path := writeKeysFile(t, "keys:\n- name: ci\n  email: ci@example.com\n  hash: "+HashKey(key)+"\n  scopes: [write]\n")
+	store, err := LoadKeyStore(path)
+	assert.NoError(t, err)
+
+	t.Run("Requests with a known key are authenticated as the key's principal", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/metadata", nil)
+		r.Header.Set(APIKeyHeader, key)
+		principal, err := store.Authenticate(r)
+		assert.NoError(t, err)
+		assert.Equal(t, &Principal{Name: "ci", Email: "ci@example.com", Method: MethodAPIKey, Scopes: []Scope{ScopeWrite}}, principal)
+		assert.Equal(t, "key:ci", principal.ID())
+		assert.True(t, principal.HasScope(ScopeRead))
+		assert.True(t, principal.HasScope(ScopeWrite))
+		assert.False(t, principal.HasScope(ScopeAdmin))
+	})
+
+	t.Run("Requests with an unknown key are rejected", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/metadata", nil)
+		r.Header.Set(APIKeyHeader, key+"0")
+		_, err := store.Authenticate(r)
+		assert.Equal(t, ErrInvalidCredentials, err)
+	})
+
+	t.Run("Requests without a key have no credentials", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/metadata", nil)
+		_, err := store.Authenticate(r)
+		assert.Equal(t, ErrNoCredentials, err)
+	})
+}
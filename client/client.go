@@ -0,0 +1,219 @@
+// Package client calls the YAML API from Go programs
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/medhir/yaml-api/auth"
+)
+
+// Client sends requests to a YAML API server. Requests that fail with a 5xx or 429 status code are retried with
+// exponential backoff. POST requests, which are not idempotent, are only retried when the server rejected them
+// without processing them: with a 429, or a 503 carrying a Retry-After header.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	header     http.Header
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures optional behavior of a client
+type Option func(*Client)
+
+// WithHTTPClient sends requests with the given http client, instead of http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey authenticates requests with an API key
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.header.Set(auth.APIKeyHeader, key)
+	}
+}
+
+// WithBearerToken authenticates requests with a JWT bearer token
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithRetries sets how many times failed requests are retried, and how long to wait before the first retry.
+// The wait doubles with every retry, unless the server asks clients to retry after a given delay.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New creates a client for the server at baseURL, such as https://yaml-api.example.com
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		header:     http.Header{},
+		retries:    3,
+		backoff:    100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Error is returned when the server responds with an error status code, and holds the problem details describing
+// why the request failed
+type Error struct {
+	StatusCode int    `json:"status"`
+	Title      string `json:"title"`
+	Detail     string `json:"detail"`
+	// Field and Reason describe why submitted metadata is invalid, such as maintainers.email and invalid_email
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+	// Maintainer is the position of the invalid maintainer, for maintainer fields
+	Maintainer *int `json:"maintainer"`
+	// Document is the position of the invalid document in a batch
+	Document *int `json:"document"`
+	// Errors describes every invalid document in a batch
+	Errors []*Error `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("yaml-api: %d %s", e.StatusCode, e.Title)
+	}
+	return fmt.Sprintf("yaml-api: %d %s: %s", e.StatusCode, e.Title, e.Detail)
+}
+
+// IsNotFound reports whether err is an Error with a 404 status code
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsPreconditionFailed reports whether err is an Error with a 412 status code, returned when a write required a
+// revision of metadata that is no longer current
+func IsPreconditionFailed(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusPreconditionFailed
+}
+
+// decodeError reads the problem details of an error response. Responses that are not problem details, such as
+// those from proxies, are described by their body.
+func decodeError(resp *http.Response) error {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("could not read %s response: %v", resp.Status, err)
+	}
+	apiErr := &Error{}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/problem+json") && json.Unmarshal(body, apiErr) == nil {
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+	return &Error{StatusCode: resp.StatusCode, Title: http.StatusText(resp.StatusCode), Detail: strings.TrimSpace(string(body))}
+}
+
+// do sends a request, retrying it when the server fails or is rate limiting clients, and returns the response
+// when it has a successful status code. Other responses are returned as an *Error.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte, options ...WriteOption) (*http.Response, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		for name, values := range c.header {
+			req.Header[name] = values
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for _, option := range options {
+			option(req.Header)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		if !retryable(method, resp) || attempt >= c.retries {
+			defer resp.Body.Close()
+			return nil, decodeError(resp)
+		}
+		wait := backoff
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		// the body is drained so the connection can be reused
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		err = sleep(ctx, wait)
+		if err != nil {
+			return nil, err
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// retryable reports whether a failed request can be sent again. Idempotent requests are retried on any server
+// error, but a POST that failed with a server error may already have created a document, so it is only retried when
+// the server asked for it.
+func retryable(method string, resp *http.Response) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+	}
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != ""
+}
+
+// doJSON sends a request, decoding the JSON body of a successful response into v
+func (c *Client) doJSON(ctx context.Context, method, path, contentType string, body []byte, v interface{}, options ...WriteOption) error {
+	resp, err := c.do(ctx, method, path, contentType, body, options...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("could not decode %s %s response: %v", method, path, err)
+	}
+	return nil
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/medhir/yaml-api/logging"
+	"github.com/medhir/yaml-api/server"
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer serves a real server, passing requests through intercept first if it is not nil. Requests are
+// passed on to the server when intercept returns false.
+func newTestServer(t *testing.T, intercept func(w http.ResponseWriter, r *http.Request) bool) *httptest.Server {
+	s := server.NewServer("127.0.0.1:0",
+		server.WithLogger(logging.New(ioutil.Discard, logging.LevelError)),
+		// maintainer emails are not looked up, so tests do not depend on DNS
+		server.WithStorage(storage.NewStorage(storage.WithMXCheck(false))),
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if intercept != nil && intercept(w, r) {
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func testMetadata(title, version string) *storage.Metadata {
+	return &storage.Metadata{
+		Title:       title,
+		Version:     version,
+		Maintainers: []storage.Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}},
+		Company:     "Random Inc.",
+		Website:     "https://website.com",
+		Source:      "https://github.com/random/repo",
+		License:     "Apache-2.0",
+		Description: "Some application content",
+	}
+}
+
+func Test_Client(t *testing.T) {
+	ctx := context.Background()
+	c := New(newTestServer(t, nil).URL)
+
+	t.Run("Metadata can be created, read, updated and deleted", func(t *testing.T) {
+		created, err := c.Create(ctx, testMetadata("App title 1", "1.0.0"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NotEmpty(t, created.ID)
+		assert.Equal(t, storage.Active, created.State)
+
+		got, err := c.Get(ctx, created.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, created, got)
+
+		updated, err := c.Update(ctx, created.ID, testMetadata("App title 1", "1.0.1"))
+		assert.NoError(t, err)
+		assert.Equal(t, created.ID, updated.ID)
+		assert.Equal(t, "1.0.1", updated.Version)
+
+		assert.NoError(t, c.Delete(ctx, created.ID))
+		_, err = c.Get(ctx, created.ID)
+		assert.True(t, IsNotFound(err), "expected a not found error, got %v", err)
+	})
+
+	t.Run("Writes fail when the metadata is no longer at the required revision", func(t *testing.T) {
+		created, err := c.Create(ctx, testMetadata("Revised app", "1.0.0"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		updated, err := c.Update(ctx, created.ID, testMetadata("Revised app", "1.0.1"), IfRevision(created.Revision))
+		assert.NoError(t, err)
+		assert.Equal(t, created.Revision+1, updated.Revision)
+
+		_, err = c.Update(ctx, created.ID, testMetadata("Revised app", "1.0.2"), IfRevision(created.Revision))
+		assert.True(t, IsPreconditionFailed(err), "expected a precondition failed error, got %v", err)
+		err = c.Delete(ctx, created.ID, IfRevision(created.Revision))
+		assert.True(t, IsPreconditionFailed(err), "expected a precondition failed error, got %v", err)
+		assert.NoError(t, c.Delete(ctx, created.ID, IfRevision(updated.Revision)))
+	})
+
+	t.Run("Metadata is searched with a query", func(t *testing.T) {
+		_, err := c.Create(ctx, testMetadata("Searchable app", "2.0.0"))
+		assert.NoError(t, err)
+		results, err := c.Search(ctx, NewQuery().Title("searchable").License("Apache-2.0"))
+		assert.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, "Searchable app", results[0].Title)
+		}
+		results, err = c.Search(ctx, NewQuery().Title("searchable").Version("3.0.0"))
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	})
+
+	t.Run("Versions of an application are listed lowest first", func(t *testing.T) {
+		for _, version := range []string{"1.10.0", "1.2.0"} {
+			_, err := c.Create(ctx, testMetadata("Versioned app", version))
+			assert.NoError(t, err)
+		}
+		versions, err := c.Versions(ctx, "Versioned app")
+		assert.NoError(t, err)
+		if assert.Len(t, versions, 2) {
+			assert.Equal(t, "1.2.0", versions[0].Version)
+			assert.Equal(t, "1.10.0", versions[1].Version)
+		}
+		_, err = c.Versions(ctx, "Unknown app")
+		assert.True(t, IsNotFound(err), "expected a not found error, got %v", err)
+	})
+
+	t.Run("Every stored document is exported", func(t *testing.T) {
+		exported, err := c.Export(ctx)
+		assert.NoError(t, err)
+		titles := []string{}
+		for _, metadata := range exported {
+			assert.NotEmpty(t, metadata.ID)
+			titles = append(titles, metadata.Title)
+		}
+		assert.Contains(t, titles, "Versioned app")
+		assert.NotContains(t, titles, "App title 1")
+	})
+
+	t.Run("Validation failures are returned as errors describing the problem", func(t *testing.T) {
+		md := testMetadata("App title 2", "1.0.0")
+		md.Maintainers[0].Email = ""
+		_, err := c.Create(ctx, md)
+		apiErr, ok := err.(*Error)
+		if assert.True(t, ok, "expected an *Error, got %v", err) {
+			assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+			assert.Equal(t, "maintainer must have an email", apiErr.Detail)
+			assert.Equal(t, "maintainers.email", apiErr.Field)
+			assert.Equal(t, storage.ReasonMissing, apiErr.Reason)
+			if assert.NotNil(t, apiErr.Maintainer) {
+				assert.Equal(t, 0, *apiErr.Maintainer)
+			}
+		}
+	})
+
+	t.Run("Batches store every document", func(t *testing.T) {
+		stored, err := c.Batch(ctx, []*storage.Metadata{testMetadata("Batch app", "1.0.0"), testMetadata("Batch app", "1.1.0")})
+		assert.NoError(t, err)
+		if assert.Len(t, stored, 2) {
+			assert.Equal(t, "1.1.0", stored[1].Version)
+			assert.NotEqual(t, stored[0].ID, stored[1].ID)
+		}
+	})
+
+	t.Run("Batches with invalid documents store nothing", func(t *testing.T) {
+		invalid := testMetadata("Invalid batch app", "latest")
+		_, err := c.Batch(ctx, []*storage.Metadata{testMetadata("Invalid batch app", "1.0.0"), invalid})
+		apiErr, ok := err.(*Error)
+		if assert.True(t, ok, "expected an *Error, got %v", err) && assert.Len(t, apiErr.Errors, 1) {
+			assert.Equal(t, 1, *apiErr.Errors[0].Document)
+			assert.Equal(t, storage.ReasonInvalidVersion, apiErr.Errors[0].Reason)
+		}
+		results, err := c.Search(ctx, NewQuery().Title("invalid batch"))
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	})
+}
+
+func Test_ClientRetries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Server errors and rate limits are retried", func(t *testing.T) {
+		var requests int32
+		ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			switch atomic.AddInt32(&requests, 1) {
+			case 1:
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return true
+			case 2:
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+				return true
+			}
+			return false
+		})
+		c := New(ts.URL, WithRetries(2, time.Millisecond))
+		_, err := c.Create(ctx, testMetadata("App title 1", "1.0.0"))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("The last error is returned once retries run out", func(t *testing.T) {
+		var requests int32
+		ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			atomic.AddInt32(&requests, 1)
+			http.Error(w, "database is down", http.StatusBadGateway)
+			return true
+		})
+		_, err := New(ts.URL, WithRetries(2, time.Millisecond)).Get(ctx, "abc")
+		assert.Equal(t, &Error{StatusCode: http.StatusBadGateway, Title: "Bad Gateway", Detail: "database is down"}, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("Server errors are not retried for POST requests", func(t *testing.T) {
+		var requests int32
+		ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			atomic.AddInt32(&requests, 1)
+			if r.Method == http.MethodPost {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return true
+			}
+			return false
+		})
+		_, err := New(ts.URL, WithRetries(2, time.Millisecond)).Create(ctx, testMetadata("App title 1", "1.0.0"))
+		assert.Equal(t, &Error{StatusCode: http.StatusServiceUnavailable, Title: "Service Unavailable", Detail: "unavailable"}, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("Client errors are not retried", func(t *testing.T) {
+		var requests int32
+		ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			atomic.AddInt32(&requests, 1)
+			return false
+		})
+		_, err := New(ts.URL, WithRetries(2, time.Millisecond)).Get(ctx, "abc")
+		assert.True(t, IsNotFound(err))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("Credentials are sent with every request", func(t *testing.T) {
+		var key, authorization string
+		ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			key, authorization = r.Header.Get("X-API-Key"), r.Header.Get("Authorization")
+			return false
+		})
+		_, err := New(ts.URL, WithAPIKey("secret"), WithBearerToken("token")).Search(ctx, NewQuery())
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", key)
+		assert.Equal(t, "Bearer token", authorization)
+	})
+}
+
+func Test_ClientWatch(t *testing.T) {
+	var connections int32
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path == "/events" {
+			atomic.AddInt32(&connections, 1)
+		}
+		return false
+	})
+	c := New(ts.URL, WithRetries(0, time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan Event, 10)
+	watched := make(chan error, 1)
+	go func() {
+		watched <- c.Watch(ctx, WatchOptions{Types: []string{"create", "delete"}}, func(event Event) error {
+			received <- event
+			return nil
+		})
+	}()
+	// wait for the stream to connect, so that no events are missed
+	for atomic.LoadInt32(&connections) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	created, err := c.Create(context.Background(), testMetadata("Watched app", "1.0.0"))
+	assert.NoError(t, err)
+	_, err = c.Update(context.Background(), created.ID, testMetadata("Watched app", "1.0.1"))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Delete(context.Background(), created.ID))
+
+	t.Run("Changes of the watched types are received in order", func(t *testing.T) {
+		for _, expected := range []Event{{ID: 1, Type: "create"}, {ID: 3, Type: "delete"}} {
+			select {
+			case event := <-received:
+				assert.Equal(t, expected.ID, event.ID)
+				assert.Equal(t, expected.Type, event.Type)
+				assert.Equal(t, storage.ChangeType(expected.Type), event.Change.Type)
+				assert.Equal(t, created.ID, event.Change.Metadata.ID)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for an event")
+			}
+		}
+	})
+
+	t.Run("Watching stops when the context is cancelled", func(t *testing.T) {
+		cancel()
+		select {
+		case err := <-watched:
+			assert.True(t, errors.Is(err, context.Canceled), "expected the context to be cancelled, got %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for Watch to return")
+		}
+	})
+
+	t.Run("Errors returned by the handler stop watching", func(t *testing.T) {
+		stop := errors.New("stop")
+		err := c.Watch(context.Background(), WatchOptions{LastEventID: 1}, func(event Event) error {
+			return stop
+		})
+		assert.Equal(t, stop, err)
+	})
+}
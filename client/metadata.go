@@ -0,0 +1,201 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/medhir/yaml-api/storage"
+	"gopkg.in/yaml.v2"
+)
+
+const yamlContentType = "application/yaml"
+
+// WriteOption sets a header of a request modifying stored metadata
+type WriteOption func(http.Header)
+
+// IfRevision makes a write fail with a 412 Error, checked with IsPreconditionFailed, unless the stored metadata is
+// still at the given revision, such as the Revision of metadata returned by Get
+func IfRevision(revision int) WriteOption {
+	return func(h http.Header) {
+		h.Set("If-Match", strconv.Quote(strconv.Itoa(revision)))
+	}
+}
+
+// Create stores metadata, returning it with its assigned ID and lifecycle state
+func (c *Client) Create(ctx context.Context, metadata *storage.Metadata) (*storage.Metadata, error) {
+	data, err := yaml.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode metadata as YAML: %v", err)
+	}
+	stored := &storage.Metadata{}
+	err = c.doJSON(ctx, http.MethodPost, "/metadata", yamlContentType, data, stored)
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Get returns stored metadata by its ID, whatever its lifecycle state
+func (c *Client) Get(ctx context.Context, id string) (*storage.Metadata, error) {
+	stored := &storage.Metadata{}
+	err := c.doJSON(ctx, http.MethodGet, "/metadata/"+url.PathEscape(id), "", nil, stored)
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Update replaces the attributes of stored metadata, which keeps its ID and lifecycle state
+func (c *Client) Update(ctx context.Context, id string, metadata *storage.Metadata, options ...WriteOption) (*storage.Metadata, error) {
+	data, err := yaml.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode metadata as YAML: %v", err)
+	}
+	stored := &storage.Metadata{}
+	err = c.doJSON(ctx, http.MethodPut, "/metadata/"+url.PathEscape(id), yamlContentType, data, stored, options...)
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Delete removes stored metadata
+func (c *Client) Delete(ctx context.Context, id string, options ...WriteOption) error {
+	return c.doJSON(ctx, http.MethodDelete, "/metadata/"+url.PathEscape(id), "", nil, nil, options...)
+}
+
+// Batch stores several metadata documents in one request. Either every document is stored, or none are and the
+// returned *Error lists the problems with each invalid document.
+func (c *Client) Batch(ctx context.Context, documents []*storage.Metadata) ([]*storage.Metadata, error) {
+	b := &bytes.Buffer{}
+	for i, metadata := range documents {
+		data, err := yaml.Marshal(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode document %d as YAML: %v", i, err)
+		}
+		b.WriteString("---\n")
+		b.Write(data)
+	}
+	stored := []*storage.Metadata{}
+	err := c.doJSON(ctx, http.MethodPost, "/metadata/batch", yamlContentType, b.Bytes(), &stored)
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Search returns the stored metadata matching every attribute of a query
+func (c *Client) Search(ctx context.Context, query *Query) ([]*storage.Metadata, error) {
+	results := []*storage.Metadata{}
+	err := c.doJSON(ctx, http.MethodGet, "/metadata?"+query.values.Encode(), "", nil, &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Versions returns every stored version of an application, lowest first, including yanked versions
+func (c *Client) Versions(ctx context.Context, title string) ([]*storage.Metadata, error) {
+	versions := []*storage.Metadata{}
+	err := c.doJSON(ctx, http.MethodGet, "/apps/"+url.PathEscape(title)+"/versions", "", nil, &versions)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Export returns every stored document, whatever its lifecycle state. It requires credentials with the admin scope.
+func (c *Client) Export(ctx context.Context) ([]*storage.Metadata, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/admin/export", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	documents := []*storage.Metadata{}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		metadata := &storage.Metadata{}
+		err := decoder.Decode(metadata)
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not decode exported document %d: %v", len(documents), err)
+		}
+		documents = append(documents, metadata)
+	}
+}
+
+// Query builds a search for metadata, such as NewQuery().Title("app").License("MIT")
+type Query struct {
+	values url.Values
+}
+
+// NewQuery creates a query that matches all active and deprecated metadata
+func NewQuery() *Query {
+	return &Query{values: url.Values{}}
+}
+
+// Where requires every word to appear in an attribute, such as maintainer_email. Attributes are listed in
+// storage.Attributes.
+func (q *Query) Where(attribute, words string) *Query {
+	q.values.Set(attribute, words)
+	return q
+}
+
+// Title requires every word to appear in the title
+func (q *Query) Title(words string) *Query {
+	return q.Where(string(storage.Title), words)
+}
+
+// Version requires the version to match
+func (q *Query) Version(version string) *Query {
+	return q.Where(string(storage.Version), version)
+}
+
+// MaintainerName requires every word to appear in the name of a maintainer
+func (q *Query) MaintainerName(words string) *Query {
+	return q.Where(string(storage.MaintainerName), words)
+}
+
+// MaintainerEmail requires the email of a maintainer to match
+func (q *Query) MaintainerEmail(email string) *Query {
+	return q.Where(string(storage.MaintainerEmail), email)
+}
+
+// Company requires every word to appear in the company
+func (q *Query) Company(words string) *Query {
+	return q.Where(string(storage.Company), words)
+}
+
+// Website requires every word to appear in the website
+func (q *Query) Website(words string) *Query {
+	return q.Where(string(storage.Website), words)
+}
+
+// Source requires every word to appear in the source
+func (q *Query) Source(words string) *Query {
+	return q.Where(string(storage.Source), words)
+}
+
+// License requires the license to match
+func (q *Query) License(license string) *Query {
+	return q.Where(string(storage.License), license)
+}
+
+// Description requires every word to appear in the description
+func (q *Query) Description(words string) *Query {
+	return q.Where(string(storage.Description), words)
+}
+
+// IncludeYanked includes yanked metadata in the results
+func (q *Query) IncludeYanked() *Query {
+	q.values.Set("include_yanked", "true")
+	return q
+}
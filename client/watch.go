@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/medhir/yaml-api/storage"
+)
+
+// EventReset is the type of event sent when events were missed while reconnecting, after which clients should
+// resynchronize with a search
+const EventReset = "reset"
+
+// Event is a change to stored metadata, received from the event stream
+type Event struct {
+	// ID increases with every change, and is 0 for reset events
+	ID     uint64
+	Type   string
+	Change storage.Change
+}
+
+// WatchOptions filter and resume the event stream
+type WatchOptions struct {
+	// Types restricts the stream to the listed change types, such as create and delete. Every type is streamed
+	// when empty.
+	Types []string
+	// LastEventID resumes the stream after an event that was already received
+	LastEventID uint64
+}
+
+// Watch streams changes to stored metadata, calling handle with each event, until ctx is done or handle returns an
+// error. Dropped connections are resumed after the last event received. Watch returns the error from handle, or
+// the context's error.
+func (c *Client) Watch(ctx context.Context, options WatchOptions, handle func(Event) error) error {
+	lastID := options.LastEventID
+	backoff := c.backoff
+	for {
+		received, err := c.watch(ctx, options.Types, &lastID, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		switch err := err.(type) {
+		case handlerError:
+			return err.err
+		case *Error:
+			// error responses, such as invalid credentials, are not resolved by reconnecting
+			return err
+		}
+		if received {
+			backoff = c.backoff
+		}
+		err = sleep(ctx, backoff)
+		if err != nil {
+			return err
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// handlerError distinguishes errors returned by a Watch handler from errors reading the stream
+type handlerError struct {
+	err error
+}
+
+func (e handlerError) Error() string {
+	return e.err.Error()
+}
+
+// watch reads a single connection to the event stream, reporting whether any events were received before it ended
+func (c *Client) watch(ctx context.Context, types []string, lastID *uint64, handle func(Event) error) (bool, error) {
+	query := url.Values{}
+	if len(types) > 0 {
+		query.Set("types", strings.Join(types, ","))
+	}
+	if *lastID > 0 {
+		query.Set("last_event_id", strconv.FormatUint(*lastID, 10))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/events?"+query.Encode(), "", nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	received := false
+	stream := bufio.NewReader(resp.Body)
+	fields := map[string]string{}
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			return received, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line != "" {
+			// lines starting with a colon are comments, such as heartbeats
+			if !strings.HasPrefix(line, ":") {
+				parts := strings.SplitN(line, ":", 2)
+				value := ""
+				if len(parts) == 2 {
+					value = strings.TrimPrefix(parts[1], " ")
+				}
+				fields[parts[0]] = value
+			}
+			continue
+		}
+		// a blank line dispatches the event, unless only fields such as retry were sent
+		eventType, ok := fields["event"]
+		if !ok {
+			fields = map[string]string{}
+			continue
+		}
+		event := Event{Type: eventType}
+		if eventType != EventReset {
+			event.ID, err = strconv.ParseUint(fields["id"], 10, 64)
+			if err != nil {
+				return received, fmt.Errorf("event has an invalid id %q", fields["id"])
+			}
+			err = json.Unmarshal([]byte(fields["data"]), &event.Change)
+			if err != nil {
+				return received, fmt.Errorf("could not decode event %d: %v", event.ID, err)
+			}
+			*lastID = event.ID
+		}
+		fields = map[string]string{}
+		received = true
+		err = handle(event)
+		if err != nil {
+			return received, handlerError{err: err}
+		}
+	}
+}
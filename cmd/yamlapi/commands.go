@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/medhir/yaml-api/client"
+	"github.com/medhir/yaml-api/storage"
+)
+
+// push stores the documents of each file in a batch, so that a file is either stored entirely or not at all
+func push(cli *cli, args []string) error {
+	fs := cli.flags(true)
+	strict := fs.Bool("strict", false, "reject documents with unknown or duplicate attributes")
+	args, err := cli.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageError("push needs at least one file, directory or glob")
+	}
+	files, err := expandPaths(args)
+	if err != nil {
+		return err
+	}
+	c := cli.client()
+	stored := []*storage.Metadata{}
+	failed := false
+	for _, file := range files {
+		documents, err := readDocuments(file, *strict)
+		if err == nil {
+			documents, err = c.Batch(context.Background(), documents)
+		}
+		if err != nil {
+			fmt.Fprintf(cli.stderr, "%s: ", file)
+			printError(cli.stderr, err)
+			failed = true
+			continue
+		}
+		stored = append(stored, documents...)
+	}
+	if len(stored) > 0 {
+		err = printMetadata(cli.stdout, cli.output, stored, false)
+		if err != nil {
+			return err
+		}
+	}
+	if failed {
+		return errInvalid
+	}
+	return nil
+}
+
+func get(cli *cli, args []string) error {
+	fs := cli.flags(true)
+	args, err := cli.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageError("get needs exactly one ID")
+	}
+	metadata, err := cli.client().Get(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	return printMetadata(cli.stdout, cli.output, []*storage.Metadata{metadata}, true)
+}
+
+// search has a flag for every searchable attribute, named like the attribute with dashes, such as -maintainer-email
+func search(cli *cli, args []string) error {
+	fs := cli.flags(true)
+	attributes := map[string]*string{}
+	for _, attribute := range storage.Attributes {
+		attributes[attribute] = fs.String(strings.Replace(attribute, "_", "-", -1), "", "words that must appear in the "+strings.Replace(attribute, "_", " ", -1))
+	}
+	includeYanked := fs.Bool("include-yanked", false, "include yanked metadata in the results")
+	args, err := cli.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usageError("search takes attributes as flags, such as -title foo")
+	}
+	query := client.NewQuery()
+	for attribute, words := range attributes {
+		if *words != "" {
+			query.Where(attribute, *words)
+		}
+	}
+	if *includeYanked {
+		query.IncludeYanked()
+	}
+	results, err := cli.client().Search(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	return printMetadata(cli.stdout, cli.output, results, false)
+}
+
+// remove deletes each ID, carrying on past failures so that one missing ID does not prevent the others from being
+// deleted
+func remove(cli *cli, args []string) error {
+	fs := cli.flags(true)
+	args, err := cli.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageError("delete needs at least one ID")
+	}
+	c := cli.client()
+	failed := false
+	for _, id := range args {
+		err := c.Delete(context.Background(), id)
+		if err != nil {
+			fmt.Fprintf(cli.stderr, "%s: ", id)
+			printError(cli.stderr, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(cli.stdout, "deleted %s\n", id)
+	}
+	if failed {
+		return errInvalid
+	}
+	return nil
+}
+
+func versions(cli *cli, args []string) error {
+	fs := cli.flags(true)
+	args, err := cli.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usageError("versions needs exactly one application title")
+	}
+	versions, err := cli.client().Versions(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	return printMetadata(cli.stdout, cli.output, versions, false)
+}
+
+func export(cli *cli, args []string) error {
+	fs := cli.flags(true)
+	args, err := cli.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usageError("export takes no arguments")
+	}
+	documents, err := cli.client().Export(context.Background())
+	if err != nil {
+		return err
+	}
+	return printMetadata(cli.stdout, cli.output, documents, false)
+}
+
+// validate checks files without contacting a server. Maintainer email domains are only looked up with -check-mx,
+// which needs DNS.
+func validate(cli *cli, args []string) error {
+	fs := cli.flags(false)
+	strict := fs.Bool("strict", false, "reject documents with unknown or duplicate attributes")
+	checkMX := fs.Bool("check-mx", false, "require the domain of maintainer emails to have a mail server")
+	args, err := cli.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageError("validate needs at least one file, directory or glob")
+	}
+	files, err := expandPaths(args)
+	if err != nil {
+		return err
+	}
+	s := storage.NewStorage(storage.WithMXCheck(*checkMX))
+	failed := false
+	for _, file := range files {
+		documents, err := readDocuments(file, *strict)
+		if err != nil {
+			fmt.Fprintf(cli.stderr, "%s: %v\n", file, err)
+			failed = true
+			continue
+		}
+		valid := true
+		for i, metadata := range documents {
+			err := s.ValidateMetadata(metadata)
+			if err != nil {
+				fmt.Fprintf(cli.stderr, "%s: document %d: %s\n", file, i, describeValidationError(err))
+				valid = false
+			}
+		}
+		if !valid {
+			failed = true
+			continue
+		}
+		fmt.Fprintf(cli.stdout, "%s: ok\n", file)
+	}
+	if failed {
+		return errInvalid
+	}
+	return nil
+}
+
+func describeValidationError(err error) string {
+	validationErr, ok := err.(*storage.ValidationError)
+	if !ok {
+		return err.Error()
+	}
+	if validationErr.Index >= 0 {
+		return fmt.Sprintf("%s[%d]: %v", validationErr.Field, validationErr.Index, err)
+	}
+	return fmt.Sprintf("%s: %v", validationErr.Field, err)
+}
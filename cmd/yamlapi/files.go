@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/medhir/yaml-api/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// expandPaths resolves files, directories and glob patterns into the YAML files they name. Directories are walked
+// recursively for .yaml and .yml files.
+func expandPaths(args []string) ([]string, error) {
+	files := []string{}
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %v", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", arg)
+			}
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				files = append(files, match)
+				continue
+			}
+			err = filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() && isYAMLFile(path) {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// readDocuments decodes every metadata document in a YAML file, separated by ---. With strict enabled, unknown and
+// duplicate attributes are rejected, as they are by servers with strict YAML enabled.
+func readDocuments(path string, strict bool) ([]*storage.Metadata, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.SetStrict(strict)
+	documents := []*storage.Metadata{}
+	for {
+		metadata := &storage.Metadata{}
+		err := decoder.Decode(metadata)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", len(documents), err)
+		}
+		documents = append(documents, metadata)
+	}
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("no metadata documents found")
+	}
+	return documents, nil
+}
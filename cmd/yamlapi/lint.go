@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/medhir/yaml-api/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// spdxLicenses lists common SPDX license identifiers in their canonical case, by their lowercase identifier
+var spdxLicenses = map[string]string{}
+
+func init() {
+	for _, id := range []string{
+		"0BSD", "AFL-3.0", "AGPL-3.0-only", "AGPL-3.0-or-later", "Apache-1.1", "Apache-2.0", "Artistic-2.0",
+		"BSD-1-Clause", "BSD-2-Clause", "BSD-3-Clause", "BSD-3-Clause-Clear", "BSD-4-Clause", "BSL-1.0",
+		"CC-BY-4.0", "CC-BY-SA-4.0", "CC0-1.0", "CDDL-1.0", "CDDL-1.1", "ECL-2.0", "EPL-1.0", "EPL-2.0",
+		"EUPL-1.1", "EUPL-1.2", "GPL-2.0-only", "GPL-2.0-or-later", "GPL-3.0-only", "GPL-3.0-or-later", "ISC",
+		"LGPL-2.0-only", "LGPL-2.0-or-later", "LGPL-2.1-only", "LGPL-2.1-or-later", "LGPL-3.0-only",
+		"LGPL-3.0-or-later", "LPPL-1.3c", "MIT", "MIT-0", "MPL-1.1", "MPL-2.0", "MS-PL", "MS-RL", "MulanPSL-2.0",
+		"NCSA", "ODbL-1.0", "OFL-1.1", "OSL-3.0", "PostgreSQL", "Python-2.0", "UPL-1.0", "Unlicense", "Vim",
+		"WTFPL", "Zlib",
+	} {
+		spdxLicenses[strings.ToLower(id)] = id
+	}
+}
+
+// metadataAttributes and maintainerAttributes list the attributes that strict decoding allows in submitted metadata
+// and maintainers
+var (
+	metadataAttributes   = yamlAttributes(storage.Metadata{})
+	maintainerAttributes = yamlAttributes(storage.Maintainer{})
+)
+
+// diagnostic is a problem found in a file, at the line and column of the YAML node it concerns
+type diagnostic struct {
+	line    int
+	column  int
+	message string
+}
+
+// fix replaces the value of a single line scalar, to normalize it
+type fix struct {
+	node  *yaml.Node
+	value string
+}
+
+// lint validates files offline like validate, but decodes them strictly and reports every problem at its position
+// in the file. With -fix, whitespace around values, the case of SPDX license identifiers and the case of maintainer
+// emails are normalized in place.
+func lint(cli *cli, args []string) error {
+	fs := cli.flags(false)
+	fixFiles := fs.Bool("fix", false, "rewrite files with whitespace, license and email case normalized")
+	checkMX := fs.Bool("check-mx", false, "require the domain of maintainer emails to have a mail server")
+	args, err := cli.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usageError("lint needs at least one file, directory or glob")
+	}
+	files, err := expandPaths(args)
+	if err != nil {
+		return err
+	}
+	s := storage.NewStorage(storage.WithMXCheck(*checkMX))
+	failed := false
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		diagnostics, fixes := lintDocuments(s, data)
+		if *fixFiles && len(fixes) > 0 {
+			fixed, applied, err := applyFixes(data, fixes)
+			if err != nil {
+				return fmt.Errorf("could not fix %s: %v", file, err)
+			}
+			if applied > 0 {
+				err = writeFile(file, fixed)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(cli.stdout, "%s: fixed %d problems\n", file, applied)
+				diagnostics, _ = lintDocuments(s, fixed)
+			}
+		}
+		for _, d := range diagnostics {
+			fmt.Fprintf(cli.stderr, "%s:%d:%d: %s\n", file, d.line, d.column, d.message)
+		}
+		if len(diagnostics) > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		return errInvalid
+	}
+	return nil
+}
+
+var errorLine = regexp.MustCompile(`line (\d+)`)
+
+// lintDocuments returns the problems found in every document of a YAML file, and the fixes for those that can be
+// normalized
+func lintDocuments(s *storage.Storage, data []byte) ([]diagnostic, []fix) {
+	diagnostics := []diagnostic{}
+	fixes := []fix{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	documents := 0
+	for {
+		document := &yaml.Node{}
+		err := decoder.Decode(document)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// syntax errors only carry a line number in their message
+			line := 1
+			if match := errorLine.FindStringSubmatch(err.Error()); match != nil {
+				line, _ = strconv.Atoi(match[1])
+			}
+			diagnostics = append(diagnostics, diagnostic{line, 1, err.Error()})
+			break
+		}
+		documents++
+		d, f := lintDocument(s, document)
+		diagnostics = append(diagnostics, d...)
+		fixes = append(fixes, f...)
+	}
+	if documents == 0 && len(diagnostics) == 0 {
+		diagnostics = append(diagnostics, diagnostic{1, 1, "no metadata documents found"})
+	}
+	return diagnostics, fixes
+}
+
+func lintDocument(s *storage.Storage, document *yaml.Node) ([]diagnostic, []fix) {
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return []diagnostic{at(root, "document must be a mapping of metadata attributes")}, nil
+	}
+	diagnostics, duplicates := checkAttributes(root, metadataAttributes, "")
+	fixes := []fix{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		if key == "maintainers" && value.Kind == yaml.SequenceNode {
+			for _, maintainer := range value.Content {
+				if maintainer.Kind != yaml.MappingNode {
+					continue
+				}
+				d, duplicate := checkAttributes(maintainer, maintainerAttributes, "maintainer ")
+				diagnostics = append(diagnostics, d...)
+				duplicates = duplicates || duplicate
+				if email := mappingValue(maintainer, "email"); email != nil {
+					fixes = append(fixes, normalize(email, strings.ToLower(strings.TrimSpace(email.Value)))...)
+				}
+				if name := mappingValue(maintainer, "name"); name != nil {
+					fixes = append(fixes, normalize(name, strings.TrimSpace(name.Value))...)
+				}
+			}
+			continue
+		}
+		if value.Kind != yaml.ScalarNode {
+			continue
+		}
+		normalized := strings.TrimSpace(value.Value)
+		if key == "license" {
+			if canonical, ok := spdxLicenses[strings.ToLower(normalized)]; ok {
+				normalized = canonical
+			}
+		}
+		fixes = append(fixes, normalize(value, normalized)...)
+	}
+	for _, f := range fixes {
+		diagnostics = append(diagnostics, at(f.node, fmt.Sprintf("%q should be written %q", f.node.Value, f.value)))
+	}
+
+	// duplicate attributes make decoding fail, so the document is only validated once they are resolved
+	if duplicates {
+		return sortDiagnostics(diagnostics), fixes
+	}
+	metadata := &storage.Metadata{}
+	err := root.Decode(metadata)
+	if err != nil {
+		diagnostics = append(diagnostics, at(root, err.Error()))
+		return sortDiagnostics(diagnostics), fixes
+	}
+	err = s.ValidateMetadata(metadata)
+	if err != nil {
+		diagnostics = append(diagnostics, at(invalidNode(root, err), err.Error()))
+	}
+	return sortDiagnostics(diagnostics), fixes
+}
+
+// checkAttributes reports unknown and duplicate attributes of a mapping, as strict YAML decoding rejects them, and
+// whether any were duplicated
+func checkAttributes(mapping *yaml.Node, allowed map[string]bool, kind string) ([]diagnostic, bool) {
+	diagnostics := []diagnostic{}
+	duplicates := false
+	seen := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i]
+		if first, ok := seen[key.Value]; ok {
+			diagnostics = append(diagnostics, at(key, fmt.Sprintf("duplicate %sattribute %s, first set at line %d", kind, key.Value, first.Line)))
+			duplicates = true
+			continue
+		}
+		seen[key.Value] = key
+		if !allowed[key.Value] {
+			diagnostics = append(diagnostics, at(key, fmt.Sprintf("unknown %sattribute %s", kind, key.Value)))
+		}
+	}
+	return diagnostics, duplicates
+}
+
+// normalize returns a fix if a scalar is not already normalized. Fixes are only offered for values written on a
+// single line, which can be replaced without reformatting the file.
+func normalize(node *yaml.Node, value string) []fix {
+	if node.Kind != yaml.ScalarNode || node.Value == value || node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		return nil
+	}
+	return []fix{{node: node, value: value}}
+}
+
+// invalidNode finds the node holding the attribute a validation error is about, falling back to its parent when
+// the attribute is missing
+func invalidNode(root *yaml.Node, err error) *yaml.Node {
+	validationErr, ok := err.(*storage.ValidationError)
+	if !ok {
+		return root
+	}
+	path := strings.Split(validationErr.Field, ".")
+	node := mappingValue(root, path[0])
+	if node == nil {
+		return root
+	}
+	if len(path) == 1 || validationErr.Index < 0 || node.Kind != yaml.SequenceNode || validationErr.Index >= len(node.Content) {
+		return node
+	}
+	maintainer := node.Content[validationErr.Index]
+	if value := mappingValue(maintainer, path[1]); value != nil {
+		return value
+	}
+	return maintainer
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func at(node *yaml.Node, message string) diagnostic {
+	return diagnostic{line: node.Line, column: node.Column, message: message}
+}
+
+func sortDiagnostics(diagnostics []diagnostic) []diagnostic {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].line != diagnostics[j].line {
+			return diagnostics[i].line < diagnostics[j].line
+		}
+		return diagnostics[i].column < diagnostics[j].column
+	})
+	return diagnostics
+}
+
+// applyFixes replaces the scalars of fixes in the source of a file, leaving the rest of it untouched, and returns
+// how many were applied. Fixes are applied from the end of each line, so that earlier columns stay valid.
+func applyFixes(data []byte, fixes []fix) ([]byte, int, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+	applied := 0
+	sort.SliceStable(fixes, func(i, j int) bool {
+		if fixes[i].node.Line != fixes[j].node.Line {
+			return fixes[i].node.Line < fixes[j].node.Line
+		}
+		return fixes[i].node.Column > fixes[j].node.Column
+	})
+	for _, f := range fixes {
+		if f.node.Line < 1 || f.node.Line > len(lines) {
+			return nil, 0, fmt.Errorf("line %d is out of range", f.node.Line)
+		}
+		line := lines[f.node.Line-1]
+		start := byteOffset(line, f.node.Column-1)
+		end, ok := scalarEnd(line, start, f.node)
+		if !ok {
+			// the value spans several lines, or is written in a way that cannot safely be replaced in place
+			continue
+		}
+		replacement, err := encodeScalar(f.node.Style, f.value)
+		if err != nil {
+			return nil, 0, err
+		}
+		lines[f.node.Line-1] = line[:start] + replacement + line[end:]
+		applied++
+	}
+	return []byte(strings.Join(lines, "")), applied, nil
+}
+
+// scalarEnd finds where the scalar starting at start ends on a line, checking that the text in between decodes to
+// the scalar's value
+func scalarEnd(line string, start int, node *yaml.Node) (int, bool) {
+	if start >= len(line) {
+		return 0, false
+	}
+	rest := line[start:]
+	end := 0
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] == '\\' {
+				i++
+				continue
+			}
+			if rest[i] == '"' {
+				end = i + 1
+				break
+			}
+		}
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] == '\'' {
+				if i+1 < len(rest) && rest[i+1] == '\'' {
+					i++
+					continue
+				}
+				end = i + 1
+				break
+			}
+		}
+	default:
+		end = len(strings.TrimRight(rest, "\r\n"))
+		if comment := strings.Index(rest[:end], " #"); comment >= 0 {
+			end = comment
+		}
+		end = len(strings.TrimRight(rest[:end], " \t"))
+	}
+	if end == 0 {
+		return 0, false
+	}
+	var decoded string
+	err := yaml.Unmarshal([]byte(rest[:end]), &decoded)
+	if err != nil || decoded != node.Value {
+		return 0, false
+	}
+	return start + end, true
+}
+
+// encodeScalar writes a value in the style of the scalar it replaces, quoting plain values that need it
+func encodeScalar(style yaml.Style, value string) (string, error) {
+	data, err := yaml.Marshal(&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: style, Value: value})
+	if err != nil {
+		return "", err
+	}
+	encoded := strings.TrimSuffix(string(data), "\n")
+	if strings.Contains(encoded, "\n") {
+		return "", fmt.Errorf("%q cannot be written on a single line", value)
+	}
+	return encoded, nil
+}
+
+// byteOffset converts a column counted in characters, as YAML positions are, into an offset in bytes
+func byteOffset(line string, column int) int {
+	offset := 0
+	for i := 0; i < column && offset < len(line); i++ {
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// writeFile replaces the content of a file, keeping its permissions
+func writeFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, info.Mode().Perm())
+}
+
+// yamlAttributes returns the YAML names of the attributes of a struct, leaving out those tagged with "-" which
+// cannot be submitted
+func yamlAttributes(v interface{}) map[string]bool {
+	attributes := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			attributes[name] = true
+		}
+	}
+	return attributes
+}
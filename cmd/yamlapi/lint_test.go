@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lint(t *testing.T) {
+	lint := func(args ...string) (int, string, string) {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(append([]string{"lint"}, args...), stdout, stderr, func(string) (string, bool) { return "", false })
+		return code, stdout.String(), stderr.String()
+	}
+
+	dir, err := ioutil.TempDir("", "lint")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{
+			name: "Lint passes for valid files",
+			args: []string{"testdata/apps"},
+		},
+		{
+			name: "Lint fails with the position of every problem",
+			args: []string{"testdata/lint/messy.yaml"},
+			code: 1,
+			stderr: "testdata/lint/messy.yaml:1:8: \"  Messy app \" should be written \"Messy app\"\n" +
+				"testdata/lint/messy.yaml:5:10: \"Bill@Gmail.com\" should be written \"bill@gmail.com\"\n" +
+				"testdata/lint/messy.yaml:6:3: unknown maintainer attribute phone\n" +
+				"testdata/lint/messy.yaml:10:10: \"apache-2.0\" should be written \"Apache-2.0\"\n" +
+				"testdata/lint/messy.yaml:16:1: duplicate attribute title, first set at line 14\n" +
+				"testdata/lint/messy.yaml:19:10: version must follow the semantic versioning scheme: https://semver.org\n" +
+				"testdata/lint/messy.yaml:24:10: \"mit\" should be written \"MIT\"\n",
+		},
+		{
+			name:   "Lint fails for missing attributes at the position of their parent",
+			args:   []string{"testdata/lint/missing.yaml"},
+			code:   1,
+			stderr: "testdata/lint/missing.yaml:4:3: maintainer must have an email\n",
+		},
+		{
+			name:   "Lint fails for invalid YAML",
+			args:   []string{"testdata/lint/syntax.yaml"},
+			code:   1,
+			stderr: "testdata/lint/syntax.yaml:1:1: yaml: line 1: did not find expected ',' or ']'\n",
+		},
+		{
+			name:   "Lint fails for documents that are not mappings",
+			args:   []string{"testdata/lint/list.yaml"},
+			code:   1,
+			stderr: "testdata/lint/list.yaml:1:1: document must be a mapping of metadata attributes\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := lint(tt.args...)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.stderr, stderr)
+		})
+	}
+
+	t.Run("Fixes normalize values in place, leaving other problems", func(t *testing.T) {
+		original, err := ioutil.ReadFile("testdata/lint/messy.yaml")
+		assert.NoError(t, err)
+		file := filepath.Join(dir, "messy.yaml")
+		assert.NoError(t, ioutil.WriteFile(file, original, 0644))
+
+		code, stdout, stderr := lint("-fix", file)
+		assert.Equal(t, 1, code)
+		assert.Equal(t, file+": fixed 4 problems\n", stdout)
+		assert.Equal(t, file+":6:3: unknown maintainer attribute phone\n"+
+			file+":16:1: duplicate attribute title, first set at line 14\n"+
+			file+":19:10: version must follow the semantic versioning scheme: https://semver.org\n", stderr)
+		fixed, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		expected, err := ioutil.ReadFile("testdata/lint/messy.fixed.yaml")
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), string(fixed))
+	})
+
+	t.Run("Fixes leave values they cannot safely replace", func(t *testing.T) {
+		file := filepath.Join(dir, "flow.yaml")
+		flow := "title: App\nmaintainers: [{name: Jane, email: Jane@gmail.com}]\n"
+		assert.NoError(t, ioutil.WriteFile(file, []byte(flow), 0644))
+
+		code, stdout, _ := lint("-fix", file)
+		assert.Equal(t, 1, code)
+		assert.Empty(t, stdout)
+		unchanged, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, flow, string(unchanged))
+	})
+}
@@ -0,0 +1,220 @@
+// Command yamlapi pushes, searches and validates metadata from the command line, using the YAML API client
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/medhir/yaml-api/client"
+)
+
+const defaultServer = "http://localhost:1111"
+
+// command is a subcommand, such as push or search
+type command struct {
+	usage   string
+	summary string
+	run     func(cli *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"push":     {"push [flags] <file|directory|glob>...", "store every metadata document in YAML files", push},
+	"get":      {"get [flags] <id>", "print stored metadata by its ID", get},
+	"search":   {"search [flags]", "search metadata by attribute, such as -license MIT -title foo", search},
+	"delete":   {"delete [flags] <id>...", "delete stored metadata", remove},
+	"lint":     {"lint [flags] <file|directory|glob>...", "check YAML files offline, reporting every problem with its position", lint},
+	"versions": {"versions [flags] <app>", "list every version of an application, lowest first", versions},
+	"export":   {"export [flags]", "print every stored document, which requires the admin scope", export},
+	"validate": {"validate [flags] <file|directory|glob>...", "validate YAML files offline, with the rules the server applies", validate},
+}
+
+var (
+	// errInvalid is returned by commands that already described why they failed, so that nothing more is printed
+	errInvalid = errors.New("invalid")
+	// errFlags is returned for flags that could not be parsed, which the flag package has already described
+	errFlags = errors.New("invalid flags")
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, os.LookupEnv))
+}
+
+// run executes the subcommand named by the first argument, and returns the exit code
+func run(args []string, stdout, stderr io.Writer, lookupEnv func(string) (string, bool)) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		printUsage(stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+	cli := &cli{name: args[0], usage: cmd.usage, stdout: stdout, stderr: stderr, lookupEnv: lookupEnv}
+	err := cmd.run(cli, args[1:])
+	switch {
+	case err == nil:
+		return 0
+	case err == flag.ErrHelp:
+		return 0
+	case err == errInvalid:
+		return 1
+	case err == errFlags:
+		return 2
+	case isUsageError(err):
+		fmt.Fprintf(stderr, "%v\nusage: yamlapi %s\n", err, cmd.usage)
+		return 2
+	}
+	printError(stderr, err)
+	return 1
+}
+
+func printUsage(w io.Writer) {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage: yamlapi <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-9s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w, "\nRun yamlapi <command> -h for the flags of a command.")
+}
+
+// usageError is returned when a command is called with the wrong arguments
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func isUsageError(err error) bool {
+	_, ok := err.(usageError)
+	return ok
+}
+
+// printError describes a failed request, including every problem reported for the documents of a batch
+func printError(w io.Writer, err error) {
+	apiErr, ok := err.(*client.Error)
+	if !ok {
+		fmt.Fprintf(w, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "error: %s\n", describeProblem(apiErr))
+	for _, problem := range apiErr.Errors {
+		fmt.Fprintf(w, "  %s\n", describeProblem(problem))
+	}
+}
+
+func describeProblem(e *client.Error) string {
+	b := &strings.Builder{}
+	if e.Document != nil {
+		fmt.Fprintf(b, "document %d: ", *e.Document)
+	}
+	if e.Field != "" {
+		b.WriteString(e.Field)
+		if e.Maintainer != nil {
+			fmt.Fprintf(b, "[%d]", *e.Maintainer)
+		}
+		b.WriteString(": ")
+	}
+	if e.Detail != "" {
+		b.WriteString(e.Detail)
+	} else if e.StatusCode != 0 {
+		fmt.Fprintf(b, "%d %s", e.StatusCode, e.Title)
+	}
+	return b.String()
+}
+
+// cli holds the state shared by every command
+type cli struct {
+	name      string
+	usage     string
+	stdout    io.Writer
+	stderr    io.Writer
+	lookupEnv func(string) (string, bool)
+
+	server string
+	apiKey string
+	token  string
+	output string
+}
+
+// flags creates the flag set of the command. With connect, the flags choosing the server and credentials are
+// added, defaulting to the YAML_API_URL, YAML_API_KEY and YAML_API_TOKEN environment variables.
+func (c *cli) flags(connect bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: yamlapi %s\n", c.usage)
+		fs.PrintDefaults()
+	}
+	if connect {
+		fs.StringVar(&c.server, "server", c.env("URL", defaultServer), "base URL of the API (YAML_API_URL)")
+		fs.StringVar(&c.apiKey, "api-key", c.env("KEY", ""), "API key to authenticate with (YAML_API_KEY)")
+		fs.StringVar(&c.token, "token", c.env("TOKEN", ""), "bearer token to authenticate with (YAML_API_TOKEN)")
+		fs.StringVar(&c.output, "o", "table", "output format, one of table, json or yaml")
+	}
+	return fs
+}
+
+func (c *cli) env(name, fallback string) string {
+	value, ok := c.lookupEnv("YAML_API_" + name)
+	if !ok {
+		return fallback
+	}
+	return value
+}
+
+// parse parses flags wherever they appear among the arguments, so that yamlapi get <id> -o json works, and returns
+// the other arguments
+func (c *cli) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		err := fs.Parse(args)
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errFlags
+		}
+		remaining := fs.Args()
+		if len(remaining) == 0 {
+			break
+		}
+		// arguments after -- are never flags, and Parse drops the -- itself
+		if parsed := len(args) - len(remaining); parsed > 0 && args[parsed-1] == "--" {
+			positional = append(positional, remaining...)
+			break
+		}
+		positional = append(positional, remaining[0])
+		args = remaining[1:]
+	}
+	if c.output != "" && c.output != "table" && c.output != "json" && c.output != "yaml" {
+		return nil, usageError(fmt.Sprintf("unknown output format %q", c.output))
+	}
+	return positional, nil
+}
+
+// client creates an API client from the server and credential flags
+func (c *cli) client() *client.Client {
+	options := []client.Option{}
+	if c.apiKey != "" {
+		options = append(options, client.WithAPIKey(c.apiKey))
+	}
+	if c.token != "" {
+		options = append(options, client.WithBearerToken(c.token))
+	}
+	return client.New(c.server, options...)
+}
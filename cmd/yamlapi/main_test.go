@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/medhir/yaml-api/logging"
+	"github.com/medhir/yaml-api/server"
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+// yamlapi runs the command against a server, returning its exit code, standard output and standard error
+type yamlapi func(args ...string) (int, string, string)
+
+func newTestCLI(t *testing.T) yamlapi {
+	s := server.NewServer("127.0.0.1:0",
+		server.WithLogger(logging.New(ioutil.Discard, logging.LevelError)),
+		// maintainer emails are not looked up, so tests do not depend on DNS
+		server.WithStorage(storage.NewStorage(storage.WithMXCheck(false))),
+	)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	env := map[string]string{"YAML_API_URL": ts.URL}
+	return func(args ...string) (int, string, string) {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(args, stdout, stderr, func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		})
+		return code, stdout.String(), stderr.String()
+	}
+}
+
+func Test_run(t *testing.T) {
+	cli := newTestCLI(t)
+
+	var pushed []*storage.Metadata
+	t.Run("Files, directories and globs are pushed", func(t *testing.T) {
+		code, stdout, stderr := cli("push", "testdata/apps", "testdata/apps/*.yaml", "-o", "json")
+		assert.Equal(t, 0, code, stderr)
+		assert.NoError(t, json.Unmarshal([]byte(stdout), &pushed))
+		versions := []string{}
+		for _, metadata := range pushed {
+			versions = append(versions, metadata.Version)
+		}
+		// the directory holds app.yaml and nested/other.yml, and the glob matches app.yaml again
+		assert.Equal(t, []string{"1.0.0", "1.1.0", "2.0.0", "1.0.0", "1.1.0"}, versions)
+	})
+
+	t.Run("Metadata is printed as a table by default", func(t *testing.T) {
+		code, stdout, _ := cli("get", pushed[2].ID)
+		assert.Equal(t, 0, code)
+		assert.Contains(t, stdout, "ID")
+		assert.Contains(t, stdout, pushed[2].ID)
+		assert.Contains(t, stdout, "Other CLI app")
+		assert.Contains(t, stdout, "active")
+	})
+
+	t.Run("Metadata printed as YAML can be pushed again", func(t *testing.T) {
+		code, stdout, _ := cli("get", pushed[2].ID, "-o", "yaml")
+		assert.Equal(t, 0, code)
+		metadata := &storage.Metadata{}
+		assert.NoError(t, yaml.UnmarshalStrict([]byte(stdout), metadata))
+		assert.Equal(t, "Other CLI app", metadata.Title)
+		assert.Empty(t, metadata.ID)
+	})
+
+	t.Run("Searches use attribute flags", func(t *testing.T) {
+		code, stdout, _ := cli("search", "-license", "Apache-2.0", "-title", "other", "-o", "json")
+		assert.Equal(t, 0, code)
+		results := []*storage.Metadata{}
+		assert.NoError(t, json.Unmarshal([]byte(stdout), &results))
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, pushed[2].ID, results[0].ID)
+		}
+	})
+
+	t.Run("Versions are listed lowest first", func(t *testing.T) {
+		code, stdout, _ := cli("versions", "-o", "json", "CLI app")
+		assert.Equal(t, 0, code)
+		versions := []*storage.Metadata{}
+		assert.NoError(t, json.Unmarshal([]byte(stdout), &versions))
+		assert.Len(t, versions, 4)
+		assert.Equal(t, "1.0.0", versions[0].Version)
+		assert.Equal(t, "1.1.0", versions[3].Version)
+	})
+
+	t.Run("Every document is exported", func(t *testing.T) {
+		code, stdout, _ := cli("export", "-o", "json")
+		assert.Equal(t, 0, code)
+		exported := []*storage.Metadata{}
+		assert.NoError(t, json.Unmarshal([]byte(stdout), &exported))
+		assert.Len(t, exported, len(pushed))
+	})
+
+	t.Run("Metadata is deleted, and missing IDs are reported", func(t *testing.T) {
+		code, stdout, stderr := cli("delete", pushed[0].ID, "missing")
+		assert.Equal(t, 1, code)
+		assert.Equal(t, "deleted "+pushed[0].ID+"\n", stdout)
+		assert.Contains(t, stderr, "missing: error: ")
+		code, _, _ = cli("get", pushed[0].ID)
+		assert.Equal(t, 1, code)
+	})
+
+	t.Run("Push fails with every problem of an invalid file, storing none of its documents", func(t *testing.T) {
+		code, _, stderr := cli("push", "testdata/invalid.yaml")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "testdata/invalid.yaml: error: ")
+		assert.Contains(t, stderr, "document 0: version: ")
+		assert.Contains(t, stderr, "document 1: maintainers.email[0]: maintainer must have an email")
+		_, stdout, _ := cli("search", "-title", "invalid")
+		assert.NotContains(t, stdout, "Invalid CLI app")
+	})
+
+	t.Run("Usage errors exit with 2", func(t *testing.T) {
+		for _, args := range [][]string{{}, {"unknown"}, {"get"}, {"search", "-o", "xml"}, {"search", "-unknown"}} {
+			code, _, _ := cli(args...)
+			assert.Equal(t, 2, code, "%v", args)
+		}
+	})
+}
+
+func Test_validate(t *testing.T) {
+	// validation needs no server
+	validate := func(args ...string) (int, string, string) {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(append([]string{"validate"}, args...), stdout, stderr, func(string) (string, bool) { return "", false })
+		return code, stdout.String(), stderr.String()
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "Validation passes for valid files",
+			args:   []string{"testdata/apps"},
+			stdout: "testdata/apps/app.yaml: ok\ntestdata/apps/nested/other.yml: ok\n",
+		},
+		{
+			name: "Validation fails with every invalid document",
+			args: []string{"testdata/invalid.yaml"},
+			code: 1,
+			stderr: "testdata/invalid.yaml: document 0: version: version must follow the semantic versioning scheme: https://semver.org\n" +
+				"testdata/invalid.yaml: document 1: maintainers.email[0]: maintainer must have an email\n",
+		},
+		{
+			name:   "Unknown attributes are ignored by default",
+			args:   []string{"testdata/unknown.yaml"},
+			stdout: "testdata/unknown.yaml: ok\n",
+		},
+		{
+			name:   "Validation fails for unknown attributes when strict",
+			args:   []string{"-strict", "testdata/unknown.yaml"},
+			code:   1,
+			stderr: "testdata/unknown.yaml: document 0: yaml: unmarshal errors:\n  line 11: field licence not found in type storage.Metadata\n",
+		},
+		{
+			name:   "Validation fails when a glob matches nothing",
+			args:   []string{"testdata/*.json"},
+			code:   1,
+			stderr: "error: no files match testdata/*.json\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := validate(tt.args...)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.stdout, stdout)
+			assert.Equal(t, tt.stderr, stderr)
+		})
+	}
+}
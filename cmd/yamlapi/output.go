@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/medhir/yaml-api/storage"
+	"gopkg.in/yaml.v2"
+)
+
+// printMetadata writes metadata in the chosen output format. Tables summarize each document on a line, JSON includes
+// the attributes managed by the API such as the ID and state, and YAML is the format metadata is submitted in, so
+// that it can be edited and pushed again. A single document is printed as an object rather than a list.
+func printMetadata(w io.Writer, format string, documents []*storage.Metadata, single bool) error {
+	switch format {
+	case "json":
+		var v interface{} = documents
+		if single {
+			v = documents[0]
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case "yaml":
+		for i, metadata := range documents {
+			if i > 0 {
+				fmt.Fprintln(w, "---")
+			}
+			data, err := yaml.Marshal(metadata)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tVERSION\tSTATE\tLICENSE")
+	for _, metadata := range documents {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", metadata.ID, metadata.Title, metadata.Version, metadata.State, metadata.License)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/logging"
+	"github.com/medhir/yaml-api/storage"
+)
+
+// Config is the configuration of the server. It is read from a YAML file, environment variables and command line
+// flags, which take precedence over each other in reverse order.
+type Config struct {
+	// Addr is the address to accept http traffic on
+	Addr       string     `yaml:"addr"`
+	TLS        TLS        `yaml:"tls"`
+	Log        Log        `yaml:"log"`
+	Auth       Auth       `yaml:"auth"`
+	Validation Validation `yaml:"validation"`
+	Analyzer   Analyzer   `yaml:"analyzer"`
+	Limits     Limits     `yaml:"limits"`
+	Timeouts   Timeouts   `yaml:"timeouts"`
+}
+
+// TLS configures serving HTTPS, and authenticating clients with mutual TLS
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile holds the CAs trusted to issue client certificates
+	ClientCAFile string `yaml:"client_ca_file"`
+	// RequireClientCert rejects connections without a verified client certificate
+	RequireClientCert bool `yaml:"require_client_cert"`
+	// ClientScopes grants scopes to client certificates by common name or subject alternative name
+	ClientScopes map[string][]string `yaml:"client_scopes"`
+	// ClientDefaultScopes are granted to every client with a verified certificate
+	ClientDefaultScopes []string `yaml:"client_default_scopes"`
+}
+
+// Log configures where entries are logged
+type Log struct {
+	// Level is one of debug, info, warn or error
+	Level string `yaml:"level"`
+	// File is appended to, instead of logging to standard output
+	File string `yaml:"file"`
+}
+
+// Auth configures how requests are authenticated. Every request is allowed when neither API keys nor bearer
+// tokens are configured.
+type Auth struct {
+	// KeysFile is a YAML file of hashed API keys
+	KeysFile string `yaml:"keys_file"`
+	// AnonymousReads allows requests without credentials to read metadata
+	AnonymousReads bool `yaml:"anonymous_reads"`
+	JWT            JWT  `yaml:"jwt"`
+}
+
+// JWT configures how bearer tokens are verified
+type JWT struct {
+	// JWKSFile holds the public keys used to verify RS256 and ES256 tokens
+	JWKSFile string `yaml:"jwks_file"`
+	// SecretFile holds the shared secret used to verify HS256 tokens
+	SecretFile  string `yaml:"secret_file"`
+	Issuer      string `yaml:"issuer"`
+	Audience    string `yaml:"audience"`
+	EmailClaim  string `yaml:"email_claim"`
+	GroupsClaim string `yaml:"groups_claim"`
+	// GroupScopes grants scopes to the groups of a token's principal
+	GroupScopes map[string][]string `yaml:"group_scopes"`
+}
+
+// Validation configures how strictly submitted metadata is checked
+type Validation struct {
+	// StrictYAML rejects metadata with unknown or duplicate attributes
+	StrictYAML bool `yaml:"strict_yaml"`
+	// CheckMX requires the domain of maintainer emails to have a mail server, which requires DNS
+	CheckMX bool `yaml:"check_mx"`
+}
+
+// Analyzer configures how text is split into the terms that are indexed and searched
+type Analyzer struct {
+	Language  string `yaml:"language"`
+	Stemming  bool   `yaml:"stemming"`
+	StopWords bool   `yaml:"stop_words"`
+}
+
+// Limits bound the resources used by the server
+type Limits struct {
+	// EventBuffer is the number of change events kept for clients resuming the event stream
+	EventBuffer int `yaml:"event_buffer"`
+	// WebhookWorkers is the number of webhook deliveries attempted concurrently
+	WebhookWorkers int `yaml:"webhook_workers"`
+	// WebhookMaxAttempts is the number of times a webhook delivery is attempted before it is dead-lettered
+	WebhookMaxAttempts int `yaml:"webhook_max_attempts"`
+	// WebhookAllowInternal allows webhooks to loopback, link-local and private addresses
+	WebhookAllowInternal bool `yaml:"webhook_allow_internal"`
+	// MaxBodyBytes is the largest request body accepted, and MaxImportBytes the largest import
+	MaxBodyBytes   int `yaml:"max_body_bytes"`
+	MaxImportBytes int `yaml:"max_import_bytes"`
+	// ReadRate limits the requests each principal, or client address, makes to read routes, in requests per
+	// minute, and ReadBurst is the number allowed at once. Zero rates allow every request.
+	ReadRate  int `yaml:"read_rate"`
+	ReadBurst int `yaml:"read_burst"`
+	// WriteRate and WriteBurst limit requests to write and admin routes in the same way
+	WriteRate  int `yaml:"write_rate"`
+	WriteBurst int `yaml:"write_burst"`
+}
+
+// Timeouts limit how long the server waits on clients, and on itself when shutting down
+type Timeouts struct {
+	ReadHeader time.Duration `yaml:"read_header"`
+	Read       time.Duration `yaml:"read"`
+	Write      time.Duration `yaml:"write"`
+	Idle       time.Duration `yaml:"idle"`
+	Shutdown   time.Duration `yaml:"shutdown"`
+	// DrainDelay is how long readiness fails before a graceful shutdown stops accepting connections
+	DrainDelay time.Duration `yaml:"drain_delay"`
+}
+
+// Default returns the configuration used for anything that is not configured
+func Default() *Config {
+	return &Config{
+		Addr: ":1111",
+		Log:  Log{Level: "info"},
+		Auth: Auth{
+			AnonymousReads: true,
+			JWT:            JWT{EmailClaim: "email", GroupsClaim: "groups"},
+		},
+		Validation: Validation{CheckMX: true},
+		Analyzer: Analyzer{
+			Language:  storage.DefaultAnalyzer.Language,
+			Stemming:  storage.DefaultAnalyzer.Stemming,
+			StopWords: storage.DefaultAnalyzer.StopWords,
+		},
+		Limits: Limits{
+			EventBuffer:        1024,
+			WebhookWorkers:     4,
+			WebhookMaxAttempts: 5,
+			MaxBodyBytes:       10 << 20,
+			MaxImportBytes:     512 << 20,
+			ReadRate:           1200,
+			ReadBurst:          100,
+			WriteRate:          120,
+			WriteBurst:         30,
+		},
+		Timeouts: Timeouts{
+			ReadHeader: 5 * time.Second,
+			Read:       30 * time.Second,
+			Idle:       120 * time.Second,
+			Shutdown:   30 * time.Second,
+		},
+	}
+}
+
+// StorageAnalyzer returns the analyzer used by storage
+func (c *Config) StorageAnalyzer() storage.Analyzer {
+	return storage.Analyzer{Language: c.Analyzer.Language, Stemming: c.Analyzer.Stemming, StopWords: c.Analyzer.StopWords}
+}
+
+// TLSEnabled reports whether the server serves HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLS.CertFile != "" || c.TLS.KeyFile != ""
+}
+
+// JWTEnabled reports whether bearer tokens are accepted
+func (c *Config) JWTEnabled() bool {
+	return c.Auth.JWT.JWKSFile != "" || c.Auth.JWT.SecretFile != ""
+}
+
+// Validate reports every problem with the configuration, identifying settings by their path in the config file
+func (c *Config) Validate() error {
+	problems := []string{}
+	invalid := func(setting string, format string, args ...interface{}) {
+		problems = append(problems, setting+": "+fmt.Sprintf(format, args...))
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		invalid("addr", "must be a host and port, such as :1111")
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		invalid("log.level", "%v", err)
+	}
+	fileExists := func(setting, path string) {
+		if path == "" {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			invalid(setting, "%v", err)
+		}
+	}
+	fileExists("tls.cert_file", c.TLS.CertFile)
+	fileExists("tls.key_file", c.TLS.KeyFile)
+	fileExists("tls.client_ca_file", c.TLS.ClientCAFile)
+	if c.TLSEnabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		invalid("tls", "cert_file and key_file must both be set to serve TLS")
+	}
+	if !c.TLSEnabled() && c.TLS.ClientCAFile != "" {
+		invalid("tls.client_ca_file", "cert_file and key_file must be set to verify client certificates")
+	}
+	if c.TLS.ClientCAFile == "" && (c.TLS.RequireClientCert || len(c.TLS.ClientScopes) > 0 || len(c.TLS.ClientDefaultScopes) > 0) {
+		invalid("tls", "client_ca_file must be set to verify client certificates")
+	}
+	validateScopes("tls.client_scopes", c.TLS.ClientScopes, invalid)
+	for _, scope := range c.TLS.ClientDefaultScopes {
+		if _, err := auth.ParseScope(scope); err != nil {
+			invalid("tls.client_default_scopes", "%v", err)
+		}
+	}
+	fileExists("auth.keys_file", c.Auth.KeysFile)
+	fileExists("auth.jwt.jwks_file", c.Auth.JWT.JWKSFile)
+	fileExists("auth.jwt.secret_file", c.Auth.JWT.SecretFile)
+	if !c.JWTEnabled() && (c.Auth.JWT.Issuer != "" || c.Auth.JWT.Audience != "" || len(c.Auth.JWT.GroupScopes) > 0) {
+		invalid("auth.jwt", "jwks_file or secret_file must be set to accept bearer tokens")
+	}
+	validateScopes("auth.jwt.group_scopes", c.Auth.JWT.GroupScopes, invalid)
+	if err := c.StorageAnalyzer().Validate(); err != nil {
+		invalid("analyzer.language", "%v", err)
+	}
+	for _, limit := range []struct {
+		setting string
+		value   int
+	}{
+		{"limits.event_buffer", c.Limits.EventBuffer},
+		{"limits.webhook_workers", c.Limits.WebhookWorkers},
+		{"limits.webhook_max_attempts", c.Limits.WebhookMaxAttempts},
+		{"limits.max_body_bytes", c.Limits.MaxBodyBytes},
+		{"limits.max_import_bytes", c.Limits.MaxImportBytes},
+		{"limits.read_burst", c.Limits.ReadBurst},
+		{"limits.write_burst", c.Limits.WriteBurst},
+	} {
+		if limit.value <= 0 {
+			invalid(limit.setting, "must be greater than 0")
+		}
+	}
+	if c.Limits.ReadRate < 0 {
+		invalid("limits.read_rate", "cannot be negative")
+	}
+	if c.Limits.WriteRate < 0 {
+		invalid("limits.write_rate", "cannot be negative")
+	}
+	for _, timeout := range []struct {
+		setting string
+		value   time.Duration
+	}{
+		{"timeouts.read_header", c.Timeouts.ReadHeader},
+		{"timeouts.read", c.Timeouts.Read},
+		{"timeouts.write", c.Timeouts.Write},
+		{"timeouts.idle", c.Timeouts.Idle},
+		{"timeouts.shutdown", c.Timeouts.Shutdown},
+		{"timeouts.drain_delay", c.Timeouts.DrainDelay},
+	} {
+		if timeout.value < 0 {
+			invalid(timeout.setting, "cannot be negative")
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// validateScopes reports every unknown scope granted by a map of scopes, in a stable order
+func validateScopes(setting string, scopes map[string][]string, invalid func(string, string, ...interface{})) {
+	keys := []string{}
+	for key := range scopes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, scope := range scopes[key] {
+			if _, err := auth.ParseScope(scope); err != nil {
+				invalid(setting+"."+key, "%v", err)
+			}
+		}
+	}
+}
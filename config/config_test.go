@@ -0,0 +1,113 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func Test_Load(t *testing.T) {
+	file := writeConfigFile(t, `
+addr: :2222
+log:
+  level: warn
+validation:
+  check_mx: false
+limits:
+  event_buffer: 10
+timeouts:
+  read: 1m
+`)
+
+	t.Run("Defaults are used when nothing is configured", func(t *testing.T) {
+		config, options, err := Load("yaml-api", nil, env(nil))
+		assert.NoError(t, err)
+		assert.Equal(t, Default(), config)
+		assert.Equal(t, Options{}, options)
+	})
+
+	t.Run("Flags take precedence over environment variables, which take precedence over the config file", func(t *testing.T) {
+		config, options, err := Load("yaml-api", []string{"-config", file, "-addr", ":3333", "--strict-yaml"}, env(map[string]string{
+			"YAML_API_ADDR":         ":4444",
+			"YAML_API_LOG_LEVEL":    "debug",
+			"YAML_API_READ_TIMEOUT": "45s",
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, file, options.File)
+		assert.Equal(t, ":3333", config.Addr)
+		assert.Equal(t, "debug", config.Log.Level)
+		assert.Equal(t, 45*time.Second, config.Timeouts.Read)
+		assert.Equal(t, 10, config.Limits.EventBuffer)
+		assert.False(t, config.Validation.CheckMX)
+		assert.True(t, config.Validation.StrictYAML)
+		assert.Equal(t, Default().Timeouts.Idle, config.Timeouts.Idle)
+	})
+
+	t.Run("The config file can be named by an environment variable", func(t *testing.T) {
+		config, options, err := Load("yaml-api", []string{"-print-config"}, env(map[string]string{"YAML_API_CONFIG": file}))
+		assert.NoError(t, err)
+		assert.True(t, options.PrintConfig)
+		assert.Equal(t, ":2222", config.Addr)
+	})
+
+	t.Run("Group scopes are parsed from flags", func(t *testing.T) {
+		secret := writeConfigFile(t, "secret")
+		config, _, err := Load("yaml-api", []string{"-jwt-secret-file", secret, "-jwt-group-scopes", "platform=admin,developers=write,developers=read"}, env(nil))
+		assert.NoError(t, err)
+		assert.Equal(t, map[string][]string{"platform": {"admin"}, "developers": {"read", "write"}}, config.Auth.JWT.GroupScopes)
+	})
+
+	t.Run("Loading fails for unknown settings and malformed values", func(t *testing.T) {
+		_, _, err := Load("yaml-api", []string{"-config", writeConfigFile(t, "adress: :1111")}, env(nil))
+		assert.Contains(t, err.Error(), "field adress not found")
+		_, _, err = Load("yaml-api", nil, env(map[string]string{"YAML_API_STEMMING": "sometimes"}))
+		assert.EqualError(t, err, `invalid value "sometimes" for YAML_API_STEMMING: must be true or false`)
+		_, _, err = Load("yaml-api", []string{"-event-buffer", "lots"}, env(nil))
+		assert.Error(t, err)
+	})
+}
+
+func Test_Validate(t *testing.T) {
+	config := Default()
+	assert.NoError(t, config.Validate())
+
+	config.Addr = "1111"
+	config.Log.Level = "verbose"
+	config.Auth.KeysFile = "/does/not/exist.yaml"
+	config.Auth.JWT.Issuer = "https://sso.example.com"
+	config.Auth.JWT.GroupScopes = map[string][]string{"platform": {"root"}}
+	config.Analyzer.Language = "klingon"
+	config.Limits.WebhookWorkers = 0
+	config.Limits.WriteRate = -1
+	config.Timeouts.Shutdown = -time.Second
+	assert.EqualError(t, config.Validate(), `invalid configuration:
+  addr: must be a host and port, such as :1111
+  log.level: unknown log level "verbose", must be one of debug, info, warn or error
+  auth.keys_file: stat /does/not/exist.yaml: no such file or directory
+  auth.jwt: jwks_file or secret_file must be set to accept bearer tokens
+  auth.jwt.group_scopes.platform: unknown scope root, must be one of read, write or admin
+  analyzer.language: cannot stem words in "klingon", must be one of english, spanish, french, russian, swedish or norwegian
+  limits.webhook_workers: must be greater than 0
+  limits.write_rate: cannot be negative
+  timeouts.shutdown: cannot be negative`)
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the name of every environment variable read by Load. The rest of the name is the flag name in
+// upper case, with dashes replaced by underscores, such as YAML_API_LOG_LEVEL for -log-level.
+const EnvPrefix = "YAML_API_"
+
+// Options are command line flags that control loading, rather than being part of the configuration
+type Options struct {
+	// File is the YAML config file that was read, if any
+	File string
+	// PrintConfig asks for the effective configuration to be printed instead of starting the server
+	PrintConfig bool
+}
+
+// setting binds a flag, and the environment variable of the same name, to a field of a configuration
+type setting struct {
+	name  string
+	usage string
+	value func(c *Config) flag.Value
+}
+
+var settings = []setting{
+	{"addr", "address to accept http traffic on", func(c *Config) flag.Value { return (*stringValue)(&c.Addr) }},
+	{"tls-cert", "path to a PEM certificate file, to serve HTTPS", func(c *Config) flag.Value { return (*stringValue)(&c.TLS.CertFile) }},
+	{"tls-key", "path to the PEM private key file of the certificate", func(c *Config) flag.Value { return (*stringValue)(&c.TLS.KeyFile) }},
+	{"tls-client-ca", "path to a PEM file of CAs trusted to issue client certificates, to allow mutual TLS", func(c *Config) flag.Value { return (*stringValue)(&c.TLS.ClientCAFile) }},
+	{"tls-require-client-cert", "reject connections without a verified client certificate", func(c *Config) flag.Value { return (*boolValue)(&c.TLS.RequireClientCert) }},
+	{"tls-client-scopes", "comma separated identity=scope pairs granting scopes to client certificates by common name or subject alternative name", func(c *Config) flag.Value { return (*scopesValue)(&c.TLS.ClientScopes) }},
+	{"tls-client-default-scopes", "comma separated scopes granted to every client with a verified certificate", func(c *Config) flag.Value { return (*listValue)(&c.TLS.ClientDefaultScopes) }},
+	{"log-level", "minimum level of log entries to write, one of debug, info, warn or error", func(c *Config) flag.Value { return (*stringValue)(&c.Log.Level) }},
+	{"log-file", "path to a file to append log entries to, instead of standard output", func(c *Config) flag.Value { return (*stringValue)(&c.Log.File) }},
+	{"keys", "path to a YAML file of hashed API keys", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.KeysFile) }},
+	{"anonymous-reads", "allow requests without credentials to read metadata", func(c *Config) flag.Value { return (*boolValue)(&c.Auth.AnonymousReads) }},
+	{"jwks", "path to a JWKS file of public keys used to verify RS256 and ES256 bearer tokens", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.JWT.JWKSFile) }},
+	{"jwt-secret-file", "path to a file holding the shared secret used to verify HS256 bearer tokens", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.JWT.SecretFile) }},
+	{"jwt-issuer", "required iss claim of bearer tokens", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.JWT.Issuer) }},
+	{"jwt-audience", "required aud claim of bearer tokens", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.JWT.Audience) }},
+	{"jwt-email-claim", "claim holding the email of a bearer token's principal", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.JWT.EmailClaim) }},
+	{"jwt-groups-claim", "claim holding the groups of a bearer token's principal", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.JWT.GroupsClaim) }},
+	{"jwt-group-scopes", "comma separated group=scope pairs granting scopes to bearer token groups, such as admins=admin,developers=write", func(c *Config) flag.Value { return (*scopesValue)(&c.Auth.JWT.GroupScopes) }},
+	{"strict-yaml", "reject submitted metadata with unknown or duplicate attributes", func(c *Config) flag.Value { return (*boolValue)(&c.Validation.StrictYAML) }},
+	{"check-mx", "require the domain of maintainer emails to have a mail server", func(c *Config) flag.Value { return (*boolValue)(&c.Validation.CheckMX) }},
+	{"analyzer-language", "language words are stemmed in, one of english, spanish, french, russian, swedish or norwegian", func(c *Config) flag.Value { return (*stringValue)(&c.Analyzer.Language) }},
+	{"stemming", "reduce indexed and searched words to their stem", func(c *Config) flag.Value { return (*boolValue)(&c.Analyzer.Stemming) }},
+	{"stop-words", "leave the most common English words out of indexes and searches", func(c *Config) flag.Value { return (*boolValue)(&c.Analyzer.StopWords) }},
+	{"event-buffer", "number of change events kept for clients resuming the event stream", func(c *Config) flag.Value { return (*intValue)(&c.Limits.EventBuffer) }},
+	{"webhook-workers", "number of webhook deliveries attempted concurrently", func(c *Config) flag.Value { return (*intValue)(&c.Limits.WebhookWorkers) }},
+	{"webhook-allow-internal", "allow webhooks to loopback, link-local and private addresses", func(c *Config) flag.Value { return (*boolValue)(&c.Limits.WebhookAllowInternal) }},
+	{"webhook-max-attempts", "number of times a webhook delivery is attempted before it is dead-lettered", func(c *Config) flag.Value { return (*intValue)(&c.Limits.WebhookMaxAttempts) }},
+	{"max-body-bytes", "largest request body accepted, in bytes", func(c *Config) flag.Value { return (*intValue)(&c.Limits.MaxBodyBytes) }},
+	{"max-import-bytes", "largest import accepted, in bytes", func(c *Config) flag.Value { return (*intValue)(&c.Limits.MaxImportBytes) }},
+	{"read-rate", "requests per minute each principal or client address can make to read routes, or 0 for no limit", func(c *Config) flag.Value { return (*intValue)(&c.Limits.ReadRate) }},
+	{"read-burst", "requests each principal or client address can make to read routes at once", func(c *Config) flag.Value { return (*intValue)(&c.Limits.ReadBurst) }},
+	{"write-rate", "requests per minute each principal or client address can make to write and admin routes, or 0 for no limit", func(c *Config) flag.Value { return (*intValue)(&c.Limits.WriteRate) }},
+	{"write-burst", "requests each principal or client address can make to write and admin routes at once", func(c *Config) flag.Value { return (*intValue)(&c.Limits.WriteBurst) }},
+	{"read-header-timeout", "maximum duration for reading request headers", func(c *Config) flag.Value { return (*durationValue)(&c.Timeouts.ReadHeader) }},
+	{"read-timeout", "maximum duration for reading an entire request, including the body", func(c *Config) flag.Value { return (*durationValue)(&c.Timeouts.Read) }},
+	{"write-timeout", "maximum duration for writing a response, or 0 for no limit", func(c *Config) flag.Value { return (*durationValue)(&c.Timeouts.Write) }},
+	{"idle-timeout", "maximum duration keep-alive connections wait for the next request", func(c *Config) flag.Value { return (*durationValue)(&c.Timeouts.Idle) }},
+	{"shutdown-timeout", "maximum duration of a graceful shutdown", func(c *Config) flag.Value { return (*durationValue)(&c.Timeouts.Shutdown) }},
+	{"drain-delay", "how long /readyz fails before shutting down stops accepting connections", func(c *Config) flag.Value { return (*durationValue)(&c.Timeouts.DrainDelay) }},
+}
+
+// envName returns the environment variable read for a flag
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Load reads the configuration from command line arguments, environment variables and a YAML config file, in that
+// order of precedence, over the defaults. The config file is named by the -config flag or the YAML_API_CONFIG
+// environment variable. The configuration is validated before it is returned, and is returned along with any
+// validation error.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, Options, error) {
+	options := Options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&options.File, "config", "", "path to a YAML config file, also read from "+envName("config"))
+	fs.BoolVar(&options.PrintConfig, "print-config", false, "print the effective configuration as YAML, and exit")
+	// flags are parsed into a separate configuration, so that only the flags that were set override the others
+	flagged := Default()
+	for _, s := range settings {
+		fs.Var(s.value(flagged), s.name, s.usage+" ("+envName(s.name)+")")
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, options, err
+	}
+	if fs.NArg() > 0 {
+		return nil, options, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	config := Default()
+	if options.File == "" {
+		options.File, _ = lookupEnv(envName("config"))
+	}
+	if options.File != "" {
+		data, err := ioutil.ReadFile(options.File)
+		if err != nil {
+			return nil, options, fmt.Errorf("could not read config file: %v", err)
+		}
+		err = yaml.UnmarshalStrict(data, config)
+		if err != nil {
+			return nil, options, fmt.Errorf("could not parse config file %s: %v", options.File, err)
+		}
+	}
+	for _, s := range settings {
+		value, ok := lookupEnv(envName(s.name))
+		if !ok {
+			continue
+		}
+		err := s.value(config).Set(value)
+		if err != nil {
+			return nil, options, fmt.Errorf("invalid value %q for %s: %v", value, envName(s.name), err)
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name == f.Name {
+				// values set by flags have already been parsed, so copying them cannot fail
+				_ = s.value(config).Set(s.value(flagged).String())
+			}
+		}
+	})
+	return config, options, config.Validate()
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string {
+	return string(*v)
+}
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("must be true or false")
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string {
+	return strconv.FormatBool(bool(*v))
+}
+
+// IsBoolFlag allows boolean flags to be set without a value, such as -strict-yaml
+func (v *boolValue) IsBoolFlag() bool {
+	return true
+}
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("must be a whole number")
+	}
+	*v = intValue(i)
+	return nil
+}
+
+func (v *intValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("must be a duration, such as 30s")
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) String() string {
+	return time.Duration(*v).String()
+}
+
+// scopesValue is set from comma separated name=scope pairs
+type scopesValue map[string][]string
+
+func (v *scopesValue) Set(s string) error {
+	groupScopes := map[string][]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%q must be formatted as name=scope", pair)
+		}
+		groupScopes[parts[0]] = append(groupScopes[parts[0]], parts[1])
+	}
+	*v = groupScopes
+	return nil
+}
+
+func (v *scopesValue) String() string {
+	if v == nil {
+		return ""
+	}
+	pairs := []string{}
+	for group, scopes := range *v {
+		for _, scope := range scopes {
+			pairs = append(pairs, group+"="+scope)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// listValue is set from a comma separated list
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	*v = list
+	return nil
+}
+
+func (v *listValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(*v, ",")
+}
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event is a notification that is delivered to subscribers in the order it was published
+type Event struct {
+	// ID increases monotonically with every published event, starting at 1
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Broker keeps the most recently published events in a fixed size ring buffer, and fans out new events to
+// subscribers. Subscribers that reconnect can resume from the last event they received, as long as it is still
+// in the buffer.
+type Broker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	buffer      []Event
+	start       int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	broker *Broker
+	// Events is closed when the subscriber falls too far behind, or the broker is closed
+	Events chan Event
+}
+
+// subscriptionBuffer is the number of events a subscriber may fall behind before it is disconnected
+const subscriptionBuffer = 64
+
+// NewBroker creates a broker retaining up to size events for subscribers to resume from
+func NewBroker(size int) *Broker {
+	if size < 1 {
+		size = 1
+	}
+	return &Broker{
+		nextID:      1,
+		buffer:      make([]Event, 0, size),
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish assigns the next ID to an event carrying data encoded as JSON, and delivers it to every subscriber
+func (b *Broker) Publish(eventType string, data interface{}) (Event, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	event := Event{ID: b.nextID, Type: eventType, Time: time.Now().UTC(), Data: encoded}
+	b.nextID++
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, event)
+	} else {
+		b.buffer[b.start] = event
+		b.start = (b.start + 1) % len(b.buffer)
+	}
+	for sub := range b.subscribers {
+		select {
+		case sub.Events <- event:
+		default:
+			// the subscriber is not keeping up, so disconnect it rather than block publishers.
+			// It can resume from the buffer once it reconnects.
+			b.unsubscribe(sub)
+		}
+	}
+	return event, nil
+}
+
+// Subscribe returns the buffered events published after lastID, and a subscription to the events published after
+// them. complete is false when events after lastID have already been dropped from the buffer, in which case the
+// subscriber has missed events. A lastID of 0 subscribes to new events only.
+func (b *Broker) Subscribe(lastID uint64) (backlog []Event, sub *Subscription, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub = &Subscription{broker: b, Events: make(chan Event, subscriptionBuffer)}
+	if b.closed {
+		close(sub.Events)
+	} else {
+		b.subscribers[sub] = struct{}{}
+	}
+	complete = true
+	if lastID == 0 {
+		return []Event{}, sub, complete
+	}
+	if lastID >= b.nextID {
+		// the subscriber is ahead of this broker, which can only happen when the broker was restarted
+		return []Event{}, sub, false
+	}
+	backlog = []Event{}
+	for i := 0; i < len(b.buffer); i++ {
+		event := b.buffer[(b.start+i)%len(b.buffer)]
+		if event.ID > lastID {
+			backlog = append(backlog, event)
+		}
+	}
+	if len(backlog) > 0 && backlog[0].ID != lastID+1 {
+		complete = false
+	}
+	return backlog, sub, complete
+}
+
+// Close stops receiving events from the broker
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.unsubscribe(s)
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.Events)
+	}
+}
+
+// Close disconnects every subscriber. Events published afterwards are still buffered, but not delivered.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		b.unsubscribe(sub)
+	}
+}
+
+// LastID returns the ID of the most recently published event, or 0 if no event has been published
+func (b *Broker) LastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID - 1
+}
@@ -0,0 +1,79 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Broker(t *testing.T) {
+	t.Run("Events are assigned monotonically increasing IDs and delivered to subscribers", func(t *testing.T) {
+		b := NewBroker(10)
+		_, sub, complete := b.Subscribe(0)
+		assert.True(t, complete)
+		for i := 1; i <= 3; i++ {
+			event, err := b.Publish("create", map[string]int{"n": i})
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(i), event.ID)
+		}
+		for i := 1; i <= 3; i++ {
+			event := <-sub.Events
+			assert.Equal(t, uint64(i), event.ID)
+			assert.Equal(t, "create", event.Type)
+		}
+		assert.Equal(t, uint64(3), b.LastID())
+	})
+
+	t.Run("Subscribers resume from the buffer after the last event they received", func(t *testing.T) {
+		b := NewBroker(3)
+		for i := 1; i <= 5; i++ {
+			_, err := b.Publish("update", i)
+			assert.NoError(t, err)
+		}
+		backlog, _, complete := b.Subscribe(3)
+		assert.True(t, complete)
+		assert.Equal(t, []uint64{4, 5}, eventIDs(backlog))
+		backlog, _, complete = b.Subscribe(1)
+		assert.False(t, complete, "event 2 has been dropped from the buffer")
+		assert.Equal(t, []uint64{3, 4, 5}, eventIDs(backlog))
+		backlog, _, complete = b.Subscribe(5)
+		assert.True(t, complete)
+		assert.Empty(t, backlog)
+		_, _, complete = b.Subscribe(9)
+		assert.False(t, complete)
+	})
+
+	t.Run("Subscribers that fall behind are disconnected", func(t *testing.T) {
+		b := NewBroker(1)
+		_, sub, _ := b.Subscribe(0)
+		for i := 0; i <= subscriptionBuffer; i++ {
+			_, err := b.Publish("update", i)
+			assert.NoError(t, err)
+		}
+		received := 0
+		for range sub.Events {
+			received++
+		}
+		assert.Equal(t, subscriptionBuffer, received)
+	})
+
+	t.Run("Closing the broker disconnects every subscriber", func(t *testing.T) {
+		b := NewBroker(1)
+		_, sub, _ := b.Subscribe(0)
+		b.Close()
+		_, ok := <-sub.Events
+		assert.False(t, ok)
+		sub.Close()
+		_, sub, _ = b.Subscribe(0)
+		_, ok = <-sub.Events
+		assert.False(t, ok)
+	})
+}
+
+func eventIDs(events []Event) []uint64 {
+	ids := []uint64{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
@@ -0,0 +1,158 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+// Levels in increasing order of severity
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn or error, case-insensitively
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, must be one of debug, info, warn or error", s)
+}
+
+// Logger writes entries as single lines of JSON. Every entry has a time, level and msg, followed by the
+// logger's own fields and then the fields passed with the entry.
+type Logger struct {
+	out    *output
+	level  Level
+	fields []interface{}
+}
+
+// output serializes writes from a logger and every logger derived from it
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New creates a logger writing entries at or above the given level to w
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{out: &output{w: w}, level: level}
+}
+
+// With returns a logger that adds the given key-value pairs to every entry
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+	fields := make([]interface{}, 0, len(l.fields)+len(keysAndValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keysAndValues...)
+	return &Logger{out: l.out, level: l.level, fields: fields}
+}
+
+// Enabled reports whether entries at the given level are written. A nil logger writes nothing.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+// Debug writes an entry at the debug level, with alternating keys and values
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(LevelDebug, msg, keysAndValues)
+}
+
+// Info writes an entry at the info level, with alternating keys and values
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(LevelInfo, msg, keysAndValues)
+}
+
+// Warn writes an entry at the warn level, with alternating keys and values
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(LevelWarn, msg, keysAndValues)
+}
+
+// Error writes an entry at the error level, with alternating keys and values
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(LevelError, msg, keysAndValues)
+}
+
+func (l *Logger) log(level Level, msg string, keysAndValues []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	b := &bytes.Buffer{}
+	b.WriteString(`{"time":`)
+	writeValue(b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeValue(b, level.String())
+	b.WriteString(`,"msg":`)
+	writeValue(b, msg)
+	writeFields(b, l.fields)
+	writeFields(b, keysAndValues)
+	b.WriteString("}\n")
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(b.Bytes())
+}
+
+// writeFields writes alternating keys and values as JSON members. A trailing key without a value is written
+// with a null value, rather than being dropped.
+func writeFields(b *bytes.Buffer, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		b.WriteByte(',')
+		writeValue(b, fmt.Sprint(keysAndValues[i]))
+		b.WriteByte(':')
+		if i+1 < len(keysAndValues) {
+			writeValue(b, keysAndValues[i+1])
+		} else {
+			b.WriteString("null")
+		}
+	}
+}
+
+func writeValue(b *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case error:
+		v = value.Error()
+	case fmt.Stringer:
+		v = value.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by a context, or a nil logger that discards every entry
+func FromContext(ctx context.Context) *Logger {
+	logger, _ := ctx.Value(contextKey{}).(*Logger)
+	return logger
+}
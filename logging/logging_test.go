@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Logger(t *testing.T) {
+	t.Run("Entries are written as JSON lines with the logger's fields", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		logger := New(b, LevelInfo).With("request_id", "abc")
+		logger.Info("request served", "status", 201, "error", errors.New("boom"), "dangling")
+		entry := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(b.Bytes(), &entry))
+		assert.Equal(t, "info", entry["level"])
+		assert.Equal(t, "request served", entry["msg"])
+		assert.Equal(t, "abc", entry["request_id"])
+		assert.Equal(t, float64(201), entry["status"])
+		assert.Equal(t, "boom", entry["error"])
+		assert.Contains(t, entry, "dangling")
+		assert.NotEmpty(t, entry["time"])
+		assert.True(t, strings.HasPrefix(b.String(), `{"time":`))
+	})
+
+	t.Run("Entries below the logger's level are not written", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		logger := New(b, LevelWarn)
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Error("error")
+		assert.Equal(t, 2, strings.Count(b.String(), "\n"))
+	})
+
+	t.Run("Loggers are carried by contexts", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		ctx := NewContext(context.Background(), New(b, LevelDebug))
+		FromContext(ctx).Debug("found")
+		assert.Contains(t, b.String(), `"msg":"found"`)
+		assert.NotPanics(t, func() { FromContext(context.Background()).With("a", 1).Error("discarded") })
+	})
+}
+
+func Test_ParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, LevelWarn, level)
+	_, err = ParseLevel("verbose")
+	assert.EqualError(t, err, `unknown log level "verbose", must be one of debug, info, warn or error`)
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Registry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Number of requests.", "route", "status")
+	latency := r.NewHistogram("latency_seconds", "Request latency.", []float64{0.5, 0.1}, "route")
+	r.NewGaugeFunc("documents", "Stored documents.", []string{"state"}, func(emit func(float64, ...string)) {
+		emit(3, "active")
+		emit(1, "yanked")
+	})
+	requests.Inc("/metadata", "200")
+	requests.Inc("/metadata", "200")
+	requests.Add(1.5, `/say "hi"`+"\n", "500")
+	latency.Observe(0.05, "/metadata")
+	latency.Observe(0.25, "/metadata")
+	latency.Observe(3, "/metadata")
+
+	t.Run("Metrics are written in the Prometheus text exposition format", func(t *testing.T) {
+		expected := `# HELP documents Stored documents.
+# TYPE documents gauge
+documents{state="active"} 3
+documents{state="yanked"} 1
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/metadata",le="0.1"} 1
+latency_seconds_bucket{route="/metadata",le="0.5"} 2
+latency_seconds_bucket{route="/metadata",le="+Inf"} 3
+latency_seconds_sum{route="/metadata"} 3.3
+latency_seconds_count{route="/metadata"} 3
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="/metadata",status="200"} 2
+requests_total{route="/say \"hi\"\n",status="500"} 1.5
+`
+		b := &bytes.Buffer{}
+		assert.NoError(t, r.Write(b))
+		assert.Equal(t, expected, b.String())
+	})
+
+	t.Run("Values can be read back for assertions", func(t *testing.T) {
+		assert.Equal(t, float64(2), requests.Value("/metadata", "200"))
+		assert.Equal(t, uint64(3), latency.Count("/metadata"))
+	})
+
+	t.Run("Metrics are served with the exposition content type", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.Handler()(w, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "# TYPE requests_total counter")
+	})
+
+	t.Run("Mismatched label values and duplicate names panic", func(t *testing.T) {
+		assert.Panics(t, func() { requests.Inc("/metadata") })
+		assert.Panics(t, func() { r.NewCounter("requests_total", "Duplicate.") })
+		assert.Panics(t, func() { requests.Add(-1, "/metadata", "200") })
+	})
+}
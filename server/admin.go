@@ -0,0 +1,304 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/medhir/yaml-api/storage"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	// NDJSONContentType is the media type of newline delimited JSON, in which every line is a JSON document
+	NDJSONContentType = "application/x-ndjson"
+	// GzipContentType is the media type of exports archived as a gzipped tarball of YAML files
+	GzipContentType = "application/gzip"
+)
+
+// handleExport streams every stored document, whatever its lifecycle state, with the attributes managed by the API.
+// Documents are written as newline delimited JSON, or with format=tar as a gzipped tarball holding a YAML file for
+// each document.
+func (s *Server) handleExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "ndjson" && format != "tar" {
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("unknown export format %q, expected ndjson or tar", format))
+			return
+		}
+		documents := s.storage.AllMetadata()
+		var err error
+		if format == "tar" {
+			w.Header().Set("Content-Type", GzipContentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="yaml-api-export.tar.gz"`)
+			err = writeArchive(w, documents)
+		} else {
+			w.Header().Set("Content-Type", NDJSONContentType)
+			encoder := json.NewEncoder(w)
+			for _, md := range documents {
+				err = encoder.Encode(md)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			// the status has already been sent, so the error can only be logged
+			s.logger.Error("could not write export", "format", format, "error", err)
+		}
+	}
+}
+
+// writeArchive writes a gzipped tarball holding a YAML file for each document, named after its ID
+func writeArchive(w io.Writer, documents []*storage.Metadata) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	for _, md := range documents {
+		data, err := marshalArchivedMetadata(md)
+		if err != nil {
+			return err
+		}
+		err = archive.WriteHeader(&tar.Header{
+			Name:    md.ID + ".yaml",
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: md.Updated,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = archive.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	err := archive.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// marshalArchivedMetadata writes metadata as YAML with every attribute, including those managed by the API which
+// submitted YAML leaves out. Attributes are named as they are in JSON.
+func marshalArchivedMetadata(md *storage.Metadata) ([]byte, error) {
+	data, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, and decoding it into a MapSlice keeps the order of its attributes
+	attributes := yaml.MapSlice{}
+	err = yaml.Unmarshal(data, &attributes)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(attributes)
+}
+
+// unmarshalArchivedMetadata reads metadata written by marshalArchivedMetadata
+func unmarshalArchivedMetadata(data []byte) (*storage.Metadata, error) {
+	// yaml.v3 decodes mappings with string keys, which can be converted to JSON
+	var attributes map[string]interface{}
+	err := yamlv3.Unmarshal(data, &attributes)
+	if err != nil {
+		return nil, err
+	}
+	data, err = json.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+	return decodeExportedMetadata(json.NewDecoder(bytes.NewReader(data)))
+}
+
+// decodeExportedMetadata decodes the next exported document, rejecting unknown attributes
+func decodeExportedMetadata(decoder *json.Decoder) (*storage.Metadata, error) {
+	decoder.DisallowUnknownFields()
+	md := &storage.Metadata{}
+	err := decoder.Decode(md)
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// readExport decodes the documents of an export, in either of the formats handleExport writes. Gzipped tarballs are
+// recognized by their content, and YAML files are read from them in the order they were archived. Since archives are
+// compressed, each file is limited to maxFileBytes once decompressed, and all of them together to maxBytes.
+func readExport(data []byte, maxFileBytes, maxBytes int64) ([]*storage.Metadata, error) {
+	documents := []*storage.Metadata{}
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			md, err := decodeExportedMetadata(decoder)
+			if err == io.EOF {
+				return documents, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("document %d: %v", len(documents), err)
+			}
+			documents = append(documents, md)
+		}
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	archive := tar.NewReader(gz)
+	total := int64(0)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ext := strings.ToLower(path.Ext(header.Name))
+		if header.Typeflag != tar.TypeReg || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(archive, maxFileBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", header.Name, err)
+		}
+		if int64(len(data)) > maxFileBytes {
+			return nil, fmt.Errorf("%s is larger than the limit of %d bytes", header.Name, maxFileBytes)
+		}
+		total += int64(len(data))
+		if total > maxBytes {
+			return nil, fmt.Errorf("the archived files are larger than the limit of %d bytes", maxBytes)
+		}
+		md, err := unmarshalArchivedMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("document %d (%s): %v", len(documents), header.Name, err)
+		}
+		documents = append(documents, md)
+	}
+}
+
+// handleImport restores an export, keeping the IDs, lifecycle state and timestamps of its documents. The conflict
+// query parameter chooses what happens to documents with the ID of stored metadata: the import fails by default, or
+// they can be skipped or overwrite the stored metadata. Every document is validated before any are stored.
+func (s *Server) handleImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conflict := storage.ConflictFail
+		if value := r.URL.Query().Get("conflict"); value != "" {
+			conflict = storage.Conflict(value)
+		}
+		if conflict != storage.ConflictFail && conflict != storage.ConflictSkip && conflict != storage.ConflictOverwrite {
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("unknown conflict strategy %q, expected one of fail, skip or overwrite", conflict))
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, "could not read request body", err)
+			return
+		}
+		// a document is at most as large as the body of a request storing it, and an archive holds at most as much as
+		// an uncompressed export could
+		maxFileBytes, maxBytes := s.maxBodyBytes, s.maxImportBytes
+		if maxFileBytes <= 0 {
+			maxFileBytes = DefaultMaxBodyBytes
+		}
+		if maxBytes <= 0 {
+			maxBytes = DefaultMaxImportBytes
+		}
+		documents, err := readExport(data, maxFileBytes, maxBytes)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("request does not contain a valid export:\n%v", err))
+			return
+		}
+		if len(documents) == 0 {
+			writeProblem(w, http.StatusBadRequest, "request does not contain any metadata documents")
+			return
+		}
+		problems := []Problem{}
+		for i, md := range documents {
+			err = s.storage.ValidateMetadata(md)
+			if err != nil {
+				s.metrics.validationFailed(err)
+				problem := invalidMetadataProblem(err)
+				index := i
+				problem.Document = &index
+				problems = append(problems, problem)
+			}
+		}
+		if len(problems) > 0 {
+			problem := newProblem(http.StatusBadRequest, fmt.Sprintf("%d of %d documents are invalid", len(problems), len(documents)))
+			problem.Errors = problems
+			writeProblemBody(w, problem)
+			return
+		}
+		result, err := s.storage.Import(documents, conflict)
+		if conflictErr, ok := err.(*storage.ConflictError); ok {
+			problem := newProblem(http.StatusConflict, fmt.Sprintf("%d documents have the IDs of stored metadata, which conflict=skip or conflict=overwrite would allow", len(conflictErr.IDs)))
+			for i, id := range conflictErr.IDs {
+				conflicting := newProblem(http.StatusConflict, fmt.Sprintf("metadata %s already exists", id))
+				conflicting.Document = &conflictErr.Documents[i]
+				problem.Errors = append(problem.Errors, conflicting)
+			}
+			writeProblemBody(w, problem)
+			return
+		}
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.logger.Info("imported metadata", "created", result.Created, "overwritten", result.Overwritten, "skipped", result.Skipped)
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// handleReindex rebuilds the index of every stored document online. The request body may change the analyzer with
+// a JSON object such as {"stemming": false}, whose missing attributes keep their current value. Without a body, the
+// index is rebuilt with the current analyzer.
+func (s *Server) handleReindex() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		analyzer := s.storage.Analyzer()
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&analyzer)
+		if err != nil && err != io.EOF {
+			writeBodyError(w, "request does not contain a valid analyzer", err)
+			return
+		}
+		err = analyzer.Validate()
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		result, err := s.storage.Reindex(analyzer)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.logger.Info("rebuilt index", "documents", result.Documents, "changed", result.Changed, "duration", result.Duration.String())
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// handleIndexStats describes the index of every attribute, listing the terms that reference the most documents. The
+// top query parameter sets how many terms are listed for each attribute.
+func (s *Server) handleIndexStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		top := 10
+		if value := r.URL.Query().Get("top"); value != "" {
+			var err error
+			top, err = strconv.Atoi(value)
+			if err != nil || top < 0 || top > 1000 {
+				writeProblem(w, http.StatusBadRequest, fmt.Sprintf("top must be a number from 0 to 1000, not %q", value))
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, s.storage.IndexStats(top))
+	}
+}
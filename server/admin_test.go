@@ -0,0 +1,242 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_exportAndImport(t *testing.T) {
+	newMetadata := func(version string) *storage.Metadata {
+		return &storage.Metadata{
+			Title:       "Exported app",
+			Version:     version,
+			Maintainers: []storage.Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}},
+			Company:     "Random Inc.",
+			Website:     "https://website.com",
+			Source:      "https://github.com/random/repo",
+			License:     "MIT",
+			Description: "Some application content",
+		}
+	}
+	// maintainer emails are not looked up, so tests do not depend on DNS
+	newStore := func() *storage.Storage { return storage.NewStorage(storage.WithMXCheck(false)) }
+	source := newStore()
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		assert.NoError(t, source.AddMetadata(context.Background(), newMetadata(version)))
+	}
+	stored := source.AllMetadata()
+	_, err := source.DeprecateMetadata(stored[0].ID, storage.Deprecation{Message: "use 1.1.0", Replacement: stored[1].ID})
+	assert.NoError(t, err)
+	stored = source.AllMetadata()
+
+	export := func(format string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		newTestServer(WithStorage(source)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/export?format="+format, nil))
+		return w
+	}
+	importInto := func(s *Server, query string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/import"+query, bytes.NewReader(body)))
+		return w
+	}
+
+	t.Run("Documents are exported as newline delimited JSON", func(t *testing.T) {
+		w := export("")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, NDJSONContentType, w.Header().Get("Content-Type"))
+		lines := bytes.Split(bytes.TrimSpace(w.Body.Bytes()), []byte("\n"))
+		if assert.Len(t, lines, 2) {
+			md := &storage.Metadata{}
+			assert.NoError(t, json.Unmarshal(lines[0], md))
+			assert.Equal(t, stored[0], md)
+		}
+	})
+
+	t.Run("Documents are exported as a tarball of YAML files", func(t *testing.T) {
+		w := export("tar")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, GzipContentType, w.Header().Get("Content-Type"))
+		gz, err := gzip.NewReader(w.Body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		archive := tar.NewReader(gz)
+		names := []string{}
+		for {
+			header, err := archive.Next()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			names = append(names, header.Name)
+		}
+		assert.Equal(t, []string{stored[0].ID + ".yaml", stored[1].ID + ".yaml"}, names)
+	})
+
+	t.Run("Export fails for an unknown format", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, export("xml").Code)
+	})
+
+	for _, format := range []string{"ndjson", "tar"} {
+		t.Run("Exports restore every document, keeping IDs, state and timestamps, as "+format, func(t *testing.T) {
+			destination := newStore()
+			w := importInto(newTestServer(WithStorage(destination)), "", export(format).Body.Bytes())
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			result := storage.ImportResult{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, storage.ImportResult{Created: 2}, result)
+			assert.Equal(t, stored, destination.AllMetadata())
+			results, err := destination.LookupMetadata(context.Background(), map[string]string{"title": "exported"}, false)
+			assert.NoError(t, err)
+			assert.Len(t, results, 2)
+		})
+	}
+
+	t.Run("Import fails for documents that are already stored, unless they are skipped or overwritten", func(t *testing.T) {
+		body := export("ndjson").Body.Bytes()
+		s := newTestServer(WithStorage(source))
+
+		w := importInto(s, "", body)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		problem := Problem{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		if assert.Len(t, problem.Errors, 2) {
+			assert.Equal(t, 1, *problem.Errors[1].Document)
+			assert.Equal(t, "metadata "+stored[1].ID+" already exists", problem.Errors[1].Detail)
+		}
+
+		for query, expected := range map[string]storage.ImportResult{"?conflict=skip": {Skipped: 2}, "?conflict=overwrite": {Overwritten: 2}} {
+			w = importInto(s, query, body)
+			assert.Equal(t, http.StatusOK, w.Code)
+			result := storage.ImportResult{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, expected, result)
+		}
+		// overwritten metadata moves to a new revision
+		for i, md := range source.AllMetadata() {
+			assert.Equal(t, stored[i].Revision+1, md.Revision)
+			md.Revision = stored[i].Revision
+			assert.Equal(t, stored[i], md)
+		}
+	})
+
+	t.Run("Import fails for invalid documents, storing none of them", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			query  string
+			body   string
+			status int
+			detail string
+		}{
+			{
+				name:   "Import fails for an unknown conflict strategy",
+				query:  "?conflict=merge",
+				body:   `{"title": "Imported app"}`,
+				status: http.StatusBadRequest,
+				detail: `unknown conflict strategy "merge", expected one of fail, skip or overwrite`,
+			},
+			{
+				name:   "Import fails for unknown attributes",
+				body:   `{"title": "Imported app", "licence": "MIT"}`,
+				status: http.StatusBadRequest,
+				detail: "request does not contain a valid export:\ndocument 0: json: unknown field \"licence\"",
+			},
+			{
+				name:   "Import fails for an empty export",
+				status: http.StatusBadRequest,
+				detail: "request does not contain any metadata documents",
+			},
+			{
+				name:   "Import fails for metadata that does not validate",
+				body:   `{"title": "Imported app", "version": "1.0.0"}`,
+				status: http.StatusBadRequest,
+				detail: "1 of 1 documents are invalid",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				destination := newStore()
+				w := importInto(newTestServer(WithStorage(destination)), tt.query, []byte(tt.body))
+				assert.Equal(t, tt.status, w.Code)
+				problem := Problem{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				assert.Equal(t, tt.detail, problem.Detail)
+				assert.Empty(t, destination.AllMetadata())
+			})
+		}
+	})
+
+	t.Run("Import fails for archived files that decompress beyond the limit", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		gz := gzip.NewWriter(b)
+		archive := tar.NewWriter(gz)
+		padding := "description: " + strings.Repeat("a", 4096) + "\n"
+		assert.NoError(t, archive.WriteHeader(&tar.Header{Name: "bomb.yaml", Mode: 0644, Size: int64(len(padding)), Typeflag: tar.TypeReg}))
+		_, err := archive.Write([]byte(padding))
+		assert.NoError(t, err)
+		assert.NoError(t, archive.Close())
+		assert.NoError(t, gz.Close())
+		assert.Less(t, b.Len(), 1024)
+
+		destination := newStore()
+		w := importInto(newTestServer(WithStorage(destination), WithMaxBodyBytes(1024)), "", b.Bytes())
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "bomb.yaml is larger than the limit of 1024 bytes")
+		assert.Empty(t, destination.AllMetadata())
+	})
+}
+
+func Test_reindexAndIndexStats(t *testing.T) {
+	store := storage.NewStorage()
+	assert.NoError(t, store.AddMetadata(context.Background(), &storage.Metadata{Title: "Jumping app", Version: "1.0.0"}))
+	s := newTestServer(WithStorage(store))
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("The index is rebuilt with the current analyzer without a body", func(t *testing.T) {
+		w := serve(http.MethodPost, "/admin/reindex", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		result := storage.ReindexResult{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, storage.DefaultAnalyzer, result.Analyzer)
+		assert.Equal(t, 1, result.Documents)
+	})
+
+	t.Run("The analyzer is changed by the attributes in the body", func(t *testing.T) {
+		w := serve(http.MethodPost, "/admin/reindex", `{"stemming": false}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, storage.Analyzer{Language: "english", StopWords: true}, store.Analyzer())
+		stats := storage.IndexStats{}
+		assert.NoError(t, json.Unmarshal(serve(http.MethodGet, "/admin/index/stats?top=1", "").Body.Bytes(), &stats))
+		assert.Equal(t, []storage.TermCount{{Term: "app", Documents: 1}}, stats.Fields["title"].TopTerms)
+		assert.Equal(t, 2, stats.Fields["title"].Terms)
+	})
+
+	t.Run("Invalid requests are rejected", func(t *testing.T) {
+		for _, w := range []*httptest.ResponseRecorder{
+			serve(http.MethodPost, "/admin/reindex", `{"language": "klingon", "stemming": true}`),
+			serve(http.MethodPost, "/admin/reindex", `{"stemmer": false}`),
+			serve(http.MethodGet, "/admin/index/stats?top=-1", ""),
+			serve(http.MethodGet, "/admin/index/stats?top=all", ""),
+		} {
+			assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		}
+		assert.Equal(t, storage.Analyzer{Language: "english", StopWords: true}, store.Analyzer())
+	})
+}
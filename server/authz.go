@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/storage"
+)
+
+var (
+	errUnauthenticated = errors.New("authentication is required")
+	errNotMaintainer   = errors.New("only a maintainer of the application or an admin may modify it")
+)
+
+// authorizeMaintainer checks that the caller of a request may modify the application with the given title.
+// Applications that have not been stored yet may be created by anyone with the write scope. Once stored,
+// only an admin or a principal whose email belongs to one of the application's maintainers may modify it.
+func (s *Server) authorizeMaintainer(r *http.Request, title string) error {
+	return s.maintainerCheck(r)(s.storage.Owners(title))
+}
+
+// maintainerCheck returns the check authorizeMaintainer makes of the owners of an application, for the caller of a
+// request
+func (s *Server) maintainerCheck(r *http.Request) func(owners []storage.Maintainer, exists bool) error {
+	principal := auth.FromContext(r.Context())
+	return func(owners []storage.Maintainer, exists bool) error {
+		if s.authenticator == nil {
+			return nil
+		}
+		if principal == nil {
+			return errUnauthenticated
+		}
+		if principal.HasScope(auth.ScopeAdmin) {
+			return nil
+		}
+		if !exists {
+			return nil
+		}
+		if principal.Email == "" {
+			return errNotMaintainer
+		}
+		for _, owner := range owners {
+			if strings.EqualFold(strings.TrimSpace(owner.Email), strings.TrimSpace(principal.Email)) {
+				return nil
+			}
+		}
+		return errNotMaintainer
+	}
+}
+
+// ifMaintainer returns a precondition requiring the caller of a request to be allowed to modify the stored metadata,
+// and every application named by titles, checked under the same lock as the write so that a concurrent change of
+// ownership cannot slip in between
+func (s *Server) ifMaintainer(r *http.Request, titles ...string) storage.Precondition {
+	return s.storage.IfOwnersAllow(s.maintainerCheck(r), titles...)
+}
+
+// isAuthzError reports whether an error was returned by authorizeMaintainer or ifMaintainer
+func isAuthzError(err error) bool {
+	return err == errUnauthenticated || err == errNotMaintainer
+}
+
+// writeAuthzError responds with the status code matching an error returned by authorizeMaintainer
+func writeAuthzError(w http.ResponseWriter, err error) {
+	writeProblemBody(w, authzProblem(err))
+}
+
+func authzProblem(err error) Problem {
+	if err == errUnauthenticated {
+		return newProblem(http.StatusUnauthorized, err.Error())
+	}
+	return newProblem(http.StatusForbidden, err.Error())
+}
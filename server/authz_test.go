@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_authorizeMaintainer(t *testing.T) {
+	authenticator := fakeAuthenticator{
+		"maintainer": {Name: "maintainer", Email: "Bill@gmail.com", Scopes: []auth.Scope{auth.ScopeWrite}},
+		"other-team": {Name: "other-team", Email: "team-b@gmail.com", Scopes: []auth.Scope{auth.ScopeWrite}},
+		"no-email":   {Name: "no-email", Scopes: []auth.Scope{auth.ScopeWrite}},
+		"admin":      {Name: "admin", Scopes: []auth.Scope{auth.ScopeAdmin}},
+	}
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		action string
+		status int
+	}{
+		{name: "Maintainers may deprecate their application", key: "maintainer", method: http.MethodPost, action: "/deprecate", status: http.StatusOK},
+		{name: "Other teams may not deprecate the application", key: "other-team", method: http.MethodPost, action: "/deprecate", status: http.StatusForbidden},
+		{name: "Principals without an email may not yank the application", key: "no-email", method: http.MethodPost, action: "/yank", status: http.StatusForbidden},
+		{name: "Other teams may not delete the application", key: "other-team", method: http.MethodDelete, status: http.StatusForbidden},
+		{name: "Other teams may not overwrite the application", key: "other-team", method: http.MethodPut, status: http.StatusForbidden},
+		{name: "Admins may delete any application", key: "admin", method: http.MethodDelete, status: http.StatusNoContent},
+		{name: "Maintainers may delete their application", key: "maintainer", method: http.MethodDelete, status: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(WithAuthenticator(authenticator))
+			md := &storage.Metadata{
+				Title:       "App title 1",
+				Version:     "1.0.0",
+				Maintainers: []storage.Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}},
+			}
+			err := s.storage.AddMetadata(context.Background(), md)
+			assert.NoError(t, err)
+			r := httptest.NewRequest(tt.method, "/metadata/"+md.ID+tt.action, strings.NewReader(`{"message": "no longer supported"}`))
+			r.Header.Set(auth.APIKeyHeader, tt.key)
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+
+	t.Run("Anyone with the write scope may create a new application", func(t *testing.T) {
+		s := &Server{storage: storage.NewStorage(), authenticator: authenticator}
+		r := httptest.NewRequest(http.MethodPost, "/metadata", nil)
+		r = r.WithContext(auth.NewContext(r.Context(), authenticator["other-team"]))
+		assert.NoError(t, s.authorizeMaintainer(r, "App title 2"))
+	})
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/medhir/yaml-api/storage"
+)
+
+// generationETag identifies the representation of a resource built from a generation of the store. ETags are weak,
+// since compressed and uncompressed responses are equivalent but not byte for byte identical.
+func generationETag(generation uint64) string {
+	return fmt.Sprintf(`W/"%d"`, generation)
+}
+
+// revisionETag identifies a revision of stored metadata. Unlike generation ETags it is strong, so that If-Match can
+// compare it as RFC 7232 requires. Compressed responses carry it with the content coding added by codingETag, since
+// a strong ETag must identify the exact bytes of a representation.
+func revisionETag(revision int) string {
+	return fmt.Sprintf(`"%d"`, revision)
+}
+
+// codingETag returns the strong ETag of a representation sent with a content coding, such as "3-gzip" for "3".
+// Weak ETags are returned as they are, since they already allow representations to differ.
+func codingETag(etag, encoding string) string {
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// withoutCoding removes the content coding added by codingETag, so that an ETag identifies the resource again
+func withoutCoding(etag string) string {
+	for _, encoding := range compressedEncodings {
+		if suffix := "-" + encoding + `"`; strings.HasSuffix(etag, suffix) {
+			return strings.TrimSuffix(etag, suffix) + `"`
+		}
+	}
+	return etag
+}
+
+// ifMatch converts the If-Match header of a request into preconditions on the revision of the stored metadata it
+// modifies. Requests without the header, or with *, may modify any revision. If-Match uses strong comparison, so weak
+// ETags never match, but the ETags of compressed responses match the revision they were sent for. If none of the listed ETags can match a revision, it writes an error response and returns false.
+func ifMatch(w http.ResponseWriter, r *http.Request) ([]storage.Precondition, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil, true
+	}
+	revisions := []int{}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return nil, true
+		}
+		if !strings.HasPrefix(candidate, `"`) || !strings.HasSuffix(candidate, `"`) {
+			continue
+		}
+		revision, err := strconv.Atoi(strings.Trim(withoutCoding(candidate), `"`))
+		if err == nil {
+			revisions = append(revisions, revision)
+		}
+	}
+	if len(revisions) == 0 {
+		writeProblem(w, http.StatusPreconditionFailed, fmt.Sprintf("If-Match must list strong ETags of metadata revisions, such as %s, not %s", revisionETag(1), header))
+		return nil, false
+	}
+	return []storage.Precondition{storage.IfRevision(revisions...)}, true
+}
+
+// writePreconditionFailed rejects a write to metadata that was modified since the client read it, with the ETag of
+// its current revision
+func writePreconditionFailed(w http.ResponseWriter, err *storage.RevisionError) {
+	w.Header().Set("ETag", revisionETag(err.Current))
+	writeProblem(w, http.StatusPreconditionFailed, err.Error()+", since it was modified after it was read")
+}
+
+// notModified sets the validators of a response, and writes 304 Not Modified when the conditional headers of a GET
+// or HEAD request show that the client already has the current representation. If-None-Match takes precedence over
+// If-Modified-Since, as it does in RFC 7232. Clients must revalidate before reusing a response.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || modified.Truncate(time.Second).After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether a list of ETags, from an If-None-Match header, includes etag by weak comparison.
+// Content codings added by codingETag are ignored, so a compressed response revalidates an uncompressed one.
+func etagMatches(list string, etag string) bool {
+	etag = withoutCoding(strings.TrimPrefix(etag, "W/"))
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || withoutCoding(strings.TrimPrefix(candidate, "W/")) == etag {
+			return true
+		}
+	}
+	return false
+}
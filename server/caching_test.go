@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_conditionalRequests(t *testing.T) {
+	store := storage.NewStorage()
+	md := &storage.Metadata{Title: "Cached app", Version: "1.0.0"}
+	assert.NoError(t, store.AddMetadata(context.Background(), md))
+	s := newTestServer(WithStorage(store))
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, target := range []string{"/metadata?title=cached", "/metadata/" + md.ID} {
+		t.Run("Responses are revalidated with their ETag for "+target, func(t *testing.T) {
+			w := get(target, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+			etag := w.Header().Get("ETag")
+			assert.NotEmpty(t, etag)
+			assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+			assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+
+			w = get(target, http.Header{"If-None-Match": {`"other", ` + etag}})
+			assert.Equal(t, http.StatusNotModified, w.Code)
+			assert.Empty(t, w.Body.String())
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+
+			_, err := store.DeprecateMetadata(md.ID, storage.Deprecation{Message: "use 2.0.0"})
+			assert.NoError(t, err)
+			w = get(target, http.Header{"If-None-Match": {etag}})
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NotEqual(t, etag, w.Header().Get("ETag"))
+		})
+	}
+
+	t.Run("Responses are revalidated with their modification time without an ETag", func(t *testing.T) {
+		target := "/metadata/" + md.ID
+		later := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+		earlier := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		assert.Equal(t, http.StatusNotModified, get(target, http.Header{"If-Modified-Since": {later}}).Code)
+		assert.Equal(t, http.StatusOK, get(target, http.Header{"If-Modified-Since": {earlier}}).Code)
+		assert.Equal(t, http.StatusOK, get(target, http.Header{"If-Modified-Since": {later}, "If-None-Match": {`W/"0"`}}).Code)
+	})
+}
+
+func Test_etagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`W/"1"`, `W/"1"`))
+	assert.True(t, etagMatches(`"1"`, `W/"1"`))
+	assert.True(t, etagMatches(`"2", W/"1"`, `W/"1"`))
+	assert.True(t, etagMatches(`*`, `W/"1"`))
+	assert.False(t, etagMatches(`W/"2"`, `W/"1"`))
+	assert.True(t, etagMatches(`"3-gzip"`, `"3"`))
+	assert.True(t, etagMatches(`"3"`, `"3-deflate"`))
+	assert.False(t, etagMatches(`"3-gzip"`, `"4"`))
+}
+
+func Test_ifMatch(t *testing.T) {
+	authenticator := fakeAuthenticator{"admin": {Name: "admin", Scopes: []auth.Scope{auth.ScopeAdmin}}}
+	store := storage.NewStorage(storage.WithMXCheck(false))
+	s := newTestServer(WithStorage(store), WithAuthenticator(authenticator))
+	md := &storage.Metadata{
+		Title:       "Concurrent app",
+		Version:     "1.0.0",
+		Maintainers: []storage.Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}},
+		Company:     "Company",
+		Website:     "https://website.com",
+		Source:      "https://github.com/company/app",
+		License:     "Apache-2.0",
+		Description: "An app written concurrently",
+	}
+	assert.NoError(t, store.AddMetadata(context.Background(), md))
+	send := func(method, action, ifMatch, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/metadata/"+md.ID+action, strings.NewReader(body))
+		r.Header.Set(auth.APIKeyHeader, "admin")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	update := `title: Concurrent app
+version: 1.0.0
+maintainers:
+- name: Bill Bob
+  email: bill@gmail.com
+company: Company
+website: https://website.com
+source: https://github.com/company/app
+license: Apache-2.0
+description: An app updated concurrently
+`
+
+	t.Run("Metadata is read with the ETag of its revision", func(t *testing.T) {
+		w := send(http.MethodGet, "", "", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("Writes succeed when If-Match lists the current revision", func(t *testing.T) {
+		w := send(http.MethodPut, "", `"1"`, update)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+		w = send(http.MethodPatch, "", `"0", "2"`, "license: MIT\n")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+		w = send(http.MethodPost, "/deprecate", `"3"`, `{"message": "use 2.0.0"}`)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("Patches keep the attributes they leave out", func(t *testing.T) {
+		stored, err := store.GetMetadata(md.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "MIT", stored.License)
+		assert.Equal(t, "An app updated concurrently", stored.Description)
+		assert.Equal(t, md.Maintainers, stored.Maintainers)
+	})
+
+	t.Run("Writes fail when If-Match lists a stale revision", func(t *testing.T) {
+		for _, w := range []*httptest.ResponseRecorder{
+			send(http.MethodPut, "", `"1"`, update),
+			send(http.MethodPatch, "", `"2"`, "license: MIT\n"),
+			send(http.MethodPost, "/yank", `"3"`, ""),
+			send(http.MethodDelete, "", `"3"`, ""),
+		} {
+			assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+			assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+			assert.Contains(t, w.Body.String(), "modified after it was read")
+		}
+		stored, err := store.GetMetadata(md.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, stored.Revision)
+		assert.Equal(t, storage.Deprecated, stored.State)
+	})
+
+	t.Run("Writes fail when If-Match lists only weak or unknown ETags", func(t *testing.T) {
+		for _, etag := range []string{`W/"4"`, `"latest"`} {
+			w := send(http.MethodPost, "/yank", etag, "")
+			assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+			assert.Contains(t, w.Body.String(), "If-Match must list strong ETags of metadata revisions")
+		}
+	})
+
+	t.Run("The ETags of compressed responses identify the content coding, and satisfy If-Match", func(t *testing.T) {
+		long := &storage.Metadata{
+			Title:       "Compressed app",
+			Version:     "1.0.0",
+			Maintainers: []storage.Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}},
+			Company:     "Company",
+			Website:     "https://website.com",
+			Source:      "https://github.com/company/app",
+			License:     "MIT",
+			Description: strings.Repeat("A description long enough to be compressed. ", 50),
+		}
+		assert.NoError(t, store.AddMetadata(context.Background(), long))
+		r := httptest.NewRequest(http.MethodGet, "/metadata/"+long.ID, nil)
+		r.Header.Set(auth.APIKeyHeader, "admin")
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		etag := w.Header().Get("ETag")
+		assert.Equal(t, `"1-gzip"`, etag)
+
+		update := strings.Replace(strings.Replace(update, "Concurrent app", long.Title, 1), "Apache-2.0", "MIT", 1)
+		r = httptest.NewRequest(http.MethodPut, "/metadata/"+long.ID, strings.NewReader(update))
+		r.Header.Set(auth.APIKeyHeader, "admin")
+		r.Header.Set("If-Match", etag)
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("Writes to any revision succeed with If-Match *, or without If-Match", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(http.MethodPost, "/yank", "*", "").Code)
+		assert.Equal(t, http.StatusOK, send(http.MethodPatch, "", "", "license: Apache-2.0\n").Code)
+		assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "", "", "").Code)
+	})
+}
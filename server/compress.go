@@ -0,0 +1,217 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressedEncodings are the content codings responses are compressed with, most preferred first
+var compressedEncodings = []string{"gzip", "deflate"}
+
+// compress encodes responses with the most preferred content coding the client accepts. Event streams, responses
+// without a body, bodies smaller than minCompressedBytes and content that is compressed already are sent as they
+// are.
+func (s *Server) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer func() {
+			err := cw.Close()
+			if err != nil {
+				s.logger.Error("could not compress response", "encoding", encoding, "error", err)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns the most preferred compressed content coding allowed by an Accept-Encoding header, or an
+// empty string if the response should not be compressed. Codings are preferred by quality value, and then in the
+// order of compressedEncodings.
+func acceptedEncoding(header string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		if coding != "" {
+			qualities[coding] = quality
+		}
+	}
+	best, bestQuality := "", 0.0
+	for _, encoding := range compressedEncodings {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// minCompressedBytes is the smallest body that is compressed. Smaller bodies would barely shrink, if at all, once
+// the compressed format's own header and trailer are added.
+const minCompressedBytes = 1024
+
+// compressedContentTypes are the media types, or prefixes of media types, whose content is compressed already, and
+// would not get any smaller by being compressed again
+var compressedContentTypes = []string{
+	"application/gzip", "application/x-gzip", "application/zip", "application/zstd", "application/x-bzip2",
+	"application/x-xz", "application/x-7z-compressed", "image/", "video/", "audio/", "font/woff",
+}
+
+// alreadyCompressed reports whether a Content-Type header names a compressed format. SVG images are text, and are
+// the only images worth compressing.
+func alreadyCompressed(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+	for _, compressed := range compressedContentTypes {
+		if strings.HasPrefix(mediaType, compressed) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter compresses the body of a response once its headers show that it should be compressed. When the
+// length of the body is not known up front, it is buffered until it reaches minCompressedBytes, and sent as it is
+// if it ends before that.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	// writer compresses the body, and is nil until the headers are written or if the body is not compressed
+	writer      io.WriteCloser
+	wroteHeader bool
+	// buffering is set while the status is held back until the body is known to be large enough to compress
+	buffering bool
+	status    int
+	buf       []byte
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	h := cw.Header()
+	bodyless := status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified
+	streamed := strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+	if bodyless || streamed || h.Get("Content-Encoding") != "" || alreadyCompressed(h.Get("Content-Type")) {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if length := h.Get("Content-Length"); length != "" {
+		n, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || n < minCompressedBytes {
+			cw.ResponseWriter.WriteHeader(status)
+			return
+		}
+		cw.startCompressing(status)
+		return
+	}
+	cw.buffering, cw.status = true, status
+}
+
+// startCompressing sends the headers of a compressed response, and sets up the writer compressing its body. A strong
+// ETag is changed to identify the compressed representation.
+func (cw *compressWriter) startCompressing(status int) {
+	h := cw.Header()
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	if etag := h.Get("ETag"); etag != "" {
+		h.Set("ETag", codingETag(etag, cw.encoding))
+	}
+	if cw.encoding == "gzip" {
+		cw.writer = gzip.NewWriter(cw.ResponseWriter)
+	} else {
+		// the deflate content coding is the zlib format
+		cw.writer = zlib.NewWriter(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// compressBuffered starts compressing a response whose status was held back, and compresses what was buffered
+func (cw *compressWriter) compressBuffered() error {
+	cw.buffering = false
+	cw.startCompressing(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.writer.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			// the content type is sniffed from the uncompressed body, as net/http would have done
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < minCompressedBytes {
+			return len(b), nil
+		}
+		err := cw.compressBuffered()
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.writer == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.writer.Write(b)
+}
+
+// Flush supports streaming responses, sending whatever has been compressed so far. A response that is flushed
+// before its body reaches minCompressedBytes is compressed anyway, since more of it is likely to follow.
+func (cw *compressWriter) Flush() {
+	if cw.buffering {
+		_ = cw.compressBuffered()
+	}
+	if flusher, ok := cw.writer.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the compressed body, or sends a buffered body that turned out too small to compress
+func (cw *compressWriter) Close() error {
+	if cw.buffering {
+		cw.buffering = false
+		cw.Header().Set("Content-Length", strconv.Itoa(len(cw.buf)))
+		cw.ResponseWriter.WriteHeader(cw.status)
+		_, err := cw.ResponseWriter.Write(cw.buf)
+		return err
+	}
+	if cw.writer == nil {
+		return nil
+	}
+	return cw.writer.Close()
+}
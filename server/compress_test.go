@@ -0,0 +1,142 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_compress(t *testing.T) {
+	s := newTestServer()
+	get := func(method, target, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}
+	uncompressed := get(http.MethodGet, "/openapi.json", "")
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		encoding       string
+	}{
+		{name: "Responses are not compressed without Accept-Encoding"},
+		{name: "Responses are compressed with gzip", acceptEncoding: "gzip", encoding: "gzip"},
+		{name: "Responses are compressed with deflate", acceptEncoding: "deflate", encoding: "deflate"},
+		{name: "gzip is preferred over deflate", acceptEncoding: "deflate, gzip", encoding: "gzip"},
+		{name: "Codings are preferred by quality", acceptEncoding: "gzip;q=0.5, deflate", encoding: "deflate"},
+		{name: "Any coding is accepted with a wildcard", acceptEncoding: "br, *", encoding: "gzip"},
+		{name: "Codings with a quality of 0 are refused", acceptEncoding: "gzip;q=0, deflate;q=0"},
+		{name: "Unsupported codings are not used", acceptEncoding: "br, zstd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(http.MethodGet, "/openapi.json", tt.acceptEncoding)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			body := io.Reader(w.Body)
+			if tt.encoding != "" {
+				var err error
+				body, err = decoders[tt.encoding](w.Body)
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+			data, err := ioutil.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Equal(t, uncompressed.Body.String(), string(data))
+		})
+	}
+
+	t.Run("Responses without a body are not compressed", func(t *testing.T) {
+		w := get(http.MethodHead, "/openapi.json", "gzip")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+		r := httptest.NewRequest(http.MethodGet, "/metadata?title=app", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set("If-None-Match", "*")
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Body.String())
+	})
+
+	serve := func(h http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		s.compress(h).ServeHTTP(w, r)
+		return w
+	}
+	large := strings.Repeat("a", minCompressedBytes)
+
+	t.Run("Small bodies are not compressed", func(t *testing.T) {
+		w := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, `{"id":"abc"}`)
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "12", w.Header().Get("Content-Length"))
+		assert.Equal(t, `{"id":"abc"}`, w.Body.String())
+
+		w = serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "5")
+			_, _ = io.WriteString(w, "hello")
+		})
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "hello", w.Body.String())
+	})
+
+	t.Run("Bodies written in small pieces are compressed once they are large enough", func(t *testing.T) {
+		w := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			for i := 0; i < len(large); i += 100 {
+				_, _ = io.WriteString(w, large[i:i+1])
+			}
+			_, _ = io.WriteString(w, large)
+		})
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		reader, err := gzip.NewReader(w.Body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		data, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Len(t, data, len(large)+(len(large)+99)/100)
+	})
+
+	t.Run("Content that is compressed already is not compressed again", func(t *testing.T) {
+		for _, contentType := range []string{"application/gzip", "application/zip", "image/png", "video/mp4; codecs=avc1"} {
+			w := serve(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				_, _ = io.WriteString(w, large)
+			})
+			assert.Empty(t, w.Header().Get("Content-Encoding"), contentType)
+			assert.Equal(t, large, w.Body.String(), contentType)
+		}
+		w := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/svg+xml")
+			_, _ = io.WriteString(w, large)
+		})
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/medhir/yaml-api/events"
+	"github.com/medhir/yaml-api/storage"
+)
+
+// heartbeatInterval is how often a comment is written to idle event streams, so proxies keep them open
+const heartbeatInterval = 15 * time.Second
+
+// publishChanges publishes an event, and dispatches webhooks, for every modification to stored metadata
+func (s *Server) publishChanges() {
+	s.storage.OnChange(func(change storage.Change) {
+		_, err := s.events.Publish(string(change.Type), change)
+		if err != nil {
+			s.logger.Error("could not publish change event", "error", err)
+		}
+		if s.webhooks != nil {
+			s.webhooks.Dispatch(change)
+		}
+	})
+}
+
+// handleEvents streams changes to stored metadata as server-sent events.
+// Clients resume from the last event they received with the Last-Event-ID header, or the last_event_id query
+// parameter, and can filter events with a comma separated list of types, such as types=create,delete.
+func (s *Server) handleEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeProblem(w, http.StatusInternalServerError, "streaming is not supported")
+			return
+		}
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = r.URL.Query().Get("last_event_id")
+		}
+		var lastID uint64
+		if lastEventID != "" {
+			var err error
+			lastID, err = strconv.ParseUint(lastEventID, 10, 64)
+			if err != nil {
+				writeProblem(w, http.StatusBadRequest, fmt.Sprintf("last event ID must be a positive integer, got %q", lastEventID))
+				return
+			}
+		}
+		types := map[string]bool{}
+		for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types[t] = true
+			}
+		}
+
+		backlog, sub, complete := s.events.Subscribe(lastID)
+		defer sub.Close()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 3000\n\n")
+		if !complete {
+			// some events were dropped from the buffer before the client reconnected,
+			// so it should resynchronize with a full search
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+		for _, event := range backlog {
+			writeEvent(w, event, types)
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				writeEvent(w, event, types)
+				flusher.Flush()
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event, types map[string]bool) {
+	if len(types) > 0 && !types[event.Type] {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/medhir/yaml-api/events"
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// readEvent reads the id, event and data fields of the next event in a stream, skipping comments and retry fields
+func readEvent(t *testing.T, stream *bufio.Reader) map[string]string {
+	event := map[string]string{}
+	for {
+		line, err := stream.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return event
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if _, ok := event["event"]; ok {
+				return event
+			}
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			event[parts[0]] = parts[1]
+		}
+	}
+}
+
+func Test_handleEvents(t *testing.T) {
+	s := &Server{storage: storage.NewStorage(), events: events.NewBroker(10)}
+	s.publishChanges()
+	ts := httptest.NewServer(s.handleEvents())
+	defer ts.Close()
+
+	md := &storage.Metadata{Title: "App title 1", Version: "1.0.0"}
+	assert.NoError(t, s.storage.AddMetadata(context.Background(), md))
+
+	t.Run("Changes are streamed as they happen", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "?types=deprecate,delete")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		stream := bufio.NewReader(resp.Body)
+		_, err = s.storage.YankMetadata(md.ID, "filtered out")
+		assert.NoError(t, err)
+		assert.NoError(t, s.storage.DeleteMetadata(md.ID))
+		event := readEvent(t, stream)
+		assert.Equal(t, "3", event["id"])
+		assert.Equal(t, "delete", event["event"])
+		assert.Contains(t, event["data"], `"id":"`+md.ID+`"`)
+	})
+
+	t.Run("Clients resume after the last event they received", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Last-Event-ID", "1")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		stream := bufio.NewReader(resp.Body)
+		assert.Equal(t, "2", readEvent(t, stream)["id"])
+		assert.Equal(t, "3", readEvent(t, stream)["id"])
+	})
+
+	t.Run("Invalid event IDs are rejected", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "?last_event_id=latest")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
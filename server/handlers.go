@@ -1,76 +1,586 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/medhir/yaml-api/storage"
 	"gopkg.in/yaml.v2"
 )
 
+// searchQuery reads the attributes searched for from the query of a request, and whether yanked metadata is included
+func searchQuery(r *http.Request) (map[string]string, bool, error) {
+	values := r.URL.Query()
+	includeYanked := false
+	if v := values.Get("include_yanked"); v != "" {
+		var err error
+		includeYanked, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("include_yanked must be true or false, got %q", v)
+		}
+		values.Del("include_yanked")
+	}
+	searchTerms := map[string]string{}
+	for k, v := range values {
+		searchTerms[k] = v[0]
+	}
+	return searchTerms, includeYanked, nil
+}
+
 func (s *Server) handleGetMetadata() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		values := r.URL.Query()
-		searchTerms := map[string]string{}
-		for k, v := range values {
-			searchTerms[k] = v[0]
+		searchTerms, includeYanked, err := searchQuery(r)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
 		}
-		results, err := s.storage.LookupMetadata(searchTerms)
+		// the generation is read before searching, so that responses are never tagged with a later generation than
+		// the one they were built from
+		generation, modified := s.storage.Generation()
+		results, err := s.storage.LookupMetadata(r.Context(), searchTerms, includeYanked)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not retreive metadata by provided search terms:\n%v", err), http.StatusBadRequest)
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("could not retreive metadata by provided search terms:\n%v", err))
+			return
+		}
+		if notModified(w, r, generationETag(generation), modified) {
 			return
 		}
 		data, err := json.Marshal(results)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not encode JSON:\n%v", err), http.StatusInternalServerError)
+			writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not encode JSON:\n%v", err))
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_, err = w.Write(data)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not write JSON to response:\n%v", err), http.StatusInternalServerError)
+			writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not write JSON to response:\n%v", err))
+			return
+		}
+	}
+}
+
+// handleExplainMetadata describes how a search with the same query as GET /metadata finds its results: the terms
+// each queried value is analyzed into, the metadata each term matches, and how the matches are narrowed down
+func (s *Server) handleExplainMetadata() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		searchTerms, includeYanked, err := searchQuery(r)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		explanation, err := s.storage.ExplainLookup(searchTerms, includeYanked)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("could not explain search by provided search terms:\n%v", err))
+			return
+		}
+		writeJSON(w, http.StatusOK, explanation)
+	}
+}
+
+// unmarshalMetadata decodes submitted YAML. With strict YAML enabled, unknown and duplicate attributes are rejected.
+func (s *Server) unmarshalMetadata(data []byte, metadata *storage.Metadata) error {
+	if s.strictYAML {
+		return yaml.UnmarshalStrict(data, metadata)
 	}
+	return yaml.Unmarshal(data, metadata)
 }
 
 func (s *Server) handlePostMetadata() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		yamlBytes, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not read request body:\n%v", err), http.StatusBadRequest)
+			writeBodyError(w, "could not read request body", err)
+			return
+		}
+		metadata := &storage.Metadata{}
+		err = s.unmarshalMetadata(yamlBytes, metadata)
+		if err != nil {
+			s.metrics.validationFailed(err)
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("request does not contain valid YAML:\n%v", err))
+			return
+		}
+		err = s.storage.ValidateMetadata(metadata)
+		if err != nil {
+			s.metrics.validationFailed(err)
+			writeInvalidMetadata(w, err)
+			return
+		}
+		err = s.authorizeMaintainer(r, metadata.Title)
+		if err != nil {
+			writeAuthzError(w, err)
+			return
+		}
+		err = s.storage.AddMetadata(r.Context(), metadata)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Location", "/metadata/"+metadata.ID)
+		writeJSON(w, http.StatusCreated, metadata)
+	}
+}
+
+// decodeMetadataDocuments decodes every document of a multi-document YAML body, separated by ---
+func (s *Server) decodeMetadataDocuments(data []byte) ([]*storage.Metadata, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.SetStrict(s.strictYAML)
+	documents := []*storage.Metadata{}
+	for {
+		metadata := &storage.Metadata{}
+		err := decoder.Decode(metadata)
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", len(documents), err)
+		}
+		documents = append(documents, metadata)
+	}
+}
+
+// handlePostMetadataBatch stores every document of a multi-document YAML body. Every document is validated and
+// authorized before any are stored, so invalid batches store nothing.
+func (s *Server) handlePostMetadataBatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		yamlBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, "could not read request body", err)
+			return
+		}
+		documents, err := s.decodeMetadataDocuments(yamlBytes)
+		if err != nil {
+			s.metrics.validationFailed(err)
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("request does not contain valid YAML:\n%v", err))
+			return
+		}
+		if len(documents) == 0 {
+			writeProblem(w, http.StatusBadRequest, "request does not contain any metadata documents")
+			return
+		}
+		problems := []Problem{}
+		for i, metadata := range documents {
+			err = s.storage.ValidateMetadata(metadata)
+			if err != nil {
+				s.metrics.validationFailed(err)
+				problem := invalidMetadataProblem(err)
+				index := i
+				problem.Document = &index
+				problems = append(problems, problem)
+			}
+		}
+		if len(problems) > 0 {
+			problem := newProblem(http.StatusBadRequest, fmt.Sprintf("%d of %d documents are invalid", len(problems), len(documents)))
+			problem.Errors = problems
+			writeProblemBody(w, problem)
+			return
+		}
+		for i, metadata := range documents {
+			err = s.authorizeMaintainer(r, metadata.Title)
+			if err != nil {
+				problem := authzProblem(err)
+				index := i
+				problem.Document = &index
+				writeProblemBody(w, problem)
+				return
+			}
+		}
+		for _, metadata := range documents {
+			err = s.storage.AddMetadata(r.Context(), metadata)
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		writeJSON(w, http.StatusCreated, documents)
+	}
+}
+
+func (s *Server) handleGetMetadataByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := pathParam(r, "id")
+		md, err := s.storage.GetMetadata(id)
+		if err == storage.ErrNotFound {
+			writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found with id %s", id))
+			return
+		}
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		setLifecycleHeaders(w, md)
+		if notModified(w, r, revisionETag(md.Revision), md.Updated) {
+			return
+		}
+		writeJSON(w, http.StatusOK, md)
+	}
+}
+
+// setLifecycleHeaders surfaces deprecated and yanked states to clients that do not inspect the response body
+func setLifecycleHeaders(w http.ResponseWriter, md *storage.Metadata) {
+	switch md.State {
+	case storage.Deprecated:
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Warning", fmt.Sprintf("299 - %s", strconv.Quote("deprecated: "+md.Deprecation.Message)))
+		if md.Deprecation.Replacement != "" {
+			w.Header().Set("Link", fmt.Sprintf("</metadata/%s>; rel=\"successor-version\"", md.Deprecation.Replacement))
+		}
+	case storage.Yanked:
+		w.Header().Set("Warning", fmt.Sprintf("299 - %s", strconv.Quote("yanked: "+md.YankReason)))
+	}
+}
+
+// authorizeStoredMetadata checks that the caller of a request may modify the stored metadata with the given ID,
+// writing an error response if the metadata does not exist or the caller is not allowed to modify it. Since the
+// metadata can change before it is written, writes must repeat the check with ifMaintainer.
+func (s *Server) authorizeStoredMetadata(w http.ResponseWriter, r *http.Request, id string) (*storage.Metadata, bool) {
+	md, err := s.storage.GetMetadata(id)
+	if err == storage.ErrNotFound {
+		writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found with id %s", id))
+		return nil, false
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	err = s.authorizeMaintainer(r, md.Title)
+	if err != nil {
+		writeAuthzError(w, err)
+		return nil, false
+	}
+	return md, true
+}
+
+func (s *Server) handlePutMetadata() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := pathParam(r, "id")
+		stored, ok := s.authorizeStoredMetadata(w, r, id)
+		if !ok {
+			return
+		}
+		preconditions, ok := ifMatch(w, r)
+		if !ok {
+			return
+		}
+		yamlBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, "could not read request body", err)
 			return
 		}
 		metadata := &storage.Metadata{}
-		err = yaml.Unmarshal(yamlBytes, metadata)
+		err = s.unmarshalMetadata(yamlBytes, metadata)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("request does not contain valid YAML:\n%v", err), http.StatusBadRequest)
+			s.metrics.validationFailed(err)
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("request does not contain valid YAML:\n%v", err))
 			return
 		}
 		err = s.storage.ValidateMetadata(metadata)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.metrics.validationFailed(err)
+			writeInvalidMetadata(w, err)
+			return
+		}
+		// renaming metadata moves it to another application, which the caller must also be allowed to modify
+		if !strings.EqualFold(strings.TrimSpace(metadata.Title), strings.TrimSpace(stored.Title)) {
+			err = s.authorizeMaintainer(r, metadata.Title)
+			if err != nil {
+				writeAuthzError(w, err)
+				return
+			}
+		}
+		md, err := s.storage.UpdateMetadata(id, metadata, append(preconditions, s.ifMaintainer(r, metadata.Title))...)
+		if isAuthzError(err) {
+			writeAuthzError(w, err)
+			return
+		}
+		if err == storage.ErrNotFound {
+			writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found with id %s", id))
+			return
+		}
+		if revisionErr, ok := err.(*storage.RevisionError); ok {
+			writePreconditionFailed(w, revisionErr)
+			return
+		}
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		setLifecycleHeaders(w, md)
+		w.Header().Set("ETag", revisionETag(md.Revision))
+		writeJSON(w, http.StatusOK, md)
+	}
+}
+
+// handlePatchMetadata replaces only the attributes present in the YAML body, keeping the others as they are stored.
+// The patch is written against the revision it was applied to, and is applied again to the latest revision if the
+// metadata is modified in the meantime, unless the request sent If-Match.
+func (s *Server) handlePatchMetadata() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := pathParam(r, "id")
+		stored, ok := s.authorizeStoredMetadata(w, r, id)
+		if !ok {
+			return
+		}
+		preconditions, ok := ifMatch(w, r)
+		if !ok {
+			return
+		}
+		patch, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, "could not read request body", err)
+			return
+		}
+		for attempt := 1; ; attempt++ {
+			patched := *stored
+			metadata := &patched
+			err = s.unmarshalMetadata(patch, metadata)
+			if err != nil {
+				s.metrics.validationFailed(err)
+				writeProblem(w, http.StatusBadRequest, fmt.Sprintf("request does not contain valid YAML:\n%v", err))
+				return
+			}
+			err = s.storage.ValidateMetadata(metadata)
+			if err != nil {
+				s.metrics.validationFailed(err)
+				writeInvalidMetadata(w, err)
+				return
+			}
+			if !strings.EqualFold(strings.TrimSpace(metadata.Title), strings.TrimSpace(stored.Title)) {
+				err = s.authorizeMaintainer(r, metadata.Title)
+				if err != nil {
+					writeAuthzError(w, err)
+					return
+				}
+			}
+			md, err := s.storage.UpdateMetadata(id, metadata,
+				append(preconditions, storage.IfRevision(stored.Revision), s.ifMaintainer(r, metadata.Title))...)
+			if isAuthzError(err) {
+				writeAuthzError(w, err)
+				return
+			}
+			revisionErr, conflict := err.(*storage.RevisionError)
+			if conflict && len(preconditions) == 0 && attempt < 3 {
+				stored, ok = s.authorizeStoredMetadata(w, r, id)
+				if !ok {
+					return
+				}
+				continue
+			}
+			if err == storage.ErrNotFound {
+				writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found with id %s", id))
+				return
+			}
+			if conflict {
+				writePreconditionFailed(w, revisionErr)
+				return
+			}
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			setLifecycleHeaders(w, md)
+			w.Header().Set("ETag", revisionETag(md.Revision))
+			writeJSON(w, http.StatusOK, md)
+			return
+		}
+	}
+}
+
+func (s *Server) handleDeleteMetadata() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := pathParam(r, "id")
+		_, ok := s.authorizeStoredMetadata(w, r, id)
+		if !ok {
+			return
+		}
+		preconditions, ok := ifMatch(w, r)
+		if !ok {
+			return
+		}
+		err := s.storage.DeleteMetadata(id, append(preconditions, s.ifMaintainer(r))...)
+		if isAuthzError(err) {
+			writeAuthzError(w, err)
+			return
+		}
+		if err == storage.ErrNotFound {
+			writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found with id %s", id))
+			return
+		}
+		if revisionErr, ok := err.(*storage.RevisionError); ok {
+			writePreconditionFailed(w, revisionErr)
+			return
+		}
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleDeprecateMetadata() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := pathParam(r, "id")
+		if _, ok := s.authorizeStoredMetadata(w, r, id); !ok {
+			return
+		}
+		preconditions, ok := ifMatch(w, r)
+		if !ok {
+			return
+		}
+		deprecation := storage.Deprecation{}
+		err := json.NewDecoder(r.Body).Decode(&deprecation)
+		if err != nil {
+			writeBodyError(w, "request does not contain a valid JSON deprecation", err)
+			return
+		}
+		md, err := s.storage.DeprecateMetadata(id, deprecation, append(preconditions, s.ifMaintainer(r))...)
+		if isAuthzError(err) {
+			writeAuthzError(w, err)
+			return
+		}
+		if err == storage.ErrNotFound {
+			writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found with id %s", id))
+			return
+		}
+		if revisionErr, ok := err.(*storage.RevisionError); ok {
+			writePreconditionFailed(w, revisionErr)
 			return
 		}
-		err = s.storage.AddMetadata(metadata)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeProblem(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		setLifecycleHeaders(w, md)
+		w.Header().Set("ETag", revisionETag(md.Revision))
+		writeJSON(w, http.StatusOK, md)
 	}
 }
 
-func (s *Server) handleMetadata() http.HandlerFunc {
+func (s *Server) handleYankMetadata() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			s.handleGetMetadata()(w, r)
-		case http.MethodPost:
-			s.handlePostMetadata()(w, r)
+		id := pathParam(r, "id")
+		if _, ok := s.authorizeStoredMetadata(w, r, id); !ok {
+			return
+		}
+		preconditions, ok := ifMatch(w, r)
+		if !ok {
+			return
+		}
+		yank := struct {
+			Reason string `json:"reason"`
+		}{}
+		// a reason is optional, so an empty body is allowed
+		err := json.NewDecoder(r.Body).Decode(&yank)
+		if err != nil && err != io.EOF {
+			writeBodyError(w, "request does not contain a valid JSON yank reason", err)
+			return
+		}
+		md, err := s.storage.YankMetadata(id, yank.Reason, append(preconditions, s.ifMaintainer(r))...)
+		if isAuthzError(err) {
+			writeAuthzError(w, err)
+			return
+		}
+		if err == storage.ErrNotFound {
+			writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found with id %s", id))
+			return
+		}
+		if revisionErr, ok := err.(*storage.RevisionError); ok {
+			writePreconditionFailed(w, revisionErr)
+			return
+		}
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		setLifecycleHeaders(w, md)
+		w.Header().Set("ETag", revisionETag(md.Revision))
+		writeJSON(w, http.StatusOK, md)
+	}
+}
+
+func (s *Server) handleGetAppDiff() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := pathParam(r, "name")
+		values := r.URL.Query()
+		fromVersion, toVersion := values.Get("from"), values.Get("to")
+		if fromVersion == "" || toVersion == "" {
+			writeProblem(w, http.StatusBadRequest, "both from and to query parameters must be provided")
+			return
+		}
+		from, ok := s.lookupVersion(w, name, fromVersion)
+		if !ok {
+			return
+		}
+		to, ok := s.lookupVersion(w, name, toVersion)
+		if !ok {
+			return
+		}
+		switch values.Get("format") {
+		case "", "json":
+			data, err := json.Marshal(storage.DiffMetadata(from, to))
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not encode JSON:\n%v", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, err = w.Write(data)
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not write JSON to response:\n%v", err))
+				return
+			}
+		case "unified":
+			fromYAML, err := yaml.Marshal(from)
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not encode YAML:\n%v", err))
+				return
+			}
+			toYAML, err := yaml.Marshal(to)
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not encode YAML:\n%v", err))
+				return
+			}
+			diff := storage.UnifiedDiff(name+"@"+from.Version, name+"@"+to.Version, string(fromYAML), string(toYAML))
+			w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+			_, err = w.Write([]byte(diff))
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not write diff to response:\n%v", err))
+				return
+			}
 		default:
-			http.Error(w, fmt.Sprintf("unimplemented http handler for method %s", r.Method), http.StatusMethodNotAllowed)
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("unsupported diff format %q, must be json or unified", values.Get("format")))
 		}
 	}
 }
+
+// handleGetAppVersions lists every stored version of an application, lowest first
+func (s *Server) handleGetAppVersions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := pathParam(r, "name")
+		versions := s.storage.Versions(name)
+		if len(versions) == 0 {
+			writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found for %s", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, versions)
+	}
+}
+
+// lookupVersion retrieves a specific version of an application, writing an error response if it cannot be found
+func (s *Server) lookupVersion(w http.ResponseWriter, name, version string) (*storage.Metadata, bool) {
+	md, err := s.storage.GetVersion(name, version)
+	if err == storage.ErrNotFound {
+		writeProblem(w, http.StatusNotFound, fmt.Sprintf("no metadata found for %s version %s", name, version))
+		return nil, false
+	}
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return md, true
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_unmarshalMetadata(t *testing.T) {
+	data := []byte("title: App title 1\nversion: 1.0.0\ncolour: blue\n")
+
+	t.Run("Unknown attributes are ignored by default", func(t *testing.T) {
+		md := &storage.Metadata{}
+		assert.NoError(t, (&Server{}).unmarshalMetadata(data, md))
+		assert.Equal(t, "App title 1", md.Title)
+	})
+
+	t.Run("Unknown attributes are rejected with strict YAML", func(t *testing.T) {
+		err := (&Server{strictYAML: true}).unmarshalMetadata(data, &storage.Metadata{})
+		assert.EqualError(t, err, "yaml: unmarshal errors:\n  line 3: field colour not found in type storage.Metadata")
+	})
+}
+
+func Test_handleExplainMetadata(t *testing.T) {
+	store := storage.NewStorage()
+	md := &storage.Metadata{Title: "The jumping app", Version: "1.0.0"}
+	assert.NoError(t, store.AddMetadata(context.Background(), md))
+	s := newTestServer(WithStorage(store))
+	explain := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metadata/explain?"+query, nil))
+		return w
+	}
+
+	t.Run("Searches are explained", func(t *testing.T) {
+		w := explain("title=The+jumped+app&include_yanked=true")
+		assert.Equal(t, http.StatusOK, w.Code)
+		explanation := storage.Explanation{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &explanation))
+		assert.Equal(t, []string{md.ID}, explanation.Results)
+		if assert.Len(t, explanation.Attributes, 1) {
+			assert.Equal(t, storage.AnalyzedWord{Word: "jumped", Term: "jump"}, explanation.Attributes[0].Words[1])
+		}
+	})
+
+	t.Run("Explaining fails for invalid queries", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, explain("colour=blue").Code)
+		assert.Equal(t, http.StatusBadRequest, explain("title=app&include_yanked=sometimes").Code)
+	})
+}
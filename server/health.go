@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/medhir/yaml-api/version"
+)
+
+// readinessCheck reports why a server cannot serve traffic yet, or nil when it can
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// WithReadinessCheck adds a named check that must pass before the server reports itself ready
+func WithReadinessCheck(name string, check func() error) Option {
+	return func(s *Server) {
+		s.readinessChecks = append(s.readinessChecks, readinessCheck{name: name, check: check})
+	}
+}
+
+// errShuttingDown fails readiness once a graceful shutdown has started, so load balancers drain traffic
+var errShuttingDown = errors.New("server is shutting down")
+
+// healthStatus is the response body of /healthz and /readyz
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// handleHealthz reports that the process is alive and serving requests
+func (s *Server) handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, healthStatus{Status: "ok"})
+	}
+}
+
+// handleReadyz reports whether every readiness check passes, responding with 503 when any fails
+func (s *Server) handleReadyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := healthStatus{Status: "ok", Checks: map[string]string{}}
+		checks := append([]readinessCheck{{name: "shutdown", check: s.checkNotShuttingDown}}, s.readinessChecks...)
+		for _, c := range checks {
+			err := c.check()
+			if err != nil {
+				status.Status = "unavailable"
+				status.Checks[c.name] = err.Error()
+				continue
+			}
+			status.Checks[c.name] = "ok"
+		}
+		if status.Status != "ok" {
+			writeJSON(w, http.StatusServiceUnavailable, status)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+func (s *Server) checkNotShuttingDown() error {
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		return errShuttingDown
+	}
+	return nil
+}
+
+// handleVersion describes the running build
+func (s *Server) handleVersion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, version.Get())
+	}
+}
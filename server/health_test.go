@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/medhir/yaml-api/version"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handleReadyz(t *testing.T) {
+	loaded := errors.New("write-ahead log is still being replayed")
+	s := &Server{}
+	WithReadinessCheck("storage", func() error { return loaded })(s)
+	readyz := func() (int, healthStatus) {
+		w := httptest.NewRecorder()
+		s.handleReadyz()(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		status := healthStatus{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return w.Code, status
+	}
+
+	t.Run("Readiness fails until every check passes", func(t *testing.T) {
+		code, status := readyz()
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, healthStatus{Status: "unavailable", Checks: map[string]string{"shutdown": "ok", "storage": loaded.Error()}}, status)
+		loaded = nil
+		code, status = readyz()
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ok", status.Status)
+	})
+
+	t.Run("Readiness fails once shutdown starts, while the process stays healthy", func(t *testing.T) {
+		s.shuttingDown = 1
+		code, status := readyz()
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, errShuttingDown.Error(), status.Checks["shutdown"])
+
+		w := httptest.NewRecorder()
+		s.handleHealthz()(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func Test_handleVersion(t *testing.T) {
+	version.Version, version.Commit = "1.2.0", "abc123"
+	defer func() { version.Version, version.Commit = "", "" }()
+	w := httptest.NewRecorder()
+	(&Server{}).handleVersion()(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	info := version.Info{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, version.Info{Version: "1.2.0", Commit: "abc123", GoVersion: runtime.Version()}, info)
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/medhir/yaml-api/auth"
+)
+
+// DefaultMaxBodyBytes is the largest request body accepted by default
+const DefaultMaxBodyBytes = 10 << 20
+
+// DefaultMaxImportBytes is the largest import accepted by default, which is larger than other bodies since it holds
+// a whole export
+const DefaultMaxImportBytes = 512 << 20
+
+// WithMaxBodyBytes sets the largest request body accepted. Larger requests are rejected with 413.
+func WithMaxBodyBytes(max int64) Option {
+	return func(s *Server) {
+		s.maxBodyBytes = max
+	}
+}
+
+// WithMaxImportBytes sets the largest body accepted by POST /admin/import, instead of the limit of other requests
+func WithMaxImportBytes(max int64) Option {
+	return func(s *Server) {
+		s.maxImportBytes = max
+	}
+}
+
+// RateLimit throttles the requests made by each principal, or by each client address for anonymous requests. Up to
+// Burst requests are allowed at once, and the allowance is refilled at RequestsPerMinute. A zero RequestsPerMinute
+// allows every request.
+type RateLimit struct {
+	RequestsPerMinute int
+	Burst             int
+}
+
+// WithRateLimits throttles requests to routes requiring the read scope, and separately those requiring the write or
+// admin scope. Throttled requests are rejected with 429, and a Retry-After header saying when to try again. Without
+// rate limits, requests are not throttled.
+func WithRateLimits(read, write RateLimit) Option {
+	return func(s *Server) {
+		s.readLimiter = newRateLimiter(read)
+		s.writeLimiter = newRateLimiter(write)
+		s.readAddressLimiter = newRateLimiter(read)
+		s.writeAddressLimiter = newRateLimiter(write)
+	}
+}
+
+// limitBody limits request bodies to the maximum size. Bodies are not read here, so nothing is buffered before a
+// request is authenticated: handlers fail to read a larger body, and writeBodyError rejects it with 413.
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && r.Body != http.NoBody && s.maxBodyBytes > 0 {
+			r.Body = &limitedBody{ReadCloser: r.Body, limit: s.maxBodyBytes, contentLength: r.ContentLength}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBodyTo is middleware changing the body limit of a route, such as the import, from that of limitBody
+func (s *Server) limitBodyTo(max int64) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if body, ok := r.Body.(*limitedBody); ok && max > 0 {
+				body.limit = max
+			} else if ok {
+				r.Body = body.ReadCloser
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBody fails to read more than limit bytes of a request body with a *bodyTooLargeError. Bodies declaring a
+// larger Content-Length fail on their first read, without reading anything.
+type limitedBody struct {
+	io.ReadCloser
+	limit         int64
+	contentLength int64
+	read          int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.contentLength > b.limit || b.read > b.limit {
+		return 0, &bodyTooLargeError{limit: b.limit}
+	}
+	// one byte more than the limit is read, to tell a body of exactly the limit from a larger one
+	if remaining := b.limit - b.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), &bodyTooLargeError{limit: b.limit}
+	}
+	return n, err
+}
+
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body is larger than the limit of %d bytes", e.limit)
+}
+
+// writeBodyError rejects a request whose body could not be read or decoded, with 413 if the body was too large and
+// otherwise with 400 and the message
+func writeBodyError(w http.ResponseWriter, message string, err error) {
+	if tooLarge, ok := err.(*bodyTooLargeError); ok {
+		writeProblem(w, http.StatusRequestEntityTooLarge, tooLarge.Error())
+		return
+	}
+	writeProblem(w, http.StatusBadRequest, fmt.Sprintf("%s:\n%v", message, err))
+}
+
+// throttleAddresses rejects requests with 429 before they are authenticated, once their client address has used up
+// its allowance of unauthenticated requests for the scope of the route, so credentials cannot be guessed without
+// limit. Requests only take from that allowance when throttleAddress charges them.
+func (s *Server) throttleAddresses(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	limiter := s.addressLimiter(scope)
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed, retryAfter := limiter.available("address:"+clientAddress(r), time.Now())
+		if !allowed {
+			writeThrottled(w, scope, retryAfter)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// throttleAddress takes a token for the client address of a request that is anonymous or failed to authenticate,
+// and writes 429 and returns true if there is none
+func (s *Server) throttleAddress(w http.ResponseWriter, r *http.Request, scope auth.Scope) bool {
+	limiter := s.addressLimiter(scope)
+	return limiter != nil && throttle(w, limiter, scope, "address:"+clientAddress(r))
+}
+
+// throttlePrincipals rejects requests with 429 once the principal that authenticated them has used up its allowance
+// for the scope of the route, wherever its requests come from. Anonymous requests are left to throttleAddress.
+func (s *Server) throttlePrincipals(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	limiter := s.limiter(scope)
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := auth.FromContext(r.Context())
+		if principal != nil && throttle(w, limiter, scope, "principal:"+principal.ID()) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limiter returns the rate limiter of principals calling routes requiring the scope, or nil if they are not
+// throttled
+func (s *Server) limiter(scope auth.Scope) *rateLimiter {
+	if scope == auth.ScopeRead {
+		return s.readLimiter
+	}
+	return s.writeLimiter
+}
+
+// addressLimiter returns the rate limiter of unauthenticated requests to routes requiring the scope, or nil if they
+// are not throttled
+func (s *Server) addressLimiter(scope auth.Scope) *rateLimiter {
+	if scope == auth.ScopeRead {
+		return s.readAddressLimiter
+	}
+	return s.writeAddressLimiter
+}
+
+// throttle takes a token for the caller, and writes 429 with a Retry-After header and returns true if there is none
+func throttle(w http.ResponseWriter, limiter *rateLimiter, scope auth.Scope, caller string) bool {
+	allowed, retryAfter := limiter.allow(caller, time.Now())
+	if allowed {
+		return false
+	}
+	writeThrottled(w, scope, retryAfter)
+	return true
+}
+
+// writeThrottled rejects a request with 429, and a Retry-After header saying when a token is refilled
+func writeThrottled(w http.ResponseWriter, scope auth.Scope, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeProblem(w, http.StatusTooManyRequests, fmt.Sprintf("too many %s requests, retry in %d seconds", scope, seconds))
+}
+
+// clientAddress returns the IP address of the client that sent a request
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimiter keeps a token bucket for every caller. Each request takes a token, and tokens are refilled at a
+// constant rate up to the burst.
+type rateLimiter struct {
+	mu sync.Mutex
+	// rate is the number of tokens refilled per second
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	// pruneAt is the number of buckets at which full buckets are forgotten, since they are the same as new ones
+	pruneAt int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter enforcing limit, or nil if limit allows every request
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.RequestsPerMinute <= 0 {
+		return nil
+	}
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    float64(limit.RequestsPerMinute) / 60,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+		pruneAt: 1024,
+	}
+}
+
+// allow takes a token from the caller's bucket at the given time. If the bucket is empty, it returns false and how
+// long until a token is refilled.
+func (l *rateLimiter) allow(caller string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket, ok := l.buckets[caller]
+	if !ok {
+		if len(l.buckets) >= l.pruneAt {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[caller] = bucket
+	}
+	l.refill(bucket, now)
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// available reports whether the caller's bucket has a token at the given time, without taking it. If the bucket is
+// empty, it also returns how long until a token is refilled.
+func (l *rateLimiter) available(caller string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket, ok := l.buckets[caller]
+	if !ok {
+		return true, 0
+	}
+	l.refill(bucket, now)
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	return true, 0
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed.Seconds()*l.rate)
+		bucket.last = now
+	}
+}
+
+// prune forgets full buckets, and grows the number of buckets kept before pruning again if most are in use
+func (l *rateLimiter) prune(now time.Time) {
+	for caller, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.tokens >= l.burst {
+			delete(l.buckets, caller)
+		}
+	}
+	if len(l.buckets) >= l.pruneAt/2 {
+		l.pruneAt *= 2
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_limitBody(t *testing.T) {
+	authenticator := fakeAuthenticator{"admin": {Name: "admin", Scopes: []auth.Scope{auth.ScopeAdmin}}}
+	s := newTestServer(WithMaxBodyBytes(64), WithMaxImportBytes(128), WithAuthenticator(authenticator))
+	post := func(target, key, body string, contentLength int64) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.ContentLength = contentLength
+		if key != "" {
+			r.Header.Set(auth.APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Bodies up to the limit are handled", func(t *testing.T) {
+		body := "title: App\n" + strings.Repeat("#", 53)
+		assert.Equal(t, http.StatusBadRequest, post("/metadata", "admin", body, int64(len(body))).Code)
+	})
+
+	t.Run("Bodies over the limit are rejected", func(t *testing.T) {
+		body := strings.Repeat("#", 65)
+		w := post("/metadata", "admin", body, int64(len(body)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Contains(t, w.Body.String(), "request body is larger than the limit of 64 bytes")
+		// bodies of unknown length are rejected once the limit has been read
+		assert.Equal(t, http.StatusRequestEntityTooLarge, post("/metadata", "admin", body, -1).Code)
+	})
+
+	t.Run("Bodies are not read before the request is authenticated", func(t *testing.T) {
+		body := strings.Repeat("#", 65)
+		assert.Equal(t, http.StatusUnauthorized, post("/metadata", "", body, int64(len(body))).Code)
+	})
+
+	t.Run("Imports have their own limit", func(t *testing.T) {
+		body := strings.Repeat("#", 100)
+		w := post("/admin/import", "admin", body, -1)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "valid export")
+		w = post("/admin/import", "admin", body+body, -1)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Contains(t, w.Body.String(), "limit of 128 bytes")
+	})
+}
+
+func Test_rateLimit(t *testing.T) {
+	authenticator := fakeAuthenticator{
+		"writer": {Name: "writer", Scopes: []auth.Scope{auth.ScopeWrite}},
+		"other":  {Name: "other", Scopes: []auth.Scope{auth.ScopeWrite}},
+		"first":  {Name: "first", Scopes: []auth.Scope{auth.ScopeRead}},
+		"second": {Name: "second", Scopes: []auth.Scope{auth.ScopeRead}},
+	}
+	s := newTestServer(
+		WithAuthenticator(authenticator),
+		WithAnonymousReads(true),
+		WithRateLimits(RateLimit{RequestsPerMinute: 60, Burst: 2}, RateLimit{RequestsPerMinute: 1, Burst: 1}),
+	)
+	request := func(method, target, key, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.RemoteAddr = remoteAddr
+		if key != "" {
+			r.Header.Set(auth.APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Anonymous callers are throttled by address", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusOK, request(http.MethodGet, "/metadata", "", "192.0.2.1:1000").Code)
+		}
+		w := request(http.MethodGet, "/metadata", "", "192.0.2.1:2000")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusOK, request(http.MethodGet, "/metadata", "", "192.0.2.2:1000").Code)
+	})
+
+	t.Run("Authenticated callers are throttled by principal, separately for reads and writes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(http.MethodGet, "/metadata", "writer", "192.0.2.3:1000").Code)
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/metadata", "writer", "192.0.2.4:1000").Code)
+		w := request(http.MethodPost, "/metadata", "writer", "192.0.2.5:1000")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/metadata", "other", "192.0.2.6:1000").Code)
+	})
+
+	t.Run("Principals behind the same address each get their full burst", func(t *testing.T) {
+		for _, key := range []string{"first", "second"} {
+			for i := 0; i < 2; i++ {
+				assert.Equal(t, http.StatusOK, request(http.MethodGet, "/metadata?title=a", key, "192.0.2.8:1000").Code, key)
+			}
+			assert.Equal(t, http.StatusTooManyRequests, request(http.MethodGet, "/metadata?title=a", key, "192.0.2.8:1000").Code, key)
+		}
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusOK, request(http.MethodGet, "/metadata?title=a", "", "192.0.2.8:1000").Code)
+		}
+	})
+
+	t.Run("Callers with wrong credentials are throttled by address before they are authenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/metadata", "guessed", "192.0.2.7:1000").Code)
+		w := request(http.MethodPost, "/metadata", "guessed-again", "192.0.2.7:1000")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, http.StatusTooManyRequests, request(http.MethodPost, "/metadata", "other", "192.0.2.7:1000").Code)
+	})
+
+	t.Run("Public routes are not throttled", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, request(http.MethodGet, "/healthz", "", "192.0.2.1:1000").Code)
+		}
+	})
+}
+
+func Test_rateLimiter(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Tokens are refilled at the rate, up to the burst", func(t *testing.T) {
+		l := newRateLimiter(RateLimit{RequestsPerMinute: 120, Burst: 2})
+		for i := 0; i < 2; i++ {
+			allowed, _ := l.allow("caller", start)
+			assert.True(t, allowed)
+		}
+		allowed, retryAfter := l.allow("caller", start)
+		assert.False(t, allowed)
+		assert.Equal(t, 500*time.Millisecond, retryAfter)
+		allowed, _ = l.allow("caller", start.Add(500*time.Millisecond))
+		assert.True(t, allowed)
+		allowed, _ = l.allow("caller", start.Add(time.Hour))
+		assert.True(t, allowed)
+		allowed, _ = l.allow("caller", start.Add(time.Hour))
+		assert.True(t, allowed)
+		allowed, _ = l.allow("caller", start.Add(time.Hour))
+		assert.False(t, allowed)
+	})
+
+	t.Run("Full buckets are forgotten", func(t *testing.T) {
+		l := newRateLimiter(RateLimit{RequestsPerMinute: 60, Burst: 1})
+		l.pruneAt = 2
+		l.allow("first", start)
+		l.allow("second", start.Add(time.Second))
+		l.allow("third", start.Add(1500*time.Millisecond))
+		assert.Len(t, l.buckets, 2)
+		assert.NotContains(t, l.buckets, "first")
+	})
+
+	t.Run("Limits without a rate allow every request", func(t *testing.T) {
+		assert.Nil(t, newRateLimiter(RateLimit{Burst: 10}))
+	})
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/medhir/yaml-api/logging"
+)
+
+// RequestIDHeader carries the ID of a request, which is propagated from clients or generated by the server
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID restricts propagated request IDs to values that are safe to echo in headers and logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type accessLogKey struct{}
+
+// accessLog collects details about a request that are only known to inner handlers, such as the principal
+type accessLog struct {
+	principal string
+}
+
+// logRequests writes a structured access log entry for every request. Each request is given an ID, which is
+// returned in the X-Request-ID header and added to every entry logged while serving the request, including those
+// logged by storage. Errors writing the response are logged too, so handlers need not log them.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		entry := &accessLog{}
+		logger := s.logger.With("request_id", id)
+		ctx := context.WithValue(r.Context(), accessLogKey{}, entry)
+		ctx = logging.NewContext(ctx, logger)
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		log := logger.Info
+		if recorder.status >= http.StatusInternalServerError {
+			log = logger.Error
+		}
+		log("request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_addr", r.RemoteAddr,
+			"principal", entry.principal,
+		)
+		if recorder.err != nil {
+			logger.Warn("could not write response", "error", recorder.err)
+		}
+	})
+}
+
+// setLoggedPrincipal records who made a request in its access log entry
+func setLoggedPrincipal(r *http.Request, name string) {
+	if entry, ok := r.Context().Value(accessLogKey{}).(*accessLog); ok {
+		entry.principal = name
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func logEntries(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	entries := []map[string]interface{}{}
+	scanner := bufio.NewScanner(b)
+	for scanner.Scan() {
+		entry := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func Test_logRequests(t *testing.T) {
+	b := &bytes.Buffer{}
+	s := newTestServer(
+		WithLogger(logging.New(b, logging.LevelDebug)),
+		WithAuthenticator(fakeAuthenticator{
+			"reader": {Name: "reader", Scopes: []auth.Scope{auth.ScopeRead}},
+		}),
+	)
+	handler := s.router
+
+	t.Run("Propagated request IDs are logged by the access log and storage", func(t *testing.T) {
+		b.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/metadata?colour=blue", nil)
+		r.Header.Set(RequestIDHeader, "req-123")
+		r.Header.Set(auth.APIKeyHeader, "reader")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, "req-123", w.Header().Get(RequestIDHeader))
+
+		entries := logEntries(t, b)
+		if assert.Equal(t, 2, len(entries)) {
+			assert.Equal(t, "could not look up metadata", entries[0]["msg"])
+			assert.Equal(t, "req-123", entries[0]["request_id"])
+			assert.Equal(t, "colour", entries[0]["attribute"])
+
+			assert.Equal(t, "request served", entries[1]["msg"])
+			assert.Equal(t, "req-123", entries[1]["request_id"])
+			assert.Equal(t, "GET", entries[1]["method"])
+			assert.Equal(t, "/metadata", entries[1]["path"])
+			assert.Equal(t, float64(http.StatusBadRequest), entries[1]["status"])
+			assert.Equal(t, float64(w.Body.Len()), entries[1]["bytes"])
+			assert.Equal(t, "reader", entries[1]["principal"])
+			assert.Equal(t, r.RemoteAddr, entries[1]["remote_addr"])
+		}
+	})
+
+	t.Run("Requests without a valid request ID are given one", func(t *testing.T) {
+		b.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/metadata?title=app", nil)
+		r.Header.Set(RequestIDHeader, "not a valid\tid")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		id := w.Header().Get(RequestIDHeader)
+		assert.Len(t, id, 32)
+
+		entries := logEntries(t, b)
+		if assert.NotEmpty(t, entries) {
+			last := entries[len(entries)-1]
+			assert.Equal(t, id, last["request_id"])
+			assert.Equal(t, "", last["principal"])
+		}
+	})
+
+	t.Run("Errors writing responses are logged", func(t *testing.T) {
+		b.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		handler.ServeHTTP(failingWriter{httptest.NewRecorder()}, r)
+
+		entries := logEntries(t, b)
+		if assert.Equal(t, 2, len(entries)) {
+			assert.Equal(t, "could not write response", entries[1]["msg"])
+			assert.Equal(t, "connection reset by peer", entries[1]["error"])
+			assert.Equal(t, entries[0]["request_id"], entries[1]["request_id"])
+		}
+	})
+}
+
+// failingWriter fails every write, as a connection closed by the client does
+type failingWriter struct {
+	http.ResponseWriter
+}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/medhir/yaml-api/metrics"
+	"github.com/medhir/yaml-api/storage"
+)
+
+// serverMetrics holds the metrics recorded while serving requests
+type serverMetrics struct {
+	registry           *metrics.Registry
+	requests           *metrics.Counter
+	requestDuration    *metrics.Histogram
+	validationFailures *metrics.Counter
+	dnsLookupDuration  *metrics.Histogram
+}
+
+func newServerMetrics(store *storage.Storage) *serverMetrics {
+	registry := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: registry,
+		requests: registry.NewCounter("yaml_api_http_requests_total",
+			"Number of http requests served, by route, method and status code.",
+			"route", "method", "status"),
+		requestDuration: registry.NewHistogram("yaml_api_http_request_duration_seconds",
+			"Time taken to serve http requests, by route, method and status code.",
+			metrics.DefaultBuckets, "route", "method", "status"),
+		validationFailures: registry.NewCounter("yaml_api_validation_failures_total",
+			"Number of submitted metadata documents rejected by validation, by field and reason.",
+			"field", "reason"),
+		dnsLookupDuration: registry.NewHistogram("yaml_api_dns_lookup_duration_seconds",
+			"Time taken by DNS lookups validating maintainer emails, by result.",
+			metrics.DefaultBuckets, "result"),
+	}
+	registry.NewGaugeFunc("yaml_api_documents",
+		"Number of stored metadata documents, by lifecycle state.",
+		[]string{"state"}, func(emit func(float64, ...string)) {
+			stats := store.Stats()
+			for _, state := range []storage.State{storage.Active, storage.Deprecated, storage.Yanked} {
+				emit(float64(stats.Documents[state]), string(state))
+			}
+		})
+	registry.NewGaugeFunc("yaml_api_index_terms",
+		"Number of distinct terms in the search index, by attribute.",
+		[]string{"field"}, func(emit func(float64, ...string)) {
+			stats := store.Stats()
+			for _, attr := range storage.Attributes {
+				emit(float64(stats.IndexTerms[attr]), attr)
+			}
+		})
+	store.OnMXLookup(func(duration time.Duration, err error) {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		m.dnsLookupDuration.Observe(duration.Seconds(), result)
+	})
+	return m
+}
+
+// validationFailed records why submitted metadata was rejected
+func (m *serverMetrics) validationFailed(err error) {
+	if m == nil {
+		return
+	}
+	if validationErr, ok := err.(*storage.ValidationError); ok {
+		m.validationFailures.Inc(validationErr.Field, validationErr.Reason)
+		return
+	}
+	m.validationFailures.Inc("", "invalid_yaml")
+}
+
+// instrument records the number and duration of requests served by next, labelled by the route pattern that
+// handles them rather than the raw path, so IDs in paths do not create a series per document
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		route := "unmatched"
+		if pattern := s.router.pattern(r); pattern != "" {
+			route = pattern
+		}
+		method := r.Method
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			method = "OTHER"
+		}
+		status := strconv.Itoa(recorder.status)
+		s.metrics.requests.Inc(route, method, status)
+		s.metrics.requestDuration.Observe(time.Since(start).Seconds(), route, method, status)
+	})
+}
+
+// responseRecorder captures the status code and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	// err is the first error writing the body, such as a client disconnecting
+	err error
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// Flush supports streaming responses, such as server-sent events
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
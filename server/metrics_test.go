@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_instrument(t *testing.T) {
+	s := newTestServer()
+	handler := s.router
+
+	for _, path := range []string{"/metadata/abc", "/metadata/def", "/metadata?title=app", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/metadata", strings.NewReader("title: [")))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/metadata", strings.NewReader("version: 1.0.0")))
+
+	t.Run("Requests are counted by route pattern rather than path", func(t *testing.T) {
+		assert.Equal(t, float64(2), s.metrics.requests.Value("/metadata/{id}", "GET", "404"))
+		assert.Equal(t, float64(1), s.metrics.requests.Value("/metadata", "GET", "200"))
+		assert.Equal(t, float64(1), s.metrics.requests.Value("unmatched", "GET", "404"))
+		assert.Equal(t, uint64(2), s.metrics.requestDuration.Count("/metadata", "POST", "400"))
+	})
+
+	t.Run("Validation failures are counted by field and reason", func(t *testing.T) {
+		assert.Equal(t, float64(1), s.metrics.validationFailures.Value("", "invalid_yaml"))
+		assert.Equal(t, float64(1), s.metrics.validationFailures.Value("title", storage.ReasonMissing))
+	})
+
+	t.Run("Storage gauges are collected when scraped", func(t *testing.T) {
+		assert.NoError(t, s.storage.AddMetadata(context.Background(), &storage.Metadata{Title: "App title 1", Version: "1.0.0", License: "MIT"}))
+		b := &bytes.Buffer{}
+		assert.NoError(t, s.metrics.registry.Write(b))
+		assert.Contains(t, b.String(), `yaml_api_documents{state="active"} 1`)
+		assert.Contains(t, b.String(), `yaml_api_index_terms{field="title"} 3`)
+		assert.Contains(t, b.String(), `yaml_api_index_terms{field="license"} 1`)
+	})
+}
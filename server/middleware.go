@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/medhir/yaml-api/auth"
+)
+
+// requireScope authenticates a request, and only calls next if the caller has been granted the scope.
+// Requests without credentials are rejected with 401, and requests lacking the scope with 403. Requests that are
+// anonymous or fail to authenticate are throttled by client address.
+func (s *Server) requireScope(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authenticator == nil {
+			if s.throttleAddress(w, r, scope) {
+				return
+			}
+			next(w, r)
+			return
+		}
+		principal, err := s.authenticator.Authenticate(r)
+		if err != nil && s.throttleAddress(w, r, scope) {
+			return
+		}
+		if err == auth.ErrNoCredentials {
+			if scope == auth.ScopeRead && s.anonymousReads {
+				next(w, r)
+				return
+			}
+			setAuthenticateChallenges(w)
+			writeProblem(w, http.StatusUnauthorized, "authentication is required")
+			return
+		}
+		if err != nil {
+			setAuthenticateChallenges(w)
+			writeProblem(w, http.StatusUnauthorized, "could not authenticate request: "+err.Error())
+			return
+		}
+		setLoggedPrincipal(r, principal.Name)
+		if !principal.HasScope(scope) {
+			writeProblem(w, http.StatusForbidden, "the "+string(scope)+" scope is required")
+			return
+		}
+		next(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+	}
+}
+
+// withScope is middleware requiring the scope for every request to a route, as requireScope does, and throttling
+// callers with the rate limit of the scope: authenticated callers by principal, and others by client address.
+// Addresses that have used up their allowance are rejected before authentication.
+func (s *Server) withScope(scope auth.Scope) middleware {
+	return func(next http.Handler) http.Handler {
+		return s.throttleAddresses(scope, s.requireScope(scope, s.throttlePrincipals(scope, next.ServeHTTP)))
+	}
+}
+
+// setAuthenticateChallenges tells clients which credentials are accepted
+func setAuthenticateChallenges(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="yaml-api"`)
+	w.Header().Add("WWW-Authenticate", `ApiKey realm="yaml-api", header="`+auth.APIKeyHeader+`"`)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAuthenticator authenticates requests by looking up the X-API-Key header in a map of principals
+type fakeAuthenticator map[string]*auth.Principal
+
+func (f fakeAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	key := r.Header.Get(auth.APIKeyHeader)
+	if key == "" {
+		return nil, auth.ErrNoCredentials
+	}
+	principal, ok := f[key]
+	if !ok {
+		return nil, auth.ErrInvalidCredentials
+	}
+	return principal, nil
+}
+
+func Test_requireScope(t *testing.T) {
+	authenticator := fakeAuthenticator{
+		"reader": {Name: "reader", Scopes: []auth.Scope{auth.ScopeRead}},
+		"writer": {Name: "writer", Scopes: []auth.Scope{auth.ScopeWrite}},
+	}
+	var principal *auth.Principal
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		principal = auth.FromContext(r.Context())
+	}
+	tests := []struct {
+		name           string
+		anonymousReads bool
+		scope          auth.Scope
+		key            string
+		status         int
+		principal      string
+	}{
+		{name: "Anonymous reads are allowed when configured", anonymousReads: true, scope: auth.ScopeRead, status: http.StatusOK},
+		{name: "Anonymous reads are rejected by default", scope: auth.ScopeRead, status: http.StatusUnauthorized},
+		{name: "Anonymous writes are always rejected", anonymousReads: true, scope: auth.ScopeWrite, status: http.StatusUnauthorized},
+		{name: "Unknown keys are rejected", anonymousReads: true, scope: auth.ScopeRead, key: "unknown", status: http.StatusUnauthorized},
+		{name: "Reads are allowed with the read scope", scope: auth.ScopeRead, key: "reader", status: http.StatusOK, principal: "reader"},
+		{name: "Writes are forbidden with the read scope", scope: auth.ScopeWrite, key: "reader", status: http.StatusForbidden},
+		{name: "Writes are allowed with the write scope", scope: auth.ScopeWrite, key: "writer", status: http.StatusOK, principal: "writer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal = nil
+			s := &Server{authenticator: authenticator, anonymousReads: tt.anonymousReads}
+			r := httptest.NewRequest(http.MethodGet, "/metadata", nil)
+			if tt.key != "" {
+				r.Header.Set(auth.APIKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+			s.requireScope(tt.scope, handler)(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+			if tt.principal != "" {
+				assert.Equal(t, tt.principal, principal.Name)
+			} else {
+				assert.Nil(t, principal)
+			}
+		})
+	}
+
+	t.Run("Every request is allowed without an authenticator", func(t *testing.T) {
+		s := &Server{}
+		w := httptest.NewRecorder()
+		s.requireScope(auth.ScopeWrite, handler)(w, httptest.NewRequest(http.MethodPost, "/metadata", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -0,0 +1,638 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/medhir/yaml-api/storage"
+	"github.com/medhir/yaml-api/version"
+	"github.com/medhir/yaml-api/webhook"
+	"gopkg.in/yaml.v2"
+)
+
+// object is a JSON object in the OpenAPI specification
+type object map[string]interface{}
+
+// operation documents how a route is used. Path parameters are documented from the route's pattern.
+type operation struct {
+	summary     string
+	description string
+	// public operations are served without authentication
+	public    bool
+	params    []object
+	request   object
+	responses map[int]object
+}
+
+// enums lists the values of string types that only take a fixed set of values
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(storage.State("")):          {string(storage.Active), string(storage.Deprecated), string(storage.Yanked)},
+	reflect.TypeOf(storage.ChangeType("")):     {string(storage.ChangeCreate), string(storage.ChangeUpdate), string(storage.ChangeDelete), string(storage.ChangeDeprecate), string(storage.ChangeYank)},
+	reflect.TypeOf(webhook.DeliveryStatus("")): {string(webhook.Pending), string(webhook.Delivered), string(webhook.Failed)},
+}
+
+// schemaGenerator derives JSON schemas from Go types and their struct tags, adding structs to the components of
+// the specification by name
+type schemaGenerator struct {
+	// tag names struct fields, and is json for response bodies or yaml for submitted metadata
+	tag string
+	// suffix is added to the names of components, so that schemas generated from different tags do not collide
+	suffix     string
+	components object
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) object {
+	if values, ok := enums[t]; ok {
+		return object{"type": "string", "enum": values}
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return object{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return object{"type": "integer", "format": "int64", "description": "duration in nanoseconds"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return object{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	}
+	// interfaces may hold any value
+	return object{}
+}
+
+// structSchema describes the exported fields of a struct, which are required unless they are omitted when empty.
+// Named structs are added to the components, and referenced.
+func (g *schemaGenerator) structSchema(t reflect.Type) object {
+	name := ""
+	if t.Name() != "" {
+		name = strings.ToUpper(t.Name()[:1]) + t.Name()[1:] + g.suffix
+		if _, ok := g.components[name]; ok {
+			return ref("schemas", name)
+		}
+		// reserve the name first, so recursive types refer to themselves
+		g.components[name] = object{}
+	}
+	properties := object{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get(g.tag), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		fieldName := tag[0]
+		if fieldName == "" {
+			fieldName = field.Name
+			if g.tag == "yaml" {
+				fieldName = strings.ToLower(field.Name)
+			}
+		}
+		properties[fieldName] = g.schema(field.Type)
+		omitEmpty := false
+		for _, option := range tag[1:] {
+			omitEmpty = omitEmpty || option == "omitempty"
+		}
+		if !omitEmpty {
+			required = append(required, fieldName)
+		}
+	}
+	schema := object{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if name == "" {
+		return schema
+	}
+	g.components[name] = schema
+	return ref("schemas", name)
+}
+
+func ref(kind, name string) object {
+	return object{"$ref": "#/components/" + kind + "/" + name}
+}
+
+func queryParam(name, description string, schema object) object {
+	return object{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+func response(description string, content object) object {
+	r := object{"description": description}
+	if content != nil {
+		r["content"] = content
+	}
+	return r
+}
+
+// openAPIErrors are the error responses shared by operations, each of which is described by a Problem
+var openAPIErrors = map[int]string{
+	http.StatusBadRequest:         "BadRequest",
+	http.StatusUnauthorized:       "Unauthorized",
+	http.StatusForbidden:          "Forbidden",
+	http.StatusNotFound:           "NotFound",
+	http.StatusConflict:           "Conflict",
+	http.StatusPreconditionFailed: "PreconditionFailed",
+	// every request with a body may be too large, and every authenticated request may be throttled
+	http.StatusRequestEntityTooLarge: "PayloadTooLarge",
+	http.StatusTooManyRequests:       "TooManyRequests",
+}
+
+func errorResponse(status int) object {
+	return ref("responses", openAPIErrors[status])
+}
+
+// operations documents every route, by method and pattern
+func (s *Server) operations(jsonSchemas, yamlSchemas *schemaGenerator) map[string]operation {
+	metadata := jsonSchemas.schema(reflect.TypeOf(storage.Metadata{}))
+	metadataList := object{"type": "array", "items": metadata}
+	yamlBody := object{
+		"description": "metadata as YAML, every attribute of which is required",
+		"required":    true,
+		"content": object{
+			"application/yaml": object{"schema": yamlSchemas.schema(reflect.TypeOf(storage.Metadata{}))},
+		},
+	}
+	lifecycleHeaders := object{
+		"Deprecation": object{"description": "true when the metadata is deprecated", "schema": object{"type": "string"}},
+		"Warning":     object{"description": "the deprecation message, or yank reason", "schema": object{"type": "string"}},
+		"Link":        object{"description": "the successor-version of deprecated metadata", "schema": object{"type": "string"}},
+	}
+	withHeaders := func(r object, headers object) object {
+		r["headers"] = headers
+		return r
+	}
+	cacheHeaders := object{
+		"ETag":          object{"description": "changes whenever stored metadata changes", "schema": object{"type": "string"}},
+		"Last-Modified": object{"description": "when the response last changed", "schema": object{"type": "string"}},
+		"Cache-Control": object{"description": "no-cache, since responses must be revalidated before they are reused", "schema": object{"type": "string"}},
+	}
+	conditionalParams := []object{
+		{"name": "If-None-Match", "in": "header", "description": "ETags of responses the client holds", "schema": object{"type": "string"}},
+		{"name": "If-Modified-Since", "in": "header", "description": "when the response the client holds last changed, used without If-None-Match", "schema": object{"type": "string"}},
+	}
+	notModifiedResponse := withHeaders(response("the response the client holds is current", nil), cacheHeaders)
+	metadataHeaders := object{}
+	for _, headers := range []object{lifecycleHeaders, cacheHeaders} {
+		for name, header := range headers {
+			metadataHeaders[name] = header
+		}
+	}
+	// writes to metadata return its new revision, and may require the revision the client last read
+	writeHeaders := object{"ETag": object{"description": "the revision of the metadata", "schema": object{"type": "string"}}}
+	for name, header := range lifecycleHeaders {
+		writeHeaders[name] = header
+	}
+	ifMatchParams := []object{
+		{"name": "If-Match", "in": "header", "description": "ETags of the revisions the metadata must be at, or * for any revision", "schema": object{"type": "string"}},
+	}
+	jsonBody := func(description string, schema object) object {
+		return object{"description": description, "required": true, "content": jsonContent(schema)}
+	}
+	searchParams := []object{}
+	for _, attr := range storage.Attributes {
+		searchParams = append(searchParams, queryParam(attr, "words that must all appear in the "+strings.Replace(attr, "_", " ", -1), object{"type": "string"}))
+	}
+	searchParams = append(searchParams, queryParam("include_yanked", "include yanked metadata in the results", object{"type": "boolean", "default": false}))
+	health := jsonSchemas.schema(reflect.TypeOf(healthStatus{}))
+	subscription := jsonSchemas.schema(reflect.TypeOf(webhook.Subscription{}))
+	deliveries := object{"type": "array", "items": jsonSchemas.schema(reflect.TypeOf(webhook.Delivery{}))}
+	savedSearch := jsonSchemas.schema(reflect.TypeOf(storage.SavedSearch{}))
+
+	return map[string]operation{
+		"GET /metadata": {
+			summary:     "Search metadata",
+			description: "Returns the metadata matching every queried attribute. Yanked metadata is left out unless include_yanked is true.",
+			params:      append(append([]object{}, searchParams...), conditionalParams...),
+			responses: map[int]object{
+				http.StatusOK:          withHeaders(response("matching metadata", jsonContent(metadataList)), cacheHeaders),
+				http.StatusNotModified: notModifiedResponse,
+				http.StatusBadRequest:  errorResponse(http.StatusBadRequest),
+			},
+		},
+		"POST /metadata": {
+			summary: "Store metadata",
+			request: yamlBody,
+			responses: map[int]object{
+				http.StatusCreated: withHeaders(response("the stored metadata", jsonContent(metadata)), object{
+					"Location": object{"description": "the URL of the stored metadata", "schema": object{"type": "string"}},
+				}),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+			},
+		},
+		"GET /metadata/explain": {
+			summary:     "Explain a search",
+			description: "Takes the query of GET /metadata, and describes the terms each queried value is analyzed into, the metadata each term matches, and how the matches are narrowed down to the results.",
+			params:      searchParams,
+			responses:   map[int]object{http.StatusOK: response("how the search finds its results", jsonContent(jsonSchemas.schema(reflect.TypeOf(storage.Explanation{})))), http.StatusBadRequest: errorResponse(http.StatusBadRequest)},
+		},
+		"POST /metadata/batch": {
+			summary:     "Store several metadata documents",
+			description: "Every document is validated and authorized before any are stored, so invalid batches store nothing. Problems with invalid documents are listed in errors.",
+			request: object{
+				"description": "metadata documents as multi-document YAML, separated by ---",
+				"required":    true,
+				"content": object{
+					"application/yaml": object{"schema": yamlSchemas.schema(reflect.TypeOf(storage.Metadata{}))},
+				},
+			},
+			responses: map[int]object{
+				http.StatusCreated:    response("the stored metadata, in the order it was submitted", jsonContent(metadataList)),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+			},
+		},
+		"GET /metadata/{id}": {
+			summary: "Get metadata by ID, whatever its lifecycle state",
+			params:  conditionalParams,
+			responses: map[int]object{
+				http.StatusOK:          withHeaders(response("the metadata", jsonContent(metadata)), metadataHeaders),
+				http.StatusNotModified: notModifiedResponse,
+				http.StatusNotFound:    errorResponse(http.StatusNotFound),
+			},
+		},
+		"PUT /metadata/{id}": {
+			summary: "Replace metadata, keeping its ID and lifecycle state",
+			params:  ifMatchParams,
+			request: yamlBody,
+			responses: map[int]object{
+				http.StatusOK:                 withHeaders(response("the replaced metadata", jsonContent(metadata)), writeHeaders),
+				http.StatusBadRequest:         errorResponse(http.StatusBadRequest),
+				http.StatusNotFound:           errorResponse(http.StatusNotFound),
+				http.StatusPreconditionFailed: errorResponse(http.StatusPreconditionFailed),
+			},
+		},
+		"PATCH /metadata/{id}": {
+			summary:     "Replace some attributes of metadata, keeping the others",
+			description: "Attributes left out of the body keep their stored value, and the result must be valid metadata.",
+			params:      ifMatchParams,
+			request: object{
+				"description": "attributes of metadata as YAML, such as description and license",
+				"required":    true,
+				"content":     object{"application/yaml": object{"schema": object{"type": "object"}}},
+			},
+			responses: map[int]object{
+				http.StatusOK:                 withHeaders(response("the patched metadata", jsonContent(metadata)), writeHeaders),
+				http.StatusBadRequest:         errorResponse(http.StatusBadRequest),
+				http.StatusNotFound:           errorResponse(http.StatusNotFound),
+				http.StatusPreconditionFailed: errorResponse(http.StatusPreconditionFailed),
+			},
+		},
+		"DELETE /metadata/{id}": {
+			summary: "Delete metadata",
+			params:  ifMatchParams,
+			responses: map[int]object{
+				http.StatusNoContent:          response("the metadata was deleted", nil),
+				http.StatusNotFound:           errorResponse(http.StatusNotFound),
+				http.StatusPreconditionFailed: errorResponse(http.StatusPreconditionFailed),
+			},
+		},
+		"POST /metadata/{id}/deprecate": {
+			summary: "Deprecate metadata",
+			params:  ifMatchParams,
+			request: jsonBody("why the metadata is deprecated, and the ID of the metadata replacing it", jsonSchemas.schema(reflect.TypeOf(storage.Deprecation{}))),
+			responses: map[int]object{
+				http.StatusOK:                 withHeaders(response("the deprecated metadata", jsonContent(metadata)), writeHeaders),
+				http.StatusBadRequest:         errorResponse(http.StatusBadRequest),
+				http.StatusNotFound:           errorResponse(http.StatusNotFound),
+				http.StatusPreconditionFailed: errorResponse(http.StatusPreconditionFailed),
+			},
+		},
+		"POST /metadata/{id}/yank": {
+			summary:     "Yank metadata",
+			description: "Yanked metadata is hidden from searches by default. The body, and its reason, are optional.",
+			params:      ifMatchParams,
+			request: object{"content": jsonContent(object{
+				"type":       "object",
+				"properties": object{"reason": object{"type": "string"}},
+			})},
+			responses: map[int]object{
+				http.StatusOK:                 withHeaders(response("the yanked metadata", jsonContent(metadata)), writeHeaders),
+				http.StatusBadRequest:         errorResponse(http.StatusBadRequest),
+				http.StatusNotFound:           errorResponse(http.StatusNotFound),
+				http.StatusPreconditionFailed: errorResponse(http.StatusPreconditionFailed),
+			},
+		},
+		"GET /apps/{name}/versions": {
+			summary: "List every version of an application, lowest first, including yanked versions",
+			responses: map[int]object{
+				http.StatusOK:       response("the versions", jsonContent(metadataList)),
+				http.StatusNotFound: errorResponse(http.StatusNotFound),
+			},
+		},
+		"GET /apps/{name}/diff": {
+			summary: "Compare two versions of an application",
+			params: []object{
+				queryParam("from", "the version to compare from", object{"type": "string"}),
+				queryParam("to", "the version to compare to", object{"type": "string"}),
+				queryParam("format", "json for a field level diff, or unified for a text diff of the YAML", object{"type": "string", "enum": []string{"json", "unified"}, "default": "json"}),
+			},
+			responses: map[int]object{
+				http.StatusOK: response("the differences between the versions", object{
+					"application/json": object{"schema": jsonSchemas.schema(reflect.TypeOf(storage.MetadataDiff{}))},
+					"text/x-diff":      object{"schema": object{"type": "string"}},
+				}),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+				http.StatusNotFound:   errorResponse(http.StatusNotFound),
+			},
+		},
+		"GET /events": {
+			summary:     "Stream changes to metadata",
+			description: "Streams server-sent events, whose data is a JSON change. Clients resume after the last event they received.",
+			params: []object{
+				queryParam("types", "comma separated change types to stream, such as create,delete", object{"type": "string"}),
+				queryParam("last_event_id", "the ID of the last event received", object{"type": "integer"}),
+				{"name": "Last-Event-ID", "in": "header", "description": "the ID of the last event received", "schema": object{"type": "integer"}},
+			},
+			responses: map[int]object{
+				http.StatusOK: response("a stream of change events", object{
+					"text/event-stream": object{"schema": object{"type": "string"}},
+				}),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+			},
+		},
+		"GET /searches": {
+			summary:   "List saved searches",
+			responses: map[int]object{http.StatusOK: response("the caller's saved searches, or every saved search for admins", jsonContent(object{"type": "array", "items": savedSearch}))},
+		},
+		"POST /searches": {
+			summary: "Save a search, which is matched against metadata as it is stored",
+			request: jsonBody("the name of the search, and the attributes it queries", object{
+				"type":       "object",
+				"required":   []string{"name", "query"},
+				"properties": object{"name": object{"type": "string"}, "query": object{"type": "object", "additionalProperties": object{"type": "string"}}},
+			}),
+			responses: map[int]object{http.StatusCreated: response("the saved search", jsonContent(savedSearch)), http.StatusBadRequest: errorResponse(http.StatusBadRequest)},
+		},
+		"GET /searches/{id}": {
+			summary:   "Get a saved search",
+			responses: map[int]object{http.StatusOK: response("the saved search", jsonContent(savedSearch)), http.StatusNotFound: errorResponse(http.StatusNotFound)},
+		},
+		"DELETE /searches/{id}": {
+			summary:   "Delete a saved search",
+			responses: map[int]object{http.StatusNoContent: response("the search was deleted", nil), http.StatusNotFound: errorResponse(http.StatusNotFound)},
+		},
+		"GET /searches/{id}/matches": {
+			summary: "List metadata that matched a saved search, oldest first",
+			params:  []object{queryParam("since", "only return matches with a greater sequence number", object{"type": "integer"})},
+			responses: map[int]object{
+				http.StatusOK:         response("the matches", jsonContent(object{"type": "array", "items": jsonSchemas.schema(reflect.TypeOf(storage.Match{}))})),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+				http.StatusNotFound:   errorResponse(http.StatusNotFound),
+			},
+		},
+		"GET /webhooks": {
+			summary:   "List webhook subscriptions",
+			responses: map[int]object{http.StatusOK: response("every subscription", jsonContent(object{"type": "array", "items": subscription}))},
+		},
+		"POST /webhooks": {
+			summary:   "Subscribe to webhooks",
+			request:   jsonBody("where, and for which changes, webhooks are delivered", subscription),
+			responses: map[int]object{http.StatusCreated: response("the subscription", jsonContent(subscription)), http.StatusBadRequest: errorResponse(http.StatusBadRequest)},
+		},
+		"GET /webhooks/dead-letters": {
+			summary:   "List webhook deliveries that failed after every attempt",
+			responses: map[int]object{http.StatusOK: response("the failed deliveries", jsonContent(deliveries))},
+		},
+		"GET /webhooks/{id}": {
+			summary:   "Get a webhook subscription",
+			responses: map[int]object{http.StatusOK: response("the subscription", jsonContent(subscription)), http.StatusNotFound: errorResponse(http.StatusNotFound)},
+		},
+		"DELETE /webhooks/{id}": {
+			summary:   "Unsubscribe from webhooks",
+			responses: map[int]object{http.StatusNoContent: response("the subscription was deleted", nil), http.StatusNotFound: errorResponse(http.StatusNotFound)},
+		},
+		"GET /webhooks/{id}/deliveries": {
+			summary:   "List recent deliveries of a webhook subscription",
+			responses: map[int]object{http.StatusOK: response("the deliveries", jsonContent(deliveries)), http.StatusNotFound: errorResponse(http.StatusNotFound)},
+		},
+		"GET /admin/export": {
+			summary:     "Export every stored document",
+			description: "Streams every document, whatever its lifecycle state, with its ID, state and timestamps. Requires the admin scope.",
+			params: []object{
+				queryParam("format", "ndjson for newline delimited JSON, or tar for a gzipped tarball holding a YAML file for each document", object{"type": "string", "enum": []string{"ndjson", "tar"}, "default": "ndjson"}),
+			},
+			responses: map[int]object{
+				http.StatusOK: response("the stored documents", object{
+					NDJSONContentType: object{"schema": metadata},
+					GzipContentType:   object{"schema": object{"type": "string", "format": "binary"}},
+				}),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+			},
+		},
+		"POST /admin/import": {
+			summary:     "Import an export",
+			description: "Restores the documents of an export, in either format, keeping their IDs, lifecycle state and timestamps, and rebuilds the index. Every document is validated before any are stored. Requires the admin scope.",
+			params: []object{
+				queryParam("conflict", "what happens to documents with the ID of stored metadata: fail the import, skip them, or overwrite the stored metadata", object{"type": "string", "enum": []string{"fail", "skip", "overwrite"}, "default": "fail"}),
+			},
+			request: object{
+				"description": "an export, in either format",
+				"required":    true,
+				"content": object{
+					NDJSONContentType: object{"schema": metadata},
+					GzipContentType:   object{"schema": object{"type": "string", "format": "binary"}},
+				},
+			},
+			responses: map[int]object{
+				http.StatusOK:         response("how many documents were created, overwritten and skipped", jsonContent(jsonSchemas.schema(reflect.TypeOf(storage.ImportResult{})))),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+				http.StatusConflict:   errorResponse(http.StatusConflict),
+			},
+		},
+		"POST /admin/reindex": {
+			summary:     "Rebuild the search index",
+			description: "Rebuilds the index of every stored document without blocking searches or writes, then swaps it in atomically. The body may change the analyzer, keeping the current value of missing attributes. Requires the admin scope.",
+			request: object{
+				"description": "the analyzer to index text with, defaulting to the current one",
+				"required":    false,
+				"content":     object{"application/json": object{"schema": jsonSchemas.schema(reflect.TypeOf(storage.Analyzer{}))}},
+			},
+			responses: map[int]object{
+				http.StatusOK:         response("the rebuilt index", jsonContent(jsonSchemas.schema(reflect.TypeOf(storage.ReindexResult{})))),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+			},
+		},
+		"GET /admin/index/stats": {
+			summary:     "Describe the search index",
+			description: "Counts the terms and postings indexed for each attribute, lists the most referenced terms, and estimates the memory used. Requires the admin scope.",
+			params:      []object{queryParam("top", "how many of the most referenced terms to list for each attribute", object{"type": "integer", "minimum": 0, "maximum": 1000, "default": 10})},
+			responses: map[int]object{
+				http.StatusOK:         response("statistics of the index", jsonContent(jsonSchemas.schema(reflect.TypeOf(storage.IndexStats{})))),
+				http.StatusBadRequest: errorResponse(http.StatusBadRequest),
+			},
+		},
+		"GET /metrics": {
+			summary: "Get metrics in the Prometheus text format",
+			responses: map[int]object{http.StatusOK: response("the metrics", object{
+				"text/plain": object{"schema": object{"type": "string"}},
+			})},
+		},
+		"GET /healthz": {
+			summary:   "Check that the server is alive",
+			public:    true,
+			responses: map[int]object{http.StatusOK: response("the server is alive", jsonContent(health))},
+		},
+		"GET /readyz": {
+			summary: "Check that the server is ready to serve traffic",
+			public:  true,
+			responses: map[int]object{
+				http.StatusOK:                 response("the server is ready", jsonContent(health)),
+				http.StatusServiceUnavailable: response("the server is not ready, or is shutting down", jsonContent(health)),
+			},
+		},
+		"GET /version": {
+			summary:   "Get the version of the server",
+			public:    true,
+			responses: map[int]object{http.StatusOK: response("the running build", jsonContent(jsonSchemas.schema(reflect.TypeOf(version.Info{}))))},
+		},
+		"GET /openapi.json": {
+			summary:   "Get this specification as JSON",
+			public:    true,
+			responses: map[int]object{http.StatusOK: response("the specification", jsonContent(object{"type": "object"}))},
+		},
+		"GET /openapi.yaml": {
+			summary: "Get this specification as YAML",
+			public:  true,
+			responses: map[int]object{http.StatusOK: response("the specification", object{
+				"application/yaml": object{"schema": object{"type": "object"}},
+			})},
+		},
+	}
+}
+
+// openAPI generates an OpenAPI 3 specification of every route served
+func (s *Server) openAPI() object {
+	components := object{}
+	jsonSchemas := &schemaGenerator{tag: "json", components: components}
+	operations := s.operations(jsonSchemas, &schemaGenerator{tag: "yaml", suffix: "YAML", components: components})
+	paths := object{}
+	for _, route := range s.router.routes {
+		item := object{}
+		pathParams := []object{}
+		for _, segment := range route.segments {
+			if name, ok := paramName(segment); ok {
+				pathParams = append(pathParams, object{"name": name, "in": "path", "required": true, "schema": object{"type": "string"}})
+			}
+		}
+		if len(pathParams) > 0 {
+			item["parameters"] = pathParams
+		}
+		for method := range route.handlers {
+			op, ok := operations[method+" "+route.pattern]
+			if !ok {
+				continue
+			}
+			item[strings.ToLower(method)] = op.document()
+		}
+		paths[route.pattern] = item
+	}
+	problem := object{ProblemContentType: object{"schema": jsonSchemas.schema(reflect.TypeOf(Problem{}))}}
+	errors := object{}
+	for status, name := range openAPIErrors {
+		errors[name] = response(http.StatusText(status), problem)
+		if status == http.StatusTooManyRequests {
+			errors[name].(object)["headers"] = object{
+				"Retry-After": object{"description": "seconds to wait before retrying", "schema": object{"type": "integer"}},
+			}
+		}
+		if status == http.StatusPreconditionFailed {
+			errors[name].(object)["headers"] = object{
+				"ETag": object{"description": "the current revision of the metadata", "schema": object{"type": "string"}},
+			}
+		}
+	}
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "YAML API",
+			"description": "Stores, searches and tracks changes to application metadata submitted as YAML.",
+			"version":     version.Get().Version,
+		},
+		"paths": paths,
+		"components": object{
+			"schemas":   components,
+			"responses": errors,
+			"securitySchemes": object{
+				"bearerAuth": object{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":     object{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		// mutual TLS cannot be described by OpenAPI 3.0, but authenticates requests in the same way
+		"security": []object{{"bearerAuth": []string{}}, {"apiKey": []string{}}},
+	}
+}
+
+// document converts an operation to its representation in the specification
+func (op operation) document() object {
+	responses := object{}
+	for status, r := range op.responses {
+		responses[strconv.Itoa(status)] = r
+	}
+	if !op.public {
+		responses[strconv.Itoa(http.StatusUnauthorized)] = errorResponse(http.StatusUnauthorized)
+		responses[strconv.Itoa(http.StatusForbidden)] = errorResponse(http.StatusForbidden)
+		responses[strconv.Itoa(http.StatusTooManyRequests)] = errorResponse(http.StatusTooManyRequests)
+	}
+	if op.request != nil {
+		responses[strconv.Itoa(http.StatusRequestEntityTooLarge)] = errorResponse(http.StatusRequestEntityTooLarge)
+	}
+	doc := object{"summary": op.summary, "responses": responses}
+	if op.description != "" {
+		doc["description"] = op.description
+	}
+	if len(op.params) > 0 {
+		doc["parameters"] = op.params
+	}
+	if op.request != nil {
+		doc["requestBody"] = op.request
+	}
+	if op.public {
+		doc["security"] = []object{}
+	}
+	return doc
+}
+
+// handleOpenAPI serves the OpenAPI specification as JSON, or as YAML
+func (s *Server) handleOpenAPI(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spec := s.openAPI()
+		if format == "json" {
+			writeJSON(w, http.StatusOK, spec)
+			return
+		}
+		data, err := yaml.Marshal(spec)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not encode YAML:\n%v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		_, err = w.Write(data)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not write YAML to response:\n%v", err))
+			return
+		}
+	}
+}
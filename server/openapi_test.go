@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_openAPI(t *testing.T) {
+	s := newTestServer()
+
+	t.Run("Every route is described", func(t *testing.T) {
+		operations := s.operations(&schemaGenerator{tag: "json", components: object{}}, &schemaGenerator{tag: "yaml", components: object{}})
+		routes := map[string]bool{}
+		for _, route := range s.router.routes {
+			for method := range route.handlers {
+				routes[method+" "+route.pattern] = true
+				_, ok := operations[method+" "+route.pattern]
+				assert.True(t, ok, "%s %s is not described by an operation", method, route.pattern)
+			}
+		}
+		for name := range operations {
+			assert.True(t, routes[name], "%s is described, but has no route", name)
+		}
+	})
+
+	t.Run("The specification is served as JSON", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		spec := struct {
+			OpenAPI    string                            `json:"openapi"`
+			Paths      map[string]map[string]interface{} `json:"paths"`
+			Components struct {
+				Schemas map[string]struct {
+					Required   []string               `json:"required"`
+					Properties map[string]interface{} `json:"properties"`
+				} `json:"schemas"`
+			} `json:"components"`
+		}{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+		assert.Equal(t, "3.0.3", spec.OpenAPI)
+		assert.Contains(t, spec.Paths["/metadata/{id}"], "parameters")
+		assert.Contains(t, spec.Paths["/metadata/{id}"], "put")
+
+		metadata := spec.Components.Schemas["Metadata"]
+		assert.Equal(t, []string{"id", "state", "created", "updated", "revision", "title", "version", "maintainers", "company", "website", "source", "license", "description"}, metadata.Required)
+		assert.Contains(t, metadata.Properties, "yank_reason")
+		submitted := spec.Components.Schemas["MetadataYAML"]
+		assert.Equal(t, []string{"title", "version", "maintainers", "company", "website", "source", "license", "description"}, submitted.Required)
+		assert.NotContains(t, submitted.Properties, "id")
+	})
+
+	t.Run("The specification is served as YAML", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+		spec := map[string]interface{}{}
+		assert.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &spec))
+		assert.Equal(t, "3.0.3", spec["openapi"])
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/medhir/yaml-api/storage"
+)
+
+// writeJSON encodes v as the JSON body of a response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, fmt.Sprintf("could not encode JSON:\n%v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// write errors, such as clients disconnecting, are logged by logRequests
+	_, _ = w.Write(data)
+}
+
+// ProblemContentType is the media type of error responses
+const ProblemContentType = "application/problem+json"
+
+// Problem describes why a request failed, as the problem details of RFC 7807
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Field and Reason describe why submitted metadata is invalid, such as maintainers.email and invalid_email
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Maintainer is the position of the invalid maintainer, for maintainer fields
+	Maintainer *int `json:"maintainer,omitempty"`
+	// Document is the position of the invalid document in a batch
+	Document *int `json:"document,omitempty"`
+	// Errors describes every invalid document in a batch
+	Errors []Problem `json:"errors,omitempty"`
+}
+
+func newProblem(status int, detail string) Problem {
+	return Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// invalidMetadataProblem describes why metadata failed validation
+func invalidMetadataProblem(err error) Problem {
+	problem := newProblem(http.StatusBadRequest, err.Error())
+	if validationErr, ok := err.(*storage.ValidationError); ok {
+		problem.Field = validationErr.Field
+		problem.Reason = validationErr.Reason
+		if validationErr.Index >= 0 {
+			index := validationErr.Index
+			problem.Maintainer = &index
+		}
+	}
+	return problem
+}
+
+// writeProblem writes an error response describing why a request failed
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	writeProblemBody(w, newProblem(status, detail))
+}
+
+// writeInvalidMetadata writes an error response describing why submitted metadata failed validation
+func writeInvalidMetadata(w http.ResponseWriter, err error) {
+	writeProblemBody(w, invalidMetadataProblem(err))
+}
+
+func writeProblemBody(w http.ResponseWriter, problem Problem) {
+	// problems only hold strings and numbers, so encoding them cannot fail
+	data, _ := json.Marshal(problem)
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// middleware wraps a handler with behavior shared by many routes, such as authentication
+type middleware func(http.Handler) http.Handler
+
+// router dispatches requests to handlers by method and path. Patterns are made of slash separated segments, where
+// a segment such as {id} matches any single segment of a path and makes it available with pathParam.
+type router struct {
+	routes     []*route
+	middleware []middleware
+	handler    http.Handler
+}
+
+// route holds the handlers of a pattern, by method
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+}
+
+type pathParamsKey struct{}
+
+func newRouter() *router {
+	rt := &router{}
+	rt.handler = http.HandlerFunc(rt.dispatch)
+	return rt
+}
+
+// use adds middleware that wraps every request served by the router, including those that match no route. The
+// first middleware added is the outermost.
+func (rt *router) use(middleware ...middleware) {
+	rt.middleware = append(rt.middleware, middleware...)
+	rt.handler = chain(http.HandlerFunc(rt.dispatch), rt.middleware...)
+}
+
+// handle registers the handler for requests with the given method and path pattern, wrapped by the given middleware
+func (rt *router) handle(method, pattern string, handler http.HandlerFunc, middleware ...middleware) {
+	var matched *route
+	for _, route := range rt.routes {
+		if route.pattern == pattern {
+			matched = route
+		}
+	}
+	if matched == nil {
+		matched = &route{
+			pattern:  pattern,
+			segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+			handlers: map[string]http.Handler{},
+		}
+		rt.routes = append(rt.routes, matched)
+	}
+	if _, ok := matched.handlers[method]; ok {
+		panic(fmt.Sprintf("a handler is already registered for %s %s", method, pattern))
+	}
+	matched.handlers[method] = chain(handler, middleware...)
+}
+
+// chain wraps handler with middleware, the first of which is the outermost
+func chain(handler http.Handler, middleware ...middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.handler.ServeHTTP(w, r)
+}
+
+// dispatch calls the handler of the route matching a request. Requests for a known path with a method that has no
+// handler are rejected with 405, listing the allowed methods in the Allow header.
+func (rt *router) dispatch(w http.ResponseWriter, r *http.Request) {
+	route, params := rt.match(r)
+	if route == nil {
+		writeProblem(w, http.StatusNotFound, fmt.Sprintf("no resource found at %s", r.URL.Path))
+		return
+	}
+	handler, ok := route.handlers[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		handler, ok = route.handlers[http.MethodGet]
+	}
+	if !ok {
+		w.Header().Set("Allow", strings.Join(route.methods(), ", "))
+		writeProblem(w, http.StatusMethodNotAllowed, fmt.Sprintf("unimplemented http handler for method %s", r.Method))
+		return
+	}
+	if len(params) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// pattern returns the pattern of the route matching a request, or an empty string if none match
+func (rt *router) pattern(r *http.Request) string {
+	route, _ := rt.match(r)
+	if route == nil {
+		return ""
+	}
+	return route.pattern
+}
+
+// match finds the route matching the path of a request, along with the values of its path parameters. When several
+// routes match, literal segments are preferred over parameters, so /webhooks/dead-letters takes precedence over
+// /webhooks/{id}.
+func (rt *router) match(r *http.Request) (*route, map[string]string) {
+	// segments are split before unescaping, so parameters may contain escaped slashes
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, nil
+		}
+		segments[i] = unescaped
+	}
+	if strings.HasSuffix(r.URL.Path, "/") && r.URL.Path != "/" {
+		// trailing slashes identify a different resource, which no route serves
+		return nil, nil
+	}
+	var best *route
+	var bestParams map[string]string
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if ok && (best == nil || route.moreSpecific(best)) {
+			best, bestParams = route, params
+		}
+	}
+	return best, bestParams
+}
+
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, segment := range rt.segments {
+		if name, ok := paramName(segment); ok {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[name] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// moreSpecific reports whether the first segment in which two routes differ is a literal in rt, and a parameter
+// in other
+func (rt *route) moreSpecific(other *route) bool {
+	for i, segment := range rt.segments {
+		_, param := paramName(segment)
+		_, otherParam := paramName(other.segments[i])
+		if param != otherParam {
+			return otherParam
+		}
+	}
+	return false
+}
+
+// methods returns the methods a route allows, including HEAD for routes that allow GET
+func (rt *route) methods() []string {
+	methods := []string{}
+	for method := range rt.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := rt.handlers[http.MethodGet]; ok {
+		if _, ok := rt.handlers[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func paramName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// pathParam returns the value of a parameter in the path of a request, such as id in /metadata/{id}
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
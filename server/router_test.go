@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_router(t *testing.T) {
+	calls := []string{}
+	rt := newRouter()
+	record := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	respond := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + pathParam(r, "id")))
+		}
+	}
+	rt.use(record("outer"), record("inner"))
+	rt.handle(http.MethodGet, "/apps/{id}", respond("get app"), record("route"))
+	rt.handle(http.MethodDelete, "/apps/{id}", respond("delete app"))
+	rt.handle(http.MethodGet, "/apps/latest", respond("latest app"))
+	rt.handle(http.MethodGet, "/apps/{id}/diff", respond("diff"))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		calls = []string{}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	t.Run("Requests are routed by method and path, with path parameters", func(t *testing.T) {
+		assert.Equal(t, "get app 123", serve(http.MethodGet, "/apps/123").Body.String())
+		assert.Equal(t, "delete app 123", serve(http.MethodDelete, "/apps/123").Body.String())
+		assert.Equal(t, "diff 123", serve(http.MethodGet, "/apps/123/diff").Body.String())
+	})
+
+	t.Run("Path parameters may contain escaped slashes", func(t *testing.T) {
+		assert.Equal(t, "diff team/app", serve(http.MethodGet, "/apps/team%2Fapp/diff").Body.String())
+	})
+
+	t.Run("Literal segments take precedence over parameters", func(t *testing.T) {
+		assert.Equal(t, "latest app ", serve(http.MethodGet, "/apps/latest").Body.String())
+	})
+
+	t.Run("Unknown paths are not found", func(t *testing.T) {
+		for _, path := range []string{"/apps", "/apps/", "/apps/123/", "/apps//diff", "/apps/123/diff/more"} {
+			assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, path).Code, path)
+		}
+	})
+
+	t.Run("Unsupported methods are not allowed", func(t *testing.T) {
+		w := serve(http.MethodPost, "/apps/123")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "DELETE, GET, HEAD", w.Header().Get("Allow"))
+		problem := Problem{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, newProblem(http.StatusMethodNotAllowed, "unimplemented http handler for method POST"), problem)
+	})
+
+	t.Run("HEAD requests are served by GET handlers", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(http.MethodHead, "/apps/123/diff").Code)
+	})
+
+	t.Run("Middleware is applied in order, including to unmatched requests", func(t *testing.T) {
+		serve(http.MethodGet, "/apps/123")
+		assert.Equal(t, []string{"outer", "inner", "route"}, calls)
+		serve(http.MethodGet, "/unknown")
+		assert.Equal(t, []string{"outer", "inner"}, calls)
+	})
+
+	t.Run("Handlers cannot be registered twice", func(t *testing.T) {
+		assert.Panics(t, func() { rt.handle(http.MethodGet, "/apps/{id}", respond("again")) })
+	})
+}
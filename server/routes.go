@@ -1,5 +1,52 @@
 package server
 
+import (
+	"net/http"
+
+	"github.com/medhir/yaml-api/auth"
+)
+
 func (s *Server) setRoutes() {
-	s.router.HandleFunc("/metadata", s.handleMetadata())
+	read := s.withScope(auth.ScopeRead)
+	write := s.withScope(auth.ScopeWrite)
+	admin := s.withScope(auth.ScopeAdmin)
+
+	s.router.handle(http.MethodGet, "/metadata", s.handleGetMetadata(), read)
+	s.router.handle(http.MethodPost, "/metadata", s.handlePostMetadata(), write)
+	s.router.handle(http.MethodGet, "/metadata/explain", s.handleExplainMetadata(), read)
+	s.router.handle(http.MethodPost, "/metadata/batch", s.handlePostMetadataBatch(), write)
+	s.router.handle(http.MethodGet, "/metadata/{id}", s.handleGetMetadataByID(), read)
+	s.router.handle(http.MethodPut, "/metadata/{id}", s.handlePutMetadata(), write)
+	s.router.handle(http.MethodPatch, "/metadata/{id}", s.handlePatchMetadata(), write)
+	s.router.handle(http.MethodDelete, "/metadata/{id}", s.handleDeleteMetadata(), write)
+	s.router.handle(http.MethodPost, "/metadata/{id}/deprecate", s.handleDeprecateMetadata(), write)
+	s.router.handle(http.MethodPost, "/metadata/{id}/yank", s.handleYankMetadata(), write)
+	s.router.handle(http.MethodGet, "/apps/{name}/versions", s.handleGetAppVersions(), read)
+	s.router.handle(http.MethodGet, "/apps/{name}/diff", s.handleGetAppDiff(), read)
+	s.router.handle(http.MethodGet, "/events", s.handleEvents(), read)
+
+	s.router.handle(http.MethodGet, "/searches", s.handleGetSearches(), read)
+	s.router.handle(http.MethodPost, "/searches", s.handlePostSearch(), write)
+	s.router.handle(http.MethodGet, "/searches/{id}", s.handleGetSearch(), read)
+	s.router.handle(http.MethodDelete, "/searches/{id}", s.handleDeleteSearch(), write)
+	s.router.handle(http.MethodGet, "/searches/{id}/matches", s.handleGetSearchMatches(), read)
+
+	s.router.handle(http.MethodGet, "/webhooks", s.handleGetWebhooks(), write)
+	s.router.handle(http.MethodPost, "/webhooks", s.handlePostWebhook(), write)
+	s.router.handle(http.MethodGet, "/webhooks/dead-letters", s.handleGetDeadLetters(), write)
+	s.router.handle(http.MethodGet, "/webhooks/{id}", s.handleGetWebhook(), write)
+	s.router.handle(http.MethodDelete, "/webhooks/{id}", s.handleDeleteWebhook(), write)
+	s.router.handle(http.MethodGet, "/webhooks/{id}/deliveries", s.handleGetDeliveries(), write)
+
+	s.router.handle(http.MethodGet, "/admin/export", s.handleExport(), admin)
+	s.router.handle(http.MethodPost, "/admin/import", s.handleImport(), admin, s.limitBodyTo(s.maxImportBytes))
+	s.router.handle(http.MethodPost, "/admin/reindex", s.handleReindex(), admin)
+	s.router.handle(http.MethodGet, "/admin/index/stats", s.handleIndexStats(), admin)
+
+	s.router.handle(http.MethodGet, "/metrics", s.metrics.registry.Handler(), read)
+	s.router.handle(http.MethodGet, "/healthz", s.handleHealthz())
+	s.router.handle(http.MethodGet, "/readyz", s.handleReadyz())
+	s.router.handle(http.MethodGet, "/version", s.handleVersion())
+	s.router.handle(http.MethodGet, "/openapi.json", s.handleOpenAPI("json"))
+	s.router.handle(http.MethodGet, "/openapi.yaml", s.handleOpenAPI("yaml"))
 }
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/storage"
+)
+
+// searchOwner returns the name of the principal whose saved searches a request may see, or an empty string if it may
+// see every saved search, as admins and servers without authentication may. ok is false for anonymous callers of a
+// server with authentication, which may not see any.
+func (s *Server) searchOwner(r *http.Request) (owner string, ok bool) {
+	if s.authenticator != nil && auth.FromContext(r.Context()) == nil {
+		return "", false
+	}
+	return webhookOwner(r), true
+}
+
+// ownedSearch returns the saved search with the ID in the path, writing 404 if it does not exist or belongs to
+// another principal, so that callers cannot tell the two apart
+func (s *Server) ownedSearch(w http.ResponseWriter, r *http.Request) (*storage.SavedSearch, bool) {
+	id := pathParam(r, "id")
+	search, err := s.storage.GetSavedSearch(id)
+	if owner, ok := s.searchOwner(r); err == nil && (!ok || owner != "" && search.Owner != owner) {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		writeProblem(w, http.StatusNotFound, fmt.Sprintf("no saved search found with id %s", id))
+		return nil, false
+	}
+	return search, true
+}
+
+func (s *Server) handlePostSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		search := struct {
+			Name  string            `json:"name"`
+			Query map[string]string `json:"query"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&search)
+		if err != nil {
+			writeBodyError(w, "request does not contain a valid JSON saved search", err)
+			return
+		}
+		owner := ""
+		if principal := auth.FromContext(r.Context()); principal != nil {
+			owner = principal.ID()
+		}
+		saved, err := s.storage.SaveSearch(search.Name, search.Query, owner)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("Location", "/searches/"+saved.ID)
+		writeJSON(w, http.StatusCreated, saved)
+	}
+}
+
+func (s *Server) handleGetSearchMatches() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		search, ok := s.ownedSearch(w, r)
+		if !ok {
+			return
+		}
+		var since uint64
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			since, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				writeProblem(w, http.StatusBadRequest, fmt.Sprintf("since must be a positive integer, got %q", v))
+				return
+			}
+		}
+		matches, err := s.storage.SearchMatches(search.ID, since)
+		if err == storage.ErrNotFound {
+			writeProblem(w, http.StatusNotFound, fmt.Sprintf("no saved search found with id %s", search.ID))
+			return
+		}
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, matches)
+	}
+}
+
+func (s *Server) handleGetSearches() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := s.searchOwner(r)
+		if !ok {
+			writeJSON(w, http.StatusOK, []*storage.SavedSearch{})
+			return
+		}
+		writeJSON(w, http.StatusOK, s.storage.SavedSearches(owner))
+	}
+}
+
+func (s *Server) handleGetSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		search, ok := s.ownedSearch(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, search)
+	}
+}
+
+func (s *Server) handleDeleteSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		search, ok := s.ownedSearch(w, r)
+		if !ok {
+			return
+		}
+		err := s.storage.DeleteSavedSearch(search.ID)
+		if err != nil {
+			writeProblem(w, http.StatusNotFound, fmt.Sprintf("no saved search found with id %s", search.ID))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
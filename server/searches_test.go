@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_searchOwnership(t *testing.T) {
+	authenticator := fakeAuthenticator{
+		"alice": {Name: "alice", Scopes: []auth.Scope{auth.ScopeWrite}},
+		"bob":   {Name: "bob", Scopes: []auth.Scope{auth.ScopeWrite}},
+		"admin": {Name: "admin", Scopes: []auth.Scope{auth.ScopeAdmin}},
+	}
+	s := newTestServer(WithAuthenticator(authenticator), WithAnonymousReads(true))
+	request := func(method, target, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set(auth.APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	w := request(http.MethodPost, "/searches", "alice", `{"name": "MIT apps", "query": {"license": "MIT"}}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	search := storage.SavedSearch{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &search))
+	assert.Equal(t, "alice", search.Owner)
+
+	t.Run("Saved searches are listed for their owner, and for admins", func(t *testing.T) {
+		for key, count := range map[string]int{"alice": 1, "bob": 0, "admin": 1, "": 0} {
+			searches := []storage.SavedSearch{}
+			assert.NoError(t, json.Unmarshal(request(http.MethodGet, "/searches", key, "").Body.Bytes(), &searches))
+			assert.Len(t, searches, count, key)
+		}
+	})
+
+	t.Run("Other principals cannot see a saved search, its matches, or delete it", func(t *testing.T) {
+		for _, target := range []string{"/searches/" + search.ID, "/searches/" + search.ID + "/matches"} {
+			assert.Equal(t, http.StatusNotFound, request(http.MethodGet, target, "bob", "").Code)
+			assert.Equal(t, http.StatusNotFound, request(http.MethodGet, target, "", "").Code)
+			assert.Equal(t, http.StatusOK, request(http.MethodGet, target, "alice", "").Code)
+			assert.Equal(t, http.StatusOK, request(http.MethodGet, target, "admin", "").Code)
+		}
+		assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/searches/"+search.ID, "bob", "").Code)
+		assert.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/searches/"+search.ID, "alice", "").Code)
+	})
+}
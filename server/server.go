@@ -3,51 +3,255 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/events"
+	"github.com/medhir/yaml-api/logging"
 	"github.com/medhir/yaml-api/storage"
+	"github.com/medhir/yaml-api/webhook"
 )
 
 type Server struct {
-	ctx     context.Context
-	router  *http.ServeMux
-	server  *http.Server
-	storage *storage.Storage
+	ctx            context.Context
+	router         *router
+	server         *http.Server
+	storage        *storage.Storage
+	events         *events.Broker
+	webhooks       *webhook.Dispatcher
+	webhookOptions webhook.Options
+	metrics        *serverMetrics
+	logger         *logging.Logger
+	authenticator  auth.Authenticator
+	anonymousReads bool
+	eventBuffer    int
+	// readinessChecks must pass for /readyz to report the server as ready
+	readinessChecks []readinessCheck
+	// shuttingDown is set to 1 once a graceful shutdown starts
+	shuttingDown int32
+	// drainDelay is how long readiness fails before the server stops accepting connections
+	drainDelay time.Duration
+	timeouts   Timeouts
+	strictYAML bool
+	// maxBodyBytes is the largest request body accepted, and maxImportBytes the largest import
+	maxBodyBytes   int64
+	maxImportBytes int64
+	// readLimiter and writeLimiter throttle principals calling read routes, and write and admin routes
+	readLimiter  *rateLimiter
+	writeLimiter *rateLimiter
+	// readAddressLimiter and writeAddressLimiter throttle unauthenticated requests by client address, apart from
+	// principals, so principals behind a shared address never share an allowance
+	readAddressLimiter  *rateLimiter
+	writeAddressLimiter *rateLimiter
+}
+
+// Option configures optional behavior of a server
+type Option func(*Server)
+
+// WithAuthenticator requires requests to be authenticated by the given authenticator.
+// Without an authenticator, every request is allowed.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = authenticator
+	}
+}
+
+// WithAnonymousReads allows requests without credentials to read metadata when authentication is enabled
+func WithAnonymousReads(allowed bool) Option {
+	return func(s *Server) {
+		s.anonymousReads = allowed
+	}
+}
+
+// WithEventBufferSize sets how many change events are kept for clients resuming the event stream
+func WithEventBufferSize(size int) Option {
+	return func(s *Server) {
+		s.eventBuffer = size
+	}
+}
+
+// WithWebhookOptions configures how webhooks are delivered
+func WithWebhookOptions(options webhook.Options) Option {
+	return func(s *Server) {
+		s.webhookOptions = options
+	}
+}
+
+// WithLogger sets the logger used for access logs and errors. By default, entries at the info level and above
+// are written to standard output.
+func WithLogger(logger *logging.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithDrainDelay sets how long /readyz reports the server as unavailable before a graceful shutdown stops accepting
+// connections, giving load balancers time to stop routing traffic to it
+func WithDrainDelay(delay time.Duration) Option {
+	return func(s *Server) {
+		s.drainDelay = delay
+	}
+}
+
+// WithStorage sets the store metadata is kept in, instead of a store with default options
+func WithStorage(store *storage.Storage) Option {
+	return func(s *Server) {
+		s.storage = store
+	}
+}
+
+// WithStrictYAML rejects submitted metadata with unknown or duplicate attributes, rather than ignoring them
+func WithStrictYAML(strict bool) Option {
+	return func(s *Server) {
+		s.strictYAML = strict
+	}
+}
+
+// Timeouts limit how long the server waits on clients, and on itself when shutting down
+type Timeouts struct {
+	// ReadHeader limits reading request headers, protecting against clients that send them slowly
+	ReadHeader time.Duration
+	// Read limits reading a whole request, including the body
+	Read time.Duration
+	// Write limits writing a response, from the end of reading the request headers. Event streams are
+	// disconnected when it elapses, and clients resume them from the last event they received.
+	Write time.Duration
+	// Idle limits how long keep-alive connections wait for the next request
+	Idle time.Duration
+	// Shutdown limits how long a graceful shutdown waits for in-flight requests and pending webhooks.
+	// A zero value waits indefinitely, as do the other zero timeouts.
+	Shutdown time.Duration
+}
+
+// DefaultTimeouts leave writes unlimited, so that event streams stay open
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 5 * time.Second,
+	Read:       30 * time.Second,
+	Idle:       120 * time.Second,
+	Shutdown:   30 * time.Second,
+}
+
+// WithTimeouts sets the timeouts of the http server, and of graceful shutdown
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(s *Server) {
+		s.timeouts = timeouts
+	}
 }
 
 // NewServer initializes a server object
-func NewServer(port string) *Server {
+func NewServer(port string, options ...Option) *Server {
 	server := &Server{
 		ctx:    context.Background(),
-		router: http.DefaultServeMux,
+		router: newRouter(),
 		server: &http.Server{
 			Addr: port,
 		},
-		storage: storage.NewStorage(),
+		storage:        storage.NewStorage(),
+		logger:         logging.New(os.Stdout, logging.LevelInfo),
+		eventBuffer:    1024,
+		timeouts:       DefaultTimeouts,
+		maxBodyBytes:   DefaultMaxBodyBytes,
+		maxImportBytes: DefaultMaxImportBytes,
+	}
+	for _, option := range options {
+		option(server)
+	}
+	server.server.ReadHeaderTimeout = server.timeouts.ReadHeader
+	server.server.ReadTimeout = server.timeouts.Read
+	server.server.WriteTimeout = server.timeouts.Write
+	server.server.IdleTimeout = server.timeouts.Idle
+	server.router.use(server.logRequests, server.instrument, server.compress, server.limitBody)
+	server.server.Handler = server.router
+	server.metrics = newServerMetrics(server.storage)
+	server.events = events.NewBroker(server.eventBuffer)
+	if server.webhookOptions.Logger == nil {
+		server.webhookOptions.Logger = server.logger
 	}
+	server.webhooks = webhook.NewDispatcher(server.webhookOptions)
+	server.publishChanges()
 	server.setRoutes()
 	return server
 }
 
-// Start opens a connection to accept http traffic on the desired port
-func (s *Server) Start() {
-	fmt.Printf("Listening on port %s\n", s.server.Addr)
-	err := s.server.ListenAndServe()
+// ServeHTTP serves a request with the server's routes and middleware, so servers can be mounted in other servers,
+// such as httptest servers
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Start accepts http traffic on the server's address until the process receives SIGINT or SIGTERM, then shuts down
+// gracefully. It returns an error if the server cannot listen, or does not shut down cleanly.
+func (s *Server) Start() error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	listener, err := net.Listen("tcp", s.server.Addr)
 	if err != nil {
-		fmt.Println("Server stopped unexpectedly:", err)
-		s.shutdown()
+		return fmt.Errorf("could not listen on %s: %v", s.server.Addr, err)
 	}
+	return s.serve(listener, stop)
 }
 
-// shutdown gracefully shuts down a server instance
-func (s *Server) shutdown() {
-	if s.server != nil {
-		ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
-		defer cancel()
-		err := s.server.Shutdown(ctx)
-		if err != nil {
-			fmt.Println("Failed to shut down server gracefully.", err)
+// serve accepts http traffic from listener until a signal is received from stop, or the server fails
+func (s *Server) serve(listener net.Listener, stop <-chan os.Signal) error {
+	s.logger.Info("listening for http traffic", "addr", listener.Addr().String(), "tls", s.server.TLSConfig != nil)
+	failed := make(chan error, 1)
+	go func() {
+		if s.server.TLSConfig != nil {
+			// certificates are provided by the TLS config, rather than files
+			failed <- s.server.ServeTLS(listener, "", "")
+			return
 		}
+		failed <- s.server.Serve(listener)
+	}()
+	select {
+	case err := <-failed:
+		s.logger.Error("server stopped unexpectedly", "error", err)
+		shutdownErr := s.shutdown()
+		if shutdownErr != nil {
+			s.logger.Error("failed to shut down server gracefully", "error", shutdownErr)
+		}
+		return err
+	case sig := <-stop:
+		s.logger.Info("shutting down", "signal", sig.String())
+	}
+	err := s.shutdown()
+	if err != nil {
+		return err
+	}
+	s.logger.Info("shut down gracefully")
+	return nil
+}
+
+// shutdown gracefully shuts down a server instance. Readiness fails first, then the server stops accepting
+// connections and waits for in-flight requests, and finally pending webhooks are delivered.
+func (s *Server) shutdown() error {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+	if s.drainDelay > 0 {
+		s.logger.Info("draining traffic before shutting down", "delay", s.drainDelay.String())
+		time.Sleep(s.drainDelay)
+	}
+	// event streams never finish on their own, so disconnect them before waiting for requests to finish
+	s.events.Close()
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	if s.timeouts.Shutdown > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeouts.Shutdown)
+		defer cancel()
+	}
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("could not finish in-flight requests: %v", err)
+	}
+	err = s.webhooks.Close(ctx)
+	if err != nil {
+		return fmt.Errorf("could not deliver pending webhooks: %v", err)
 	}
+	return nil
 }
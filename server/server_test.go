@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/medhir/yaml-api/events"
+	"github.com/medhir/yaml-api/logging"
+	"github.com/medhir/yaml-api/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer creates a server with every route, which logs nothing unless given a logger
+func newTestServer(options ...Option) *Server {
+	options = append([]Option{WithLogger(logging.New(ioutil.Discard, logging.LevelError))}, options...)
+	return NewServer("127.0.0.1:0", options...)
+}
+
+func Test_NewServer(t *testing.T) {
+	t.Run("Servers route requests independently of each other", func(t *testing.T) {
+		first, second := newTestServer(), newTestServer()
+		first.router.handle(http.MethodGet, "/first", func(w http.ResponseWriter, r *http.Request) {})
+		w := httptest.NewRecorder()
+		second.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/first", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func Test_serve(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	s := &Server{
+		ctx:      context.Background(),
+		server:   &http.Server{},
+		events:   events.NewBroker(1),
+		webhooks: webhook.NewDispatcher(webhook.Options{}),
+		timeouts: DefaultTimeouts,
+	}
+	s.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		w.Write([]byte("finished"))
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serve(listener, stop)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+	<-started
+	stop <- syscall.SIGTERM
+
+	t.Run("A signal fails readiness and stops accepting connections", func(t *testing.T) {
+		assert.Eventually(t, func() bool { return s.checkNotShuttingDown() != nil }, time.Second, time.Millisecond)
+		assert.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err == nil {
+				conn.Close()
+			}
+			return err != nil
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("In-flight requests finish before the server stops", func(t *testing.T) {
+		select {
+		case <-served:
+			t.Fatal("server stopped before in-flight requests finished")
+		default:
+		}
+		close(finish)
+		assert.Equal(t, "finished", <-responses)
+		select {
+		case err := <-served:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not stop")
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/medhir/yaml-api/logging"
+)
+
+// TLSFiles names the PEM files used to serve HTTPS. Every file is reloaded when it changes, so certificates can be
+// rotated without a restart.
+type TLSFiles struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the CAs trusted to issue client certificates. When set, clients may authenticate with
+	// mutual TLS.
+	ClientCAFile string
+	// RequireClientCert rejects connections without a client certificate verified by a trusted CA
+	RequireClientCert bool
+}
+
+// LoadTLSConfig loads the certificate, key and client CAs, returning a TLS config that reloads them whenever the
+// files change. If changed files cannot be loaded, for example while they are being rewritten, the previous
+// certificates are kept and the error is logged.
+func LoadTLSConfig(files TLSFiles, logger *logging.Logger) (*tls.Config, error) {
+	if files.CertFile == "" || files.KeyFile == "" {
+		return nil, errors.New("a certificate file and key file are required to serve TLS")
+	}
+	if files.RequireClientCert && files.ClientCAFile == "" {
+		return nil, errors.New("a client CA file is required to verify client certificates")
+	}
+	// the config for each handshake replaces this one entirely, so it must offer HTTP/2 itself
+	nextProtos := []string{"h2", "http/1.1"}
+	reloader := &tlsReloader{files: files, nextProtos: nextProtos, logger: logger}
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		// every handshake is configured from the latest files
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return reloader.current(), nil
+		},
+	}, nil
+}
+
+// tlsReloader holds the TLS config built from a set of files, rebuilding it when any of them change
+type tlsReloader struct {
+	files TLSFiles
+	// nextProtos are the application protocols negotiated with ALPN, copied from the base config
+	nextProtos []string
+	logger     *logging.Logger
+
+	mu     sync.Mutex
+	stats  map[string]fileStat
+	config *tls.Config
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func (r *tlsReloader) paths() []string {
+	paths := []string{r.files.CertFile, r.files.KeyFile}
+	if r.files.ClientCAFile != "" {
+		paths = append(paths, r.files.ClientCAFile)
+	}
+	return paths
+}
+
+// current returns the TLS config, reloading the files if any have been modified since they were last read
+func (r *tlsReloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			// a file that is briefly missing while it is replaced keeps the previous config
+			return r.config
+		}
+		if stat := r.stats[path]; !stat.modTime.Equal(info.ModTime()) || stat.size != info.Size() {
+			err := r.load()
+			if err != nil {
+				r.logger.Error("could not reload TLS certificates", "error", err)
+			} else {
+				r.logger.Info("reloaded TLS certificates", "cert_file", r.files.CertFile)
+			}
+			break
+		}
+	}
+	return r.config
+}
+
+func (r *tlsReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load()
+}
+
+// load reads every file, replacing the config only if all of them are valid. It must be called while holding
+// the lock.
+func (r *tlsReloader) load() error {
+	stats := map[string]fileStat{}
+	for _, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("unable to read TLS file: %s", err.Error())
+		}
+		stats[path] = fileStat{modTime: info.ModTime(), size: info.Size()}
+	}
+	// invalid files are not retried until they change again
+	r.stats = stats
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load TLS certificate: %s", err.Error())
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   r.nextProtos,
+	}
+	if r.files.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(r.files.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read client CA file: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("client CA file does not contain any PEM encoded certificates")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.files.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	r.config = config
+	return nil
+}
+
+// WithTLSConfig serves HTTPS using the given config, such as one created by LoadTLSConfig
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.server.TLSConfig = config
+	}
+}
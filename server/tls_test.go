@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/events"
+	"github.com/medhir/yaml-api/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a locally generated certificate and its private key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// issueCert creates a certificate signed by parent, or a self-signed CA when parent is nil
+func issueCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) writeFiles(t *testing.T, certPath, keyPath string) {
+	err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	assert.NoError(t, err)
+	if keyPath == "" {
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	assert.NoError(t, err)
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func serverCert(t *testing.T, ca *testCert, name string) *testCert {
+	return issueCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+}
+
+func Test_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certPath, keyPath, caPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+
+	ca := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}}, nil)
+	ca.writeFiles(t, caPath, "")
+	serverCert(t, ca, "first").writeFiles(t, certPath, keyPath)
+	client := issueCert(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "ci-pipeline"},
+		EmailAddresses: []string{"ci@example.com"},
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+	}, ca)
+	untrustedCA := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Untrusted CA"}}, nil)
+	untrusted := issueCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "intruder"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, untrustedCA)
+
+	tlsConfig, err := LoadTLSConfig(TLSFiles{CertFile: certPath, KeyFile: keyPath, ClientCAFile: caPath}, nil)
+	assert.NoError(t, err)
+	s := &Server{
+		ctx:      context.Background(),
+		router:   newRouter(),
+		server:   &http.Server{},
+		events:   events.NewBroker(1),
+		webhooks: webhook.NewDispatcher(webhook.Options{}),
+		timeouts: DefaultTimeouts,
+		authenticator: auth.NewCertAuthenticator(auth.CertConfig{
+			IdentityScopes: map[string][]auth.Scope{"ci-pipeline": {auth.ScopeWrite}},
+		}),
+	}
+	WithTLSConfig(tlsConfig)(s)
+	s.router.handle(http.MethodGet, "/whoami", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, auth.FromContext(r.Context()))
+	}, s.withScope(auth.ScopeRead))
+	s.server.Handler = s.router
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serve(listener, stop)
+	}()
+	t.Cleanup(func() {
+		stop <- os.Interrupt
+		<-served
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCert *testCert) (*http.Response, error) {
+		config := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if clientCert != nil {
+			// always present the certificate, even when it was not issued by a CA the server asks for
+			certificate := clientCert.tlsCertificate()
+			config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &certificate, nil
+			}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+		return client.Get("https://" + listener.Addr().String() + "/whoami")
+	}
+
+	t.Run("Client certificates issued by a trusted CA authenticate a principal", func(t *testing.T) {
+		resp, err := get(client)
+		if assert.NoError(t, err) {
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			principal := &auth.Principal{}
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(principal))
+			assert.Equal(t, &auth.Principal{Name: "ci-pipeline", Email: "ci@example.com", Method: auth.MethodCert, Scopes: []auth.Scope{auth.ScopeWrite}}, principal)
+			assert.Equal(t, "first", resp.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	})
+
+	t.Run("HTTP/2 is negotiated", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "localhost"},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get("https://" + listener.Addr().String() + "/whoami")
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, "HTTP/2.0", resp.Proto)
+		}
+	})
+
+	t.Run("Connections without a client certificate have no credentials", func(t *testing.T) {
+		resp, err := get(nil)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("Client certificates from untrusted CAs fail the handshake", func(t *testing.T) {
+		resp, err := get(untrusted)
+		if err == nil {
+			resp.Body.Close()
+			t.Fatalf("expected the request to fail, got %s", resp.Status)
+		}
+	})
+
+	t.Run("Certificates are reloaded when their files change", func(t *testing.T) {
+		serverCert(t, ca, "second").writeFiles(t, certPath, keyPath)
+		later := time.Now().Add(time.Minute)
+		assert.NoError(t, os.Chtimes(certPath, later, later))
+		resp, err := get(client)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, "second", resp.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	})
+
+	t.Run("Invalid files keep the previous certificate", func(t *testing.T) {
+		assert.NoError(t, ioutil.WriteFile(certPath, []byte("not a certificate"), 0600))
+		resp, err := get(client)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, "second", resp.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	})
+}
+
+func Test_LoadTLSConfig(t *testing.T) {
+	_, err := LoadTLSConfig(TLSFiles{CertFile: "cert.pem"}, nil)
+	assert.EqualError(t, err, "a certificate file and key file are required to serve TLS")
+	_, err = LoadTLSConfig(TLSFiles{CertFile: "cert.pem", KeyFile: "key.pem", RequireClientCert: true}, nil)
+	assert.EqualError(t, err, "a client CA file is required to verify client certificates")
+	_, err = LoadTLSConfig(TLSFiles{CertFile: "/does/not/exist.pem", KeyFile: "/does/not/exist.pem"}, nil)
+	assert.EqualError(t, err, "unable to read TLS file: stat /does/not/exist.pem: no such file or directory")
+}
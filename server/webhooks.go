@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/webhook"
+)
+
+// webhookOwner returns the name of the principal whose subscriptions, and saved searches, a request may see, or an
+// empty string if it may see every one, as admins and servers without authentication may
+func webhookOwner(r *http.Request) string {
+	principal := auth.FromContext(r.Context())
+	if principal == nil || principal.HasScope(auth.ScopeAdmin) {
+		return ""
+	}
+	return principal.ID()
+}
+
+// ownedSubscription returns the subscription with the ID in the path, writing 404 if it does not exist or belongs to
+// another principal, so that callers cannot tell the two apart
+func (s *Server) ownedSubscription(w http.ResponseWriter, r *http.Request) (webhook.Subscription, bool) {
+	sub, err := s.webhooks.Subscription(pathParam(r, "id"))
+	if owner := webhookOwner(r); err == nil && owner != "" && sub.Owner != owner {
+		err = webhook.ErrNotFound
+	}
+	if err != nil {
+		writeProblem(w, http.StatusNotFound, err.Error())
+		return webhook.Subscription{}, false
+	}
+	return sub, true
+}
+
+func (s *Server) handlePostWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub := webhook.Subscription{}
+		err := json.NewDecoder(r.Body).Decode(&sub)
+		if err != nil {
+			writeBodyError(w, "request does not contain a valid JSON webhook subscription", err)
+			return
+		}
+		sub.Owner = ""
+		if principal := auth.FromContext(r.Context()); principal != nil {
+			sub.Owner = principal.ID()
+		}
+		sub, err = s.webhooks.Subscribe(sub)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("Location", "/webhooks/"+sub.ID)
+		writeJSON(w, http.StatusCreated, sub)
+	}
+}
+
+// handleGetWebhooks lists the subscriptions created by the caller, or every subscription for admins
+func (s *Server) handleGetWebhooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.webhooks.Subscriptions(webhookOwner(r)))
+	}
+}
+
+func (s *Server) handleGetWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, ok := s.ownedSubscription(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, sub)
+	}
+}
+
+func (s *Server) handleDeleteWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, ok := s.ownedSubscription(w, r)
+		if !ok {
+			return
+		}
+		err := s.webhooks.Unsubscribe(sub.ID)
+		if err != nil {
+			writeProblem(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleGetDeliveries lists the delivery log of a webhook subscription
+func (s *Server) handleGetDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, ok := s.ownedSubscription(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.webhooks.Deliveries(sub.ID))
+	}
+}
+
+// handleGetDeadLetters lists deliveries that failed after every attempt, to the caller's subscriptions or to every
+// subscription for admins
+func (s *Server) handleGetDeadLetters() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.webhooks.DeadLetters(webhookOwner(r)))
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/medhir/yaml-api/auth"
+	"github.com/medhir/yaml-api/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_webhookOwnership(t *testing.T) {
+	authenticator := fakeAuthenticator{
+		"alice":     {Name: "alice", Method: auth.MethodAPIKey, Scopes: []auth.Scope{auth.ScopeWrite}},
+		"bob":       {Name: "bob", Method: auth.MethodAPIKey, Scopes: []auth.Scope{auth.ScopeWrite}},
+		"admin":     {Name: "admin", Method: auth.MethodAPIKey, Scopes: []auth.Scope{auth.ScopeAdmin}},
+		"jwt-alice": {Name: "alice", Method: auth.MethodJWT, Scopes: []auth.Scope{auth.ScopeWrite}},
+	}
+	s := newTestServer(WithAuthenticator(authenticator))
+	request := func(method, target, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set(auth.APIKeyHeader, key)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	w := request(http.MethodPost, "/webhooks", "alice", `{"url": "https://203.0.113.10/hook", "secret": "s3cret", "owner": "bob"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	sub := webhook.Subscription{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sub))
+	assert.Equal(t, "key:alice", sub.Owner)
+
+	t.Run("Subscriptions are listed for their owner, and for admins", func(t *testing.T) {
+		for key, count := range map[string]int{"alice": 1, "bob": 0, "admin": 1, "jwt-alice": 0} {
+			subs := []webhook.Subscription{}
+			assert.NoError(t, json.Unmarshal(request(http.MethodGet, "/webhooks", key, "").Body.Bytes(), &subs))
+			assert.Len(t, subs, count, key)
+		}
+	})
+
+	t.Run("Other principals cannot see or delete a subscription, even with the same name", func(t *testing.T) {
+		for _, target := range []string{"/webhooks/" + sub.ID, "/webhooks/" + sub.ID + "/deliveries"} {
+			assert.Equal(t, http.StatusNotFound, request(http.MethodGet, target, "bob", "").Code)
+			assert.Equal(t, http.StatusNotFound, request(http.MethodGet, target, "jwt-alice", "").Code)
+			assert.Equal(t, http.StatusOK, request(http.MethodGet, target, "alice", "").Code)
+			assert.Equal(t, http.StatusOK, request(http.MethodGet, target, "admin", "").Code)
+		}
+		assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/webhooks/"+sub.ID, "bob", "").Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/webhooks/"+sub.ID, "jwt-alice", "").Code)
+		assert.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/webhooks/"+sub.ID, "alice", "").Code)
+	})
+
+	t.Run("Subscriptions to internal addresses are rejected", func(t *testing.T) {
+		w := request(http.MethodPost, "/webhooks", "alice", `{"url": "http://169.254.169.254/latest/meta-data", "secret": "s3cret"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "must not be an internal address")
+	})
+}
@@ -0,0 +1,52 @@
+package storage
+
+import "time"
+
+// ChangeType describes how stored metadata was modified
+type ChangeType string
+
+const (
+	ChangeCreate    = ChangeType("create")
+	ChangeUpdate    = ChangeType("update")
+	ChangeDelete    = ChangeType("delete")
+	ChangeDeprecate = ChangeType("deprecate")
+	ChangeYank      = ChangeType("yank")
+)
+
+// Change describes a modification to stored metadata. For deletions, Metadata holds the deleted metadata.
+type Change struct {
+	Type     ChangeType `json:"type"`
+	Metadata *Metadata  `json:"metadata"`
+}
+
+// OnChange registers a listener that is called after every modification to stored metadata.
+// Listeners are called in the order changes are made, while the store is locked, so they must return quickly
+// and must not call back into the store.
+func (s *Storage) OnChange(listener func(Change)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// notify advances the generation of the store and calls every change listener. It must be called while holding
+// the write lock.
+func (s *Storage) notify(changeType ChangeType, md *Metadata) {
+	s.advance()
+	for _, listener := range s.listeners {
+		listener(Change{Type: changeType, Metadata: md.clone()})
+	}
+}
+
+// Generation returns a number that changes whenever stored metadata, or the way it is searched, changes, along with
+// the time of that change. Responses built from the store can be cached until the generation changes.
+func (s *Storage) Generation() (uint64, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation, s.modified
+}
+
+// advance moves the store to a new generation, and must be called while holding the write lock
+func (s *Storage) advance() {
+	s.generation++
+	s.modified = time.Now().UTC()
+}
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldChange describes the previous and current value of a single metadata attribute
+type FieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MetadataDiff describes the field-level differences between two metadata documents
+type MetadataDiff struct {
+	// Changes holds every changed attribute other than the description, which can be long, and is only reported
+	// as a diff
+	Changes            map[string]FieldChange `json:"changes"`
+	MaintainersAdded   []Maintainer           `json:"maintainers_added"`
+	MaintainersRemoved []Maintainer           `json:"maintainers_removed"`
+	// Description holds a unified text diff of the description, and is empty when the description is unchanged
+	Description string `json:"description,omitempty"`
+}
+
+// Empty reports whether the two compared documents were identical
+func (d *MetadataDiff) Empty() bool {
+	return len(d.Changes) == 0 && len(d.MaintainersAdded) == 0 && len(d.MaintainersRemoved) == 0 && d.Description == ""
+}
+
+// DiffMetadata compares two metadata documents attribute by attribute.
+// Maintainers are compared by name and email, ignoring the order they are listed in.
+func DiffMetadata(from, to *Metadata) *MetadataDiff {
+	diff := &MetadataDiff{
+		Changes:            map[string]FieldChange{},
+		MaintainersAdded:   []Maintainer{},
+		MaintainersRemoved: []Maintainer{},
+	}
+	fields := []struct {
+		attr     attribute
+		from, to string
+	}{
+		{Title, from.Title, to.Title},
+		{Version, from.Version, to.Version},
+		{Company, from.Company, to.Company},
+		{Website, from.Website, to.Website},
+		{Source, from.Source, to.Source},
+		{License, from.License, to.License},
+	}
+	for _, field := range fields {
+		if field.from != field.to {
+			diff.Changes[string(field.attr)] = FieldChange{From: field.from, To: field.to}
+		}
+	}
+	diff.MaintainersAdded = subtractMaintainers(to.Maintainers, from.Maintainers)
+	diff.MaintainersRemoved = subtractMaintainers(from.Maintainers, to.Maintainers)
+	if from.Description != to.Description {
+		diff.Description = UnifiedDiff("description@"+from.Version, "description@"+to.Version, from.Description, to.Description)
+	}
+	return diff
+}
+
+// subtractMaintainers returns the maintainers in a that are not present in b
+func subtractMaintainers(a, b []Maintainer) []Maintainer {
+	exists := map[Maintainer]bool{}
+	for _, maintainer := range b {
+		exists[normalizeMaintainer(maintainer)] = true
+	}
+	difference := []Maintainer{}
+	for _, maintainer := range a {
+		if !exists[normalizeMaintainer(maintainer)] {
+			difference = append(difference, maintainer)
+		}
+	}
+	return difference
+}
+
+func normalizeMaintainer(maintainer Maintainer) Maintainer {
+	return Maintainer{
+		Name:  strings.TrimSpace(maintainer.Name),
+		Email: strings.ToLower(strings.TrimSpace(maintainer.Email)),
+	}
+}
+
+// diffContext is the number of unchanged lines shown around each change in a unified diff
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns a line-based diff of two texts in the unified format used by `diff -u`.
+// An empty string is returned when the texts are identical.
+func UnifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+	ops := diffLines(splitLines(from), splitLines(to))
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+	for start := 0; start < len(ops); {
+		// find the next change, and skip ahead if it is beyond the context of the previous hunk
+		change := start
+		for change < len(ops) && ops[change].kind == ' ' {
+			change++
+		}
+		if change == len(ops) {
+			break
+		}
+		hunkStart := change - diffContext
+		if hunkStart < start {
+			hunkStart = start
+		}
+		// extend the hunk until there are more than 2*diffContext unchanged lines in a row
+		hunkEnd := change
+		for unchanged := 0; hunkEnd < len(ops) && unchanged <= 2*diffContext; hunkEnd++ {
+			if ops[hunkEnd].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for hunkEnd > change && ops[hunkEnd-1].kind == ' ' {
+			hunkEnd--
+		}
+		hunkEnd += diffContext
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+		writeHunk(&b, ops, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+	return b.String()
+}
+
+func writeHunk(b *strings.Builder, ops []diffOp, start, end int) {
+	fromLine, toLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			fromLine++
+		}
+		if op.kind != '-' {
+			toLine++
+		}
+	}
+	fromCount, toCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			fromCount++
+		}
+		if op.kind != '-' {
+			toCount++
+		}
+	}
+	// by convention, an empty range starts at the line before it
+	if fromCount == 0 {
+		fromLine--
+	}
+	if toCount == 0 {
+		toLine--
+	}
+	fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+	for _, op := range ops[start:end] {
+		b.WriteByte(op.kind)
+		b.WriteString(op.line)
+		b.WriteByte('\n')
+	}
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// maxDiffEdits bounds the number of edits searched for when splitting two sets of lines. Lines that would need more
+// edits to match up are reported as entirely removed and added, rather than spending quadratic time on them.
+const maxDiffEdits = 1000
+
+// diffLines computes the shortest edit script between two sets of lines with Myers' algorithm, in linear space.
+// See "An O(ND) Difference Algorithm and Its Variations", Eugene W. Myers, 1986.
+func diffLines(a, b []string) []diffOp {
+	return appendDiff(make([]diffOp, 0, len(a)+len(b)), a, b)
+}
+
+// appendDiff appends the edit script between a and b to ops. Common leading and trailing lines are matched up
+// directly, and the rest is split where the shortest edit script crosses its middle, and diffed in halves.
+func appendDiff(ops []diffOp, a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		ops = append(ops, diffOp{' ', a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	x, y, ok := bisect(a, b)
+	if ok {
+		ops = appendDiff(ops, a[:x], b[:y])
+		ops = appendDiff(ops, a[x:], b[y:])
+	} else {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+	}
+	for _, line := range common {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// bisect finds the middle of the shortest edit script between a and b, which start and end with different lines,
+// by searching for it forward from the start and backward from the end at once. The script is split after line x of
+// a and line y of b. ok is false if either is empty, or more than maxDiffEdits edits would be needed to find the
+// middle.
+func bisect(a, b []string) (x, y int, ok bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+	maxD := (n + m + 1) / 2
+	if maxD > maxDiffEdits {
+		maxD = maxDiffEdits
+	}
+	// forward[offset+k] and backward[offset+k] are the furthest lines of a reached on diagonal k, counting from the
+	// start and from the end respectively
+	offset := maxD + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	// with an odd delta the paths meet while searching forward, and otherwise while searching backward
+	odd := delta%2 != 0
+	// the diagonals that run past the end of a or b are trimmed from the search
+	kStart, kEnd, rStart, rEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		for k := -d + kStart; k <= d-kEnd; k += 2 {
+			var x1 int
+			if k == -d || k != d && forward[offset+k-1] < forward[offset+k+1] {
+				x1 = forward[offset+k+1]
+			} else {
+				x1 = forward[offset+k-1] + 1
+			}
+			y1 := x1 - k
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			forward[offset+k] = x1
+			switch {
+			case x1 > n:
+				kEnd += 2
+			case y1 > m:
+				kStart += 2
+			case odd:
+				r := offset + delta - k
+				if r >= 0 && r < len(backward) && backward[r] != -1 && x1 >= n-backward[r] {
+					return x1, y1, true
+				}
+			}
+		}
+		for k := -d + rStart; k <= d-rEnd; k += 2 {
+			var x2 int
+			if k == -d || k != d && backward[offset+k-1] < backward[offset+k+1] {
+				x2 = backward[offset+k+1]
+			} else {
+				x2 = backward[offset+k-1] + 1
+			}
+			y2 := x2 - k
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			backward[offset+k] = x2
+			switch {
+			case x2 > n:
+				rEnd += 2
+			case y2 > m:
+				rStart += 2
+			case !odd:
+				f := offset + delta - k
+				if f >= 0 && f < len(forward) && forward[f] != -1 {
+					x1 := forward[f]
+					y1 := x1 - (f - offset)
+					if x1 >= n-x2 {
+						return x1, y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
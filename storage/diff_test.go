@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiffMetadata(t *testing.T) {
+	from := &Metadata{
+		Title:   "App title 1",
+		Version: "1.0.0",
+		Maintainers: []Maintainer{
+			{
+				Name:  "Bill Bob",
+				Email: "bill@gmail.com",
+			},
+			{
+				Name:  "Medhir Bhargava",
+				Email: "email@gmail.com",
+			},
+		},
+		Company:     "BigCorp",
+		Website:     "https://www.wikipedia.com",
+		Source:      "https://github.com",
+		License:     "MIT",
+		Description: "# A main heading\nA paragraph\n",
+	}
+	to := &Metadata{
+		Title:   "App title 1",
+		Version: "1.1.0",
+		Maintainers: []Maintainer{
+			{
+				Name:  "Medhir Bhargava",
+				Email: "EMAIL@gmail.com",
+			},
+			{
+				Name:  "Billy Bob",
+				Email: "billy@gmail.com",
+			},
+		},
+		Company:     "BigCorp",
+		Website:     "https://www.wikipedia.com",
+		Source:      "https://github.com",
+		License:     "Apache-2.0",
+		Description: "# A main heading\nAnother paragraph\n",
+	}
+
+	t.Run("Reports changed attributes only, leaving the description to its diff", func(t *testing.T) {
+		diff := DiffMetadata(from, to)
+		assert.Equal(t, map[string]FieldChange{
+			"version": {From: "1.0.0", To: "1.1.0"},
+			"license": {From: "MIT", To: "Apache-2.0"},
+		}, diff.Changes)
+		assert.False(t, diff.Empty())
+	})
+
+	t.Run("Reports maintainers added and removed regardless of order or email case", func(t *testing.T) {
+		diff := DiffMetadata(from, to)
+		assert.Equal(t, []Maintainer{{Name: "Billy Bob", Email: "billy@gmail.com"}}, diff.MaintainersAdded)
+		assert.Equal(t, []Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}}, diff.MaintainersRemoved)
+	})
+
+	t.Run("Includes a unified diff of the description", func(t *testing.T) {
+		diff := DiffMetadata(from, to)
+		assert.Equal(t, "--- description@1.0.0\n+++ description@1.1.0\n@@ -1,2 +1,2 @@\n # A main heading\n-A paragraph\n+Another paragraph\n", diff.Description)
+	})
+
+	t.Run("Identical documents produce an empty diff", func(t *testing.T) {
+		diff := DiffMetadata(from, from)
+		assert.True(t, diff.Empty())
+		assert.Empty(t, diff.Description)
+	})
+}
+
+func Test_UnifiedDiff(t *testing.T) {
+	t.Run("Separates distant changes into hunks with context", func(t *testing.T) {
+		from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+		to := "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n"
+		expected := "--- a\n+++ b\n" +
+			"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+			"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n"
+		assert.Equal(t, expected, UnifiedDiff("a", "b", from, to))
+	})
+
+	t.Run("Merges nearby changes into a single hunk", func(t *testing.T) {
+		from := "1\n2\n3\n4\n5\n"
+		to := "1\n3\n4\n5\nsix\n"
+		expected := "--- a\n+++ b\n@@ -1,5 +1,5 @@\n 1\n-2\n 3\n 4\n 5\n+six\n"
+		assert.Equal(t, expected, UnifiedDiff("a", "b", from, to))
+	})
+
+	t.Run("Handles additions to empty text", func(t *testing.T) {
+		assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+new\n", UnifiedDiff("a", "b", "", "new"))
+	})
+}
+
+func Test_diffLines(t *testing.T) {
+	// apply checks that an edit script turns a into b
+	apply := func(t *testing.T, ops []diffOp, a, b []string) {
+		from, to := []string{}, []string{}
+		for _, op := range ops {
+			if op.kind != '+' {
+				from = append(from, op.line)
+			}
+			if op.kind != '-' {
+				to = append(to, op.line)
+			}
+		}
+		assert.Equal(t, a, from)
+		assert.Equal(t, b, to)
+	}
+	// lcs returns the length of the longest common subsequence of a and b
+	lcs := func(a, b []string) int {
+		lengths := make([][]int, len(a)+1)
+		for i := range lengths {
+			lengths[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lengths[i][j] = lengths[i+1][j+1] + 1
+				} else if lengths[i+1][j] > lengths[i][j+1] {
+					lengths[i][j] = lengths[i+1][j]
+				} else {
+					lengths[i][j] = lengths[i][j+1]
+				}
+			}
+		}
+		return lengths[0][0]
+	}
+	random := rand.New(rand.NewSource(1))
+	lines := func(n int) []string {
+		text := make([]string, n)
+		for i := range text {
+			text[i] = string(rune('a' + random.Intn(4)))
+		}
+		return text
+	}
+
+	t.Run("Finds the shortest edit script", func(t *testing.T) {
+		for i := 0; i < 200; i++ {
+			a, b := lines(random.Intn(30)), lines(random.Intn(30))
+			ops := diffLines(a, b)
+			apply(t, ops, a, b)
+			unchanged := 0
+			for _, op := range ops {
+				if op.kind == ' ' {
+					unchanged++
+				}
+			}
+			assert.Equal(t, lcs(a, b), unchanged, "%q %q", a, b)
+		}
+	})
+
+	t.Run("Diffs long texts without comparing every pair of lines", func(t *testing.T) {
+		a, b := make([]string, 50000), make([]string, 50000)
+		for i := range a {
+			a[i], b[i] = fmt.Sprintf("from %d", i), fmt.Sprintf("to %d", i)
+		}
+		b[25000] = a[25000]
+		apply(t, diffLines(a, b), a, b)
+	})
+}
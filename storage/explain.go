@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Explanation describes how LookupMetadata finds the results of a search, step by step
+type Explanation struct {
+	Analyzer Analyzer `json:"analyzer"`
+	// Attributes explains how the value of each queried attribute was matched, in alphabetical order of attribute
+	Attributes []AttributeExplanation `json:"attributes"`
+	// Steps narrows the metadata matched by each attribute down to the metadata matching all of them
+	Steps []ExplainStep `json:"steps"`
+	// Results are the IDs of the metadata the search returns
+	Results []string `json:"results"`
+	// Yanked are the IDs of matching metadata left out of the results because it is yanked
+	Yanked []string `json:"yanked"`
+	// Notes point out why parts of the search match nothing
+	Notes []string `json:"notes"`
+}
+
+// AttributeExplanation describes how the value queried for an attribute was matched
+type AttributeExplanation struct {
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+	// Analyzed is false for attributes such as version, whose value is matched exactly
+	Analyzed bool `json:"analyzed"`
+	// Words are the words of the value, and the terms the analyzer turned them into
+	Words []AnalyzedWord `json:"words"`
+	// Steps narrows the metadata referenced by each term down to the metadata referenced by all of them
+	Steps []ExplainStep `json:"steps"`
+	// Matches are the IDs of the metadata matching the attribute
+	Matches []string `json:"matches"`
+}
+
+// AnalyzedWord is a word of a query, and the term it is searched by
+type AnalyzedWord struct {
+	Word string `json:"word"`
+	// Term is empty for stop words, which are not searched
+	Term     string `json:"term"`
+	StopWord bool   `json:"stop_word"`
+}
+
+// ExplainStep is the metadata matching a term, or an attribute, and the metadata left once it is intersected with
+// the matches of the previous steps
+type ExplainStep struct {
+	Term string `json:"term"`
+	// Postings counts the metadata matching the term alone
+	Postings int      `json:"postings"`
+	Matches  []string `json:"matches"`
+}
+
+// ExplainLookup describes how LookupMetadata would search for metadata with the same arguments: the terms each
+// queried value is analyzed into, the metadata each term references, and how the matches are intersected
+func (s *Storage) ExplainLookup(attrsAndValues map[string]string, includeYanked bool) (*Explanation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	explanation := &Explanation{
+		Analyzer:   s.index.analyzer,
+		Attributes: []AttributeExplanation{},
+		Steps:      []ExplainStep{},
+		Results:    []string{},
+		Yanked:     []string{},
+		Notes:      []string{},
+	}
+	attrs := []string{}
+	for attr := range attrsAndValues {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	if len(attrs) == 0 {
+		explanation.Notes = append(explanation.Notes, "no attributes were queried, so no metadata matches")
+		return explanation, nil
+	}
+
+	var matches []*Metadata
+	for i, attr := range attrs {
+		attrExplanation, attrMatches, err := s.explainAttribute(attr, attrsAndValues[attr], explanation)
+		if err != nil {
+			return nil, err
+		}
+		explanation.Attributes = append(explanation.Attributes, attrExplanation)
+		if i == 0 {
+			matches = attrMatches
+		} else {
+			matches = intersection(matches, attrMatches)
+		}
+		explanation.Steps = append(explanation.Steps, ExplainStep{Term: attr, Postings: len(attrMatches), Matches: metadataIDs(matches)})
+	}
+	if len(matches) == 0 && len(attrs) > 1 {
+		empty := false
+		for _, attrExplanation := range explanation.Attributes {
+			empty = empty || len(attrExplanation.Matches) == 0
+		}
+		if !empty {
+			explanation.Notes = append(explanation.Notes, "every attribute matches some metadata, but no metadata matches all of them")
+		}
+	}
+
+	for _, md := range matches {
+		if md.State == Yanked && !includeYanked {
+			explanation.Yanked = append(explanation.Yanked, md.ID)
+			continue
+		}
+		explanation.Results = append(explanation.Results, md.ID)
+	}
+	if len(explanation.Yanked) > 0 {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf("%d matching metadata documents are yanked, and are left out unless include_yanked is true", len(explanation.Yanked)))
+	}
+	return explanation, nil
+}
+
+// explainAttribute matches the value of an attribute the way retrieveDocuments does, adding notes about terms that
+// match nothing to the explanation
+func (s *Storage) explainAttribute(attr, value string, explanation *Explanation) (AttributeExplanation, []*Metadata, error) {
+	field, ok := s.index.fieldsByAttribute()[attr]
+	if !ok {
+		return AttributeExplanation{}, nil, errors.New("cannot retrieve documents by unknown attribute type")
+	}
+	attrExplanation := AttributeExplanation{Attribute: attr, Value: value, Analyzed: attribute(attr) != Version, Steps: []ExplainStep{}}
+	if attrExplanation.Analyzed {
+		words, err := s.index.analyzer.explain(value)
+		if err != nil {
+			return AttributeExplanation{}, nil, err
+		}
+		attrExplanation.Words = words
+	} else {
+		// version numbers are not tokenized
+		attrExplanation.Words = []AnalyzedWord{{Word: value, Term: value}}
+	}
+
+	var matches []*Metadata
+	searched := map[string]bool{}
+	for _, word := range attrExplanation.Words {
+		if word.StopWord || searched[word.Term] {
+			continue
+		}
+		postings := field[word.Term]
+		if len(searched) == 0 {
+			matches = postings
+		} else {
+			matches = intersection(matches, postings)
+		}
+		searched[word.Term] = true
+		attrExplanation.Steps = append(attrExplanation.Steps, ExplainStep{Term: word.Term, Postings: len(postings), Matches: metadataIDs(matches)})
+		if len(postings) == 0 {
+			explanation.Notes = append(explanation.Notes, fmt.Sprintf("no metadata has the term %q in its %s", word.Term, attr))
+		}
+	}
+	if len(searched) == 0 {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf("%s=%q has no terms once stop words are removed, so it matches no metadata", attr, value))
+	}
+	attrExplanation.Matches = metadataIDs(matches)
+	return attrExplanation, matches, nil
+}
+
+// explain splits text into words the way process does, along with the term each word is indexed and searched by
+func (a Analyzer) explain(text string) ([]AnalyzedWord, error) {
+	words := []AnalyzedWord{}
+	for _, word := range tokenize(text) {
+		analyzed := AnalyzedWord{Word: word, Term: toLowercase([]string{word})[0]}
+		if a.StopWords && commonWords[analyzed.Term] {
+			analyzed.Term = ""
+			analyzed.StopWord = true
+		} else if a.Stemming {
+			stemmed, err := stem([]string{analyzed.Term}, a.Language)
+			if err != nil {
+				return nil, err
+			}
+			analyzed.Term = stemmed[0]
+		}
+		words = append(words, analyzed)
+	}
+	return words, nil
+}
+
+func metadataIDs(documents []*Metadata) []string {
+	ids := []string{}
+	for _, md := range documents {
+		ids = append(ids, md.ID)
+	}
+	return ids
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExplainLookup(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	jumping := &Metadata{Title: "The jumping app", Version: "1.0.0", License: "MIT"}
+	running := &Metadata{Title: "The running app", Version: "1.0.0", License: "Apache-2.0"}
+	yanked := &Metadata{Title: "The jumping app", Version: "0.9.0", License: "MIT"}
+	for _, md := range []*Metadata{jumping, running, yanked} {
+		assert.NoError(t, s.AddMetadata(ctx, md))
+	}
+	_, err := s.YankMetadata(yanked.ID, "broken")
+	assert.NoError(t, err)
+
+	t.Run("Each word is analyzed into a term, and the matches of every term and attribute are intersected", func(t *testing.T) {
+		explanation, err := s.ExplainLookup(map[string]string{"title": "the Jumped app", "license": "MIT"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, DefaultAnalyzer, explanation.Analyzer)
+		if assert.Len(t, explanation.Attributes, 2) {
+			license, title := explanation.Attributes[0], explanation.Attributes[1]
+			assert.Equal(t, "license", license.Attribute)
+			assert.Equal(t, []AnalyzedWord{{Word: "the", StopWord: true}, {Word: "Jumped", Term: "jump"}, {Word: "app", Term: "app"}}, title.Words)
+			assert.Equal(t, []ExplainStep{
+				{Term: "jump", Postings: 2, Matches: []string{jumping.ID, yanked.ID}},
+				{Term: "app", Postings: 3, Matches: []string{jumping.ID, yanked.ID}},
+			}, title.Steps)
+		}
+		assert.Equal(t, []ExplainStep{
+			{Term: "license", Postings: 2, Matches: []string{jumping.ID, yanked.ID}},
+			{Term: "title", Postings: 2, Matches: []string{jumping.ID, yanked.ID}},
+		}, explanation.Steps)
+		assert.Equal(t, []string{jumping.ID}, explanation.Results)
+		assert.Equal(t, []string{yanked.ID}, explanation.Yanked)
+		assert.Equal(t, []string{"1 matching metadata documents are yanked, and are left out unless include_yanked is true"}, explanation.Notes)
+	})
+
+	t.Run("Explanations have the results of LookupMetadata", func(t *testing.T) {
+		queries := []map[string]string{
+			{"title": "app"},
+			{"title": "the"},
+			{"title": "running", "license": "MIT"},
+			{"version": "1.0.0", "title": "app"},
+			{"description": "jumping"},
+			{},
+		}
+		for _, query := range queries {
+			for _, includeYanked := range []bool{false, true} {
+				explanation, err := s.ExplainLookup(query, includeYanked)
+				assert.NoError(t, err)
+				results, err := s.LookupMetadata(ctx, query, includeYanked)
+				assert.NoError(t, err)
+				ids := metadataIDs(results)
+				sort.Strings(ids)
+				sort.Strings(explanation.Results)
+				assert.Equal(t, ids, explanation.Results, "%v", query)
+			}
+		}
+	})
+
+	t.Run("Notes explain why nothing matches", func(t *testing.T) {
+		tests := []struct {
+			query map[string]string
+			notes []string
+		}{
+			{query: map[string]string{"title": "The"}, notes: []string{`title="The" has no terms once stop words are removed, so it matches no metadata`}},
+			{query: map[string]string{"title": "flying app"}, notes: []string{`no metadata has the term "fli" in its title`}},
+			{query: map[string]string{"title": "running", "license": "MIT"}, notes: []string{"every attribute matches some metadata, but no metadata matches all of them"}},
+			{query: map[string]string{}, notes: []string{"no attributes were queried, so no metadata matches"}},
+		}
+		for _, tt := range tests {
+			explanation, err := s.ExplainLookup(tt.query, true)
+			assert.NoError(t, err)
+			assert.Empty(t, explanation.Results)
+			assert.Equal(t, tt.notes, explanation.Notes)
+		}
+	})
+
+	t.Run("Explaining fails for an unknown attribute", func(t *testing.T) {
+		_, err := s.ExplainLookup(map[string]string{"colour": "blue"}, false)
+		assert.EqualError(t, err, "cannot retrieve documents by unknown attribute type")
+	})
+}
@@ -24,6 +24,52 @@ const (
 	Description     = attribute("description")
 )
 
+// Attributes lists every attribute metadata can be searched by
+var Attributes = []string{
+	string(Title),
+	string(Version),
+	string(MaintainerName),
+	string(MaintainerEmail),
+	string(Company),
+	string(Website),
+	string(Source),
+	string(License),
+	string(Description),
+}
+
+// AttributeValues returns the values of a searchable attribute of the metadata, and whether the attribute exists.
+// Maintainer attributes have one value per maintainer.
+func (md *Metadata) AttributeValues(attr string) ([]string, bool) {
+	switch attribute(attr) {
+	case Title:
+		return []string{md.Title}, true
+	case Version:
+		return []string{md.Version}, true
+	case MaintainerName, MaintainerEmail:
+		values := []string{}
+		for _, maintainer := range md.Maintainers {
+			if attribute(attr) == MaintainerName {
+				values = append(values, maintainer.Name)
+			} else {
+				values = append(values, maintainer.Email)
+			}
+		}
+		return values, true
+	case Company:
+		return []string{md.Company}, true
+	case Website:
+		return []string{md.Website}, true
+	case Source:
+		return []string{md.Source}, true
+	case License:
+		return []string{md.License}, true
+	case Description:
+		return []string{md.Description}, true
+	default:
+		return nil, false
+	}
+}
+
 type index struct {
 	title           map[string][]*Metadata
 	version         map[string][]*Metadata
@@ -34,32 +80,160 @@ type index struct {
 	source          map[string][]*Metadata
 	license         map[string][]*Metadata
 	description     map[string][]*Metadata
+	analyzer        Analyzer
+}
+
+// newIndex creates an empty index, in which text is split into terms by an analyzer
+func newIndex(analyzer Analyzer) index {
+	return index{
+		title:           map[string][]*Metadata{},
+		version:         map[string][]*Metadata{},
+		maintainerName:  map[string][]*Metadata{},
+		maintainerEmail: map[string][]*Metadata{},
+		company:         map[string][]*Metadata{},
+		website:         map[string][]*Metadata{},
+		source:          map[string][]*Metadata{},
+		license:         map[string][]*Metadata{},
+		description:     map[string][]*Metadata{},
+		analyzer:        analyzer,
+	}
+}
+
+// add indexes metadata by the values of every attribute.
+// If indexing fails, any references already added for the metadata are removed.
+func (idx *index) add(metadata *Metadata) error {
+	err := idx.addFields(metadata)
+	if err != nil {
+		idx.remove(metadata)
+		return err
+	}
+	return nil
 }
 
-func indexField(text string, field map[string][]*Metadata, metadata *Metadata, tokenize bool) error {
-	if tokenize {
-		tokens, err := processText(text)
+func (idx *index) addFields(metadata *Metadata) error {
+	for _, value := range idx.values(metadata) {
+		err := idx.indexField(value.text, value.field, metadata, value.tokenize)
 		if err != nil {
 			return err
 		}
-		for _, token := range tokens {
-			_, ok := field[token]
-			if !ok {
-				field[token] = []*Metadata{}
+	}
+	return nil
+}
+
+// remove deletes every reference to metadata from the index. Only the postings of the terms in the metadata are
+// visited, by analyzing its text again.
+func (idx *index) remove(metadata *Metadata) {
+	for _, value := range idx.values(metadata) {
+		terms, err := idx.terms(value.text, value.tokenize)
+		if err != nil {
+			// the terms the metadata was indexed by are unknown, so every posting of the field is searched instead
+			terms = make([]string, 0, len(value.field))
+			for term := range value.field {
+				terms = append(terms, term)
 			}
-			// the particular token being assessed has already been indexed for this document
-			// so do not add a duplicate reference to the document
-			if len(field[token]) > 0 && field[token][len(field[token])-1] == metadata {
+		}
+		for _, term := range terms {
+			documents, ok := value.field[term]
+			if !ok {
 				continue
 			}
-			field[token] = append(field[token], metadata)
+			remaining := documents[:0]
+			for _, md := range documents {
+				if md != metadata {
+					remaining = append(remaining, md)
+				}
+			}
+			if len(remaining) == 0 {
+				delete(value.field, term)
+			} else {
+				value.field[term] = remaining
+			}
 		}
-	} else {
-		_, ok := field[text]
+	}
+}
+
+// fieldValue is text of a document, and the field of the index it is indexed in
+type fieldValue struct {
+	text     string
+	field    map[string][]*Metadata
+	tokenize bool
+}
+
+// values returns every text of metadata that is indexed, with the field it is indexed in. Versions are indexed
+// as they are, rather than split into terms.
+func (idx *index) values(metadata *Metadata) []fieldValue {
+	values := []fieldValue{
+		{text: metadata.Title, field: idx.title, tokenize: true},
+		{text: metadata.Version, field: idx.version},
+	}
+	for _, maintainer := range metadata.Maintainers {
+		values = append(values,
+			fieldValue{text: maintainer.Name, field: idx.maintainerName, tokenize: true},
+			fieldValue{text: maintainer.Email, field: idx.maintainerEmail, tokenize: true},
+		)
+	}
+	return append(values,
+		fieldValue{text: metadata.Company, field: idx.company, tokenize: true},
+		fieldValue{text: metadata.Website, field: idx.website, tokenize: true},
+		fieldValue{text: metadata.Source, field: idx.source, tokenize: true},
+		fieldValue{text: metadata.License, field: idx.license, tokenize: true},
+		fieldValue{text: metadata.Description, field: idx.description, tokenize: true},
+	)
+}
+
+// terms returns the terms text is indexed by
+func (idx *index) terms(text string, tokenize bool) ([]string, error) {
+	if !tokenize {
+		return []string{text}, nil
+	}
+	return idx.analyzer.process(text)
+}
+
+// fieldsByAttribute returns the index of every field, keyed by the attribute it is searched by
+func (idx *index) fieldsByAttribute() map[string]map[string][]*Metadata {
+	return map[string]map[string][]*Metadata{
+		string(Title):           idx.title,
+		string(Version):         idx.version,
+		string(MaintainerName):  idx.maintainerName,
+		string(MaintainerEmail): idx.maintainerEmail,
+		string(Company):         idx.company,
+		string(Website):         idx.website,
+		string(Source):          idx.source,
+		string(License):         idx.license,
+		string(Description):     idx.description,
+	}
+}
+
+func (idx *index) fields() []map[string][]*Metadata {
+	return []map[string][]*Metadata{
+		idx.title,
+		idx.version,
+		idx.maintainerName,
+		idx.maintainerEmail,
+		idx.company,
+		idx.website,
+		idx.source,
+		idx.license,
+		idx.description,
+	}
+}
+
+func (idx *index) indexField(text string, field map[string][]*Metadata, metadata *Metadata, tokenize bool) error {
+	terms, err := idx.terms(text, tokenize)
+	if err != nil {
+		return err
+	}
+	for _, term := range terms {
+		_, ok := field[term]
 		if !ok {
-			field[text] = []*Metadata{}
+			field[term] = []*Metadata{}
 		}
-		field[text] = append(field[text], metadata)
+		// the particular token being assessed has already been indexed for this document
+		// so do not add a duplicate reference to the document
+		if tokenize && len(field[term]) > 0 && field[term][len(field[term])-1] == metadata {
+			continue
+		}
+		field[term] = append(field[term], metadata)
 	}
 	return nil
 }
@@ -99,11 +273,41 @@ func intersection(slice1 []*Metadata, slice2 []*Metadata) []*Metadata {
 	return intersection
 }
 
-func processText(text string) ([]string, error) {
+// Analyzer controls how text is split into the terms that are indexed and searched
+type Analyzer struct {
+	// Language is the language words are stemmed in, one of english, spanish, french, russian, swedish or norwegian
+	Language string `json:"language"`
+	// Stemming reduces words to their stem, so that searching for "jumping" finds "jumped"
+	Stemming bool `json:"stemming"`
+	// StopWords leaves the most common English words out of indexes and queries
+	StopWords bool `json:"stop_words"`
+}
+
+// DefaultAnalyzer stems English words, and leaves out common words
+var DefaultAnalyzer = Analyzer{Language: "english", Stemming: true, StopWords: true}
+
+// Validate ensures that words can be stemmed in the analyzer's language
+func (a Analyzer) Validate() error {
+	if !a.Stemming {
+		return nil
+	}
+	_, err := snowball.Stem("validate", a.Language, true)
+	if err != nil {
+		return fmt.Errorf("cannot stem words in %q, must be one of english, spanish, french, russian, swedish or norwegian", a.Language)
+	}
+	return nil
+}
+
+func (a Analyzer) process(text string) ([]string, error) {
 	tokens := tokenize(text)
 	tokens = toLowercase(tokens)
-	tokens = removeCommonWords(tokens)
-	tokens, err := stem(tokens)
+	if a.StopWords {
+		tokens = removeCommonWords(tokens)
+	}
+	if !a.Stemming {
+		return tokens, nil
+	}
+	tokens, err := stem(tokens, a.Language)
 	if err != nil {
 		return nil, err
 	}
@@ -124,25 +328,26 @@ func toLowercase(tokens []string) []string {
 	return lowercaseTokens
 }
 
+// commonWords are the top 15 words (OEC rank), left out of indexes and queries by analyzers with StopWords set
+var commonWords = map[string]bool{
+	"the":  true,
+	"be":   true,
+	"to":   true,
+	"of":   true,
+	"and":  true,
+	"a":    true,
+	"in":   true,
+	"that": true,
+	"have": true,
+	"I":    true,
+	"it":   true,
+	"for":  true,
+	"not":  true,
+	"on":   true,
+	"with": true,
+}
+
 func removeCommonWords(tokens []string) []string {
-	// Top 15 words (OEC rank)
-	commonWords := map[string]bool{
-		"the":  true,
-		"be":   true,
-		"to":   true,
-		"of":   true,
-		"and":  true,
-		"a":    true,
-		"in":   true,
-		"that": true,
-		"have": true,
-		"I":    true,
-		"it":   true,
-		"for":  true,
-		"not":  true,
-		"on":   true,
-		"with": true,
-	}
 	tokensWithoutCommonWords := []string{}
 	for _, token := range tokens {
 		if _, ok := commonWords[token]; !ok {
@@ -152,10 +357,10 @@ func removeCommonWords(tokens []string) []string {
 	return tokensWithoutCommonWords
 }
 
-func stem(tokens []string) ([]string, error) {
+func stem(tokens []string, language string) ([]string, error) {
 	stemmedTokens := []string{}
 	for _, token := range tokens {
-		stemmedToken, err := snowball.Stem(token, "english", true)
+		stemmedToken, err := snowball.Stem(token, language, true)
 		if err != nil {
 			return nil, fmt.Errorf("unable to stem token: %s", err.Error())
 		}
@@ -23,7 +23,7 @@ func Test_processText(t *testing.T) {
 		assert.Equal(t, tokens, []string{"quick", "brown", "fox", "jumped", "things", "rocks", "emailed", "letter", "at", "mail", "gmail", "com"})
 	})
 
-	tokens, err := stem(tokens)
+	tokens, err := stem(tokens, "english")
 	t.Run("stems tokens to transform words to their base form", func(t *testing.T) {
 		assert.Equal(t, tokens, []string{"quick", "brown", "fox", "jump", "thing", "rock", "email", "letter", "at", "mail", "gmail", "com"})
 		assert.NoError(t, err)
@@ -31,6 +31,7 @@ func Test_processText(t *testing.T) {
 }
 
 func Test_indexField(t *testing.T) {
+	idx := &index{analyzer: DefaultAnalyzer}
 	descriptionIndex := map[string][]*Metadata{}
 	versionIndex := map[string][]*Metadata{}
 	md := &Metadata{
@@ -54,9 +55,9 @@ func Test_indexField(t *testing.T) {
 	}
 
 	t.Run("indexes using tokens", func(t *testing.T) {
-		err := indexField(md.Description, descriptionIndex, md, true)
+		err := idx.indexField(md.Description, descriptionIndex, md, true)
 		assert.NoError(t, err)
-		tokens, err := processText(md.Description)
+		tokens, err := DefaultAnalyzer.process(md.Description)
 		assert.NoError(t, err)
 		for _, token := range tokens {
 			assert.Equal(t, 1, len(descriptionIndex[token]))
@@ -65,9 +66,47 @@ func Test_indexField(t *testing.T) {
 	})
 
 	t.Run("indexes without using tokens", func(t *testing.T) {
-		err := indexField(md.Version, versionIndex, md, false)
+		err := idx.indexField(md.Version, versionIndex, md, false)
 		assert.NoError(t, err)
 		assert.Equal(t, 1, len(versionIndex[md.Version]))
 		assert.Equal(t, md, versionIndex[md.Version][0])
 	})
 }
+
+func Test_indexRemove(t *testing.T) {
+	idx := newIndex(DefaultAnalyzer)
+	first := &Metadata{
+		Title:       "App title 1",
+		Version:     "1.0.0",
+		Maintainers: []Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}},
+		License:     "MIT",
+		Description: "A quick brown fox",
+	}
+	second := &Metadata{
+		Title:       "App title 2",
+		Version:     "1.0.0",
+		Maintainers: []Maintainer{{Name: "Bill Bob", Email: "bob@gmail.com"}},
+		License:     "Apache-2.0",
+		Description: "A lazy brown dog",
+	}
+	assert.NoError(t, idx.add(first))
+	assert.NoError(t, idx.add(second))
+
+	idx.remove(first)
+	for _, field := range idx.fields() {
+		for term, documents := range field {
+			assert.NotContains(t, documents, first, term)
+			assert.Equal(t, []*Metadata{second}, documents, term)
+		}
+	}
+	assert.Contains(t, idx.title, "2")
+	assert.NotContains(t, idx.title, "1")
+	assert.Contains(t, idx.version, "1.0.0")
+	assert.NotContains(t, idx.license, "mit")
+	assert.NotContains(t, idx.description, "quick")
+
+	idx.remove(second)
+	for _, field := range idx.fields() {
+		assert.Empty(t, field)
+	}
+}
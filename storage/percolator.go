@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxSearchMatches is the number of recent matches kept for each saved search
+const maxSearchMatches = 1000
+
+// SavedSearch is a query that is evaluated against every newly stored document, recording the documents that match
+type SavedSearch struct {
+	ID    string            `json:"id"`
+	Name  string            `json:"name"`
+	Query map[string]string `json:"query"`
+	// Owner is the name of the principal that saved the search, which only it, and admins, can see
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+	// LastMatch is the sequence number of the most recent match, or 0 if nothing has matched yet
+	LastMatch uint64 `json:"last_match"`
+
+	// terms holds the processed tokens of each queried attribute, so they are not re-processed for every document
+	terms   map[string][]string
+	matches []Match
+}
+
+// Match records a document that matched a saved search when it was stored
+type Match struct {
+	// Sequence increases with every match of a saved search, starting at 1
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+	Metadata *Metadata `json:"metadata"`
+}
+
+// SaveSearch registers a query to be evaluated against every document stored from now on, on behalf of an owner.
+// The query uses the same attributes and matching rules as LookupMetadata.
+func (s *Storage) SaveSearch(name string, query map[string]string, owner string) (*SavedSearch, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, errors.New("saved search must have a name")
+	}
+	if len(query) == 0 {
+		return nil, errors.New("saved search must have a query")
+	}
+	for attr := range query {
+		if _, ok := (&Metadata{}).AttributeValues(attr); !ok {
+			return nil, fmt.Errorf("cannot search by unknown attribute %q", attr)
+		}
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	search := &SavedSearch{
+		ID:      id,
+		Name:    name,
+		Query:   map[string]string{},
+		Owner:   owner,
+		Created: time.Now().UTC(),
+		matches: []Match{},
+	}
+	for attr, value := range query {
+		search.Query[attr] = value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// terms are processed while locked, so that they use the same analyzer as the index
+	search.terms, err = queryTerms(s.index.analyzer, query)
+	if err != nil {
+		return nil, err
+	}
+	s.savedSearches[id] = search
+	return search.clone(), nil
+}
+
+// queryTerms processes the values of a query into the terms matched against indexed tokens. Versions are matched
+// exactly, and are not processed.
+func queryTerms(analyzer Analyzer, query map[string]string) (map[string][]string, error) {
+	terms := map[string][]string{}
+	for attr, value := range query {
+		if attribute(attr) == Version {
+			terms[attr] = []string{value}
+			continue
+		}
+		tokens, err := analyzer.process(value)
+		if err != nil {
+			return nil, err
+		}
+		terms[attr] = tokens
+	}
+	return terms, nil
+}
+
+// GetSavedSearch returns the saved search with the given ID
+func (s *Storage) GetSavedSearch(id string) (*SavedSearch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	search, ok := s.savedSearches[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return search.clone(), nil
+}
+
+// SavedSearches returns the saved searches of an owner, or every saved search if owner is empty
+func (s *Storage) SavedSearches(owner string) []*SavedSearch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	searches := []*SavedSearch{}
+	for _, search := range s.savedSearches {
+		if owner == "" || search.Owner == owner {
+			searches = append(searches, search.clone())
+		}
+	}
+	return searches
+}
+
+// DeleteSavedSearch stops evaluating a saved search, and discards its matches
+func (s *Storage) DeleteSavedSearch(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.savedSearches[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.savedSearches, id)
+	return nil
+}
+
+// SearchMatches returns the recent matches of a saved search with a sequence number greater than since, oldest first
+func (s *Storage) SearchMatches(id string, since uint64) ([]Match, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	search, ok := s.savedSearches[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	matches := []Match{}
+	for _, match := range search.matches {
+		if match.Sequence > since {
+			matches = append(matches, Match{Sequence: match.Sequence, Time: match.Time, Metadata: match.Metadata.clone()})
+		}
+	}
+	return matches, nil
+}
+
+func (search *SavedSearch) clone() *SavedSearch {
+	c := &SavedSearch{
+		ID:        search.ID,
+		Name:      search.Name,
+		Query:     map[string]string{},
+		Owner:     search.Owner,
+		Created:   search.Created,
+		LastMatch: search.LastMatch,
+	}
+	for attr, value := range search.Query {
+		c.Query[attr] = value
+	}
+	return c
+}
+
+// percolate evaluates every saved search against a single document, recording a match for each search it satisfies.
+// Rather than running each search against the whole index, the document's own tokens are compared to the
+// pre-processed terms of each search. It must be called while holding the write lock.
+func (s *Storage) percolate(md *Metadata) {
+	if len(s.savedSearches) == 0 {
+		return
+	}
+	// tokens are only processed for attributes that are queried, and at most once per attribute
+	documentTokens := map[string]map[string]bool{}
+	tokensOf := func(attr string) map[string]bool {
+		if tokens, ok := documentTokens[attr]; ok {
+			return tokens
+		}
+		tokens := map[string]bool{}
+		values, _ := md.AttributeValues(attr)
+		for _, value := range values {
+			if attribute(attr) == Version {
+				tokens[value] = true
+				continue
+			}
+			processed, err := s.index.analyzer.process(value)
+			if err != nil {
+				continue
+			}
+			for _, token := range processed {
+				tokens[token] = true
+			}
+		}
+		documentTokens[attr] = tokens
+		return tokens
+	}
+	now := time.Now().UTC()
+	for _, search := range s.savedSearches {
+		if !search.matchesTokens(tokensOf) {
+			continue
+		}
+		search.LastMatch++
+		search.matches = append(search.matches, Match{Sequence: search.LastMatch, Time: now, Metadata: md.clone()})
+		if len(search.matches) > maxSearchMatches {
+			search.matches = search.matches[len(search.matches)-maxSearchMatches:]
+		}
+	}
+}
+
+// matchesTokens reports whether a document contains every term of every queried attribute, which is the same
+// condition LookupMetadata uses to intersect its results
+func (search *SavedSearch) matchesTokens(tokensOf func(attr string) map[string]bool) bool {
+	for attr, terms := range search.terms {
+		if len(terms) == 0 {
+			// a query made up of common words matches nothing, as it does in LookupMetadata
+			return false
+		}
+		tokens := tokensOf(attr)
+		for _, term := range terms {
+			if !tokens[term] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SavedSearches(t *testing.T) {
+	s := NewStorage()
+	agpl, err := s.SaveSearch("AGPL apps", map[string]string{"license": "AGPL-3.0"}, "")
+	assert.NoError(t, err)
+	bigCorp, err := s.SaveSearch("BigCorp 1.0.0", map[string]string{"company": "bigcorp", "version": "1.0.0", "maintainer_email": "email@gmail.com"}, "")
+	assert.NoError(t, err)
+	common, err := s.SaveSearch("Common words", map[string]string{"title": "the"}, "")
+	assert.NoError(t, err)
+
+	documents := []*Metadata{
+		{
+			Title:   "App title 1",
+			Version: "1.0.0",
+			Maintainers: []Maintainer{
+				{Name: "Bill Bob", Email: "bill@gmail.com"},
+				{Name: "Medhir Bhargava", Email: "email@gmail.com"},
+			},
+			Company: "BigCorp",
+			License: "AGPL-3.0",
+		},
+		{
+			Title:       "App title 2",
+			Version:     "2.0.0",
+			Maintainers: []Maintainer{{Name: "Billy Bob", Email: "billy@gmail.com"}},
+			Company:     "BigCorp",
+			License:     "MIT",
+		},
+		{
+			Title:       "The app",
+			Version:     "1.0.0",
+			Maintainers: []Maintainer{{Name: "Billy Bob", Email: "billy@gmail.com"}},
+			Company:     "SmallCorp",
+			License:     "agpl 3.0",
+		},
+	}
+	for _, document := range documents {
+		err := s.AddMetadata(context.Background(), document)
+		assert.NoError(t, err)
+	}
+
+	t.Run("Documents matching a saved search are recorded in order", func(t *testing.T) {
+		matches, err := s.SearchMatches(agpl.ID, 0)
+		assert.NoError(t, err)
+		if assert.Equal(t, 2, len(matches)) {
+			assert.Equal(t, uint64(1), matches[0].Sequence)
+			assert.Equal(t, documents[0].ID, matches[0].Metadata.ID)
+			assert.Equal(t, uint64(2), matches[1].Sequence)
+			assert.Equal(t, documents[2].ID, matches[1].Metadata.ID)
+		}
+		matches, err = s.SearchMatches(agpl.ID, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(matches))
+		search, err := s.GetSavedSearch(agpl.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), search.LastMatch)
+	})
+
+	t.Run("Saved searches match the same documents as LookupMetadata", func(t *testing.T) {
+		for _, search := range []*SavedSearch{agpl, bigCorp, common} {
+			results, err := s.LookupMetadata(context.Background(), search.Query, false)
+			assert.NoError(t, err)
+			matches, err := s.SearchMatches(search.ID, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, len(results), len(matches), search.Name)
+		}
+	})
+
+	t.Run("Deleted saved searches are no longer evaluated", func(t *testing.T) {
+		assert.NoError(t, s.DeleteSavedSearch(agpl.ID))
+		_, err := s.SearchMatches(agpl.ID, 0)
+		assert.Equal(t, ErrNotFound, err)
+		assert.Equal(t, 2, len(s.SavedSearches("")))
+	})
+
+	t.Run("Invalid saved searches are rejected", func(t *testing.T) {
+		_, err := s.SaveSearch("", map[string]string{"license": "MIT"}, "")
+		assert.EqualError(t, err, "saved search must have a name")
+		_, err = s.SaveSearch("Nothing", map[string]string{}, "")
+		assert.EqualError(t, err, "saved search must have a query")
+		_, err = s.SaveSearch("Colours", map[string]string{"colour": "blue"}, "")
+		assert.EqualError(t, err, `cannot search by unknown attribute "colour"`)
+	})
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReindexResult describes a rebuilt index
+type ReindexResult struct {
+	Analyzer  Analyzer `json:"analyzer"`
+	Documents int      `json:"documents"`
+	// Changed counts the documents stored, updated or deleted while the index was being built, which were applied to
+	// it before it replaced the previous index
+	Changed  int           `json:"changed"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Analyzer returns the analyzer text is currently indexed and searched with
+func (s *Storage) Analyzer() Analyzer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.analyzer
+}
+
+// Reindex rebuilds the index of every stored document with an analyzer, which may differ from the current one, and
+// reprocesses the queries of saved searches with it. The new index is built without blocking searches or writes.
+// Documents changed in the meantime are then applied to it, and it replaces the previous index atomically, so
+// searches use either the previous index or the new one. If the new index cannot be built, the previous one is kept.
+func (s *Storage) Reindex(analyzer Analyzer) (ReindexResult, error) {
+	err := analyzer.Validate()
+	if err != nil {
+		return ReindexResult{}, err
+	}
+	// concurrent rebuilds would each apply the changes made during the other
+	s.reindexMu.Lock()
+	defer s.reindexMu.Unlock()
+	start := time.Now()
+
+	s.mu.RLock()
+	snapshot := append([]*Metadata{}, s.documents...)
+	s.mu.RUnlock()
+	// indexed attributes are never modified in place, since updates store a new document, so the snapshot can be
+	// read without holding the lock
+	idx, err := buildIndex(analyzer, snapshot)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+
+	result, err := s.swapIndex(idx, snapshot)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// swapIndex replaces the index with one built from a snapshot of the stored documents, after applying the documents
+// stored, updated or deleted since the snapshot was taken
+func (s *Storage) swapIndex(idx index, snapshot []*Metadata) (ReindexResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed, err := applyChanges(&idx, snapshot, s.documents)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+	terms := map[*SavedSearch]map[string][]string{}
+	for _, search := range s.savedSearches {
+		searchTerms, err := queryTerms(idx.analyzer, search.Query)
+		if err != nil {
+			return ReindexResult{}, fmt.Errorf("could not process saved search %s: %v", search.ID, err)
+		}
+		terms[search] = searchTerms
+	}
+	s.index = idx
+	// the new analyzer may change the results of searches
+	s.advance()
+	for search, searchTerms := range terms {
+		search.terms = searchTerms
+	}
+	return ReindexResult{Analyzer: idx.analyzer, Documents: len(s.documents), Changed: changed}, nil
+}
+
+// applyChanges brings an index of the documents in from up to date with the documents in to, adding and removing
+// the documents that differ, and returns how many did
+func applyChanges(idx *index, from, to []*Metadata) (int, error) {
+	indexed := map[*Metadata]bool{}
+	for _, md := range from {
+		indexed[md] = true
+	}
+	current := map[*Metadata]bool{}
+	changed := 0
+	for _, md := range to {
+		current[md] = true
+		if indexed[md] {
+			continue
+		}
+		err := idx.add(md)
+		if err != nil {
+			return 0, fmt.Errorf("could not index metadata %s: %v", md.ID, err)
+		}
+		changed++
+	}
+	for _, md := range from {
+		if !current[md] {
+			idx.remove(md)
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// buildIndex creates an index of documents
+func buildIndex(analyzer Analyzer, documents []*Metadata) (index, error) {
+	idx := newIndex(analyzer)
+	for _, md := range documents {
+		err := idx.add(md)
+		if err != nil {
+			return index{}, fmt.Errorf("could not index metadata %s: %v", md.ID, err)
+		}
+	}
+	return idx, nil
+}
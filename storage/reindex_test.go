@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Reindex(t *testing.T) {
+	ctx := context.Background()
+	search := func(s *Storage, title string) []string {
+		results, err := s.LookupMetadata(ctx, map[string]string{"title": title}, false)
+		assert.NoError(t, err)
+		versions := []string{}
+		for _, md := range results {
+			versions = append(versions, md.Version)
+		}
+		return versions
+	}
+
+	t.Run("Reindexing with another analyzer changes how text is matched", func(t *testing.T) {
+		s := NewStorage()
+		assert.NoError(t, s.AddMetadata(ctx, &Metadata{Title: "Jumping app", Version: "1.0.0"}))
+		saved, err := s.SaveSearch("jumped", map[string]string{"title": "jumped"}, "")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"1.0.0"}, search(s, "jumped"))
+
+		literal := Analyzer{Language: "english"}
+		result, err := s.Reindex(literal)
+		assert.NoError(t, err)
+		assert.Equal(t, literal, result.Analyzer)
+		assert.Equal(t, 1, result.Documents)
+		assert.Equal(t, literal, s.Analyzer())
+		assert.Empty(t, search(s, "jumped"))
+		assert.Equal(t, []string{"1.0.0"}, search(s, "jumping"))
+
+		// saved searches are matched with the new analyzer too
+		assert.NoError(t, s.AddMetadata(ctx, &Metadata{Title: "Jumping app", Version: "1.1.0"}))
+		matches, err := s.SearchMatches(saved.ID, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, matches)
+	})
+
+	t.Run("Reindexing fails for an invalid analyzer, keeping the index", func(t *testing.T) {
+		s := NewStorage()
+		assert.NoError(t, s.AddMetadata(ctx, &Metadata{Title: "Jumping app", Version: "1.0.0"}))
+		_, err := s.Reindex(Analyzer{Language: "klingon", Stemming: true})
+		assert.Error(t, err)
+		assert.Equal(t, DefaultAnalyzer, s.Analyzer())
+		assert.Equal(t, []string{"1.0.0"}, search(s, "jumped"))
+	})
+
+	t.Run("Documents changed while the index is built are applied before it is swapped", func(t *testing.T) {
+		s := NewStorage()
+		kept := &Metadata{Title: "Kept app", Version: "1.0.0"}
+		updated := &Metadata{Title: "Updated app", Version: "1.0.0"}
+		deleted := &Metadata{Title: "Deleted app", Version: "1.0.0"}
+		for _, md := range []*Metadata{kept, updated, deleted} {
+			assert.NoError(t, s.AddMetadata(ctx, md))
+		}
+		snapshot := append([]*Metadata{}, s.documents...)
+		idx, err := buildIndex(DefaultAnalyzer, snapshot)
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.AddMetadata(ctx, &Metadata{Title: "Added app", Version: "1.0.0"}))
+		_, err = s.UpdateMetadata(updated.ID, &Metadata{Title: "Renamed app", Version: "1.0.0"})
+		assert.NoError(t, err)
+		assert.NoError(t, s.DeleteMetadata(deleted.ID))
+
+		result, err := s.swapIndex(idx, snapshot)
+		assert.NoError(t, err)
+		assert.Equal(t, ReindexResult{Analyzer: DefaultAnalyzer, Documents: 3, Changed: 4}, result)
+		for title, versions := range map[string][]string{"kept": {"1.0.0"}, "added": {"1.0.0"}, "renamed": {"1.0.0"}, "updated": {}, "deleted": {}} {
+			assert.Equal(t, versions, search(s, title), title)
+		}
+	})
+}
+
+func Test_IndexStats(t *testing.T) {
+	s := NewStorage()
+	for _, md := range []*Metadata{
+		{Title: "Search app", Version: "1.0.0", License: "MIT"},
+		{Title: "Search engine app", Version: "1.1.0", License: "MIT"},
+		{Title: "Other", Version: "1.0.0", License: "Apache-2.0"},
+	} {
+		assert.NoError(t, s.AddMetadata(context.Background(), md))
+	}
+
+	stats := s.IndexStats(2)
+	assert.Equal(t, DefaultAnalyzer, stats.Analyzer)
+	assert.Equal(t, 3, stats.Documents)
+	title := stats.Fields["title"]
+	assert.Equal(t, 4, title.Terms)
+	assert.Equal(t, 6, title.Postings)
+	assert.Equal(t, []TermCount{{Term: "app", Documents: 2}, {Term: "search", Documents: 2}}, title.TopTerms)
+	assert.True(t, title.MemoryBytes > 0)
+	assert.Equal(t, []TermCount{{Term: "1.0.0", Documents: 2}, {Term: "1.1.0", Documents: 1}}, stats.Fields["version"].TopTerms)
+	assert.Empty(t, stats.Fields["description"].TopTerms)
+
+	terms, postings, memory := 0, 0, 0
+	for _, field := range stats.Fields {
+		terms, postings, memory = terms+field.Terms, postings+field.Postings, memory+field.MemoryBytes
+	}
+	assert.Equal(t, terms, stats.Terms)
+	assert.Equal(t, postings, stats.Postings)
+	assert.Equal(t, memory, stats.MemoryBytes)
+}
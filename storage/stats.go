@@ -0,0 +1,101 @@
+package storage
+
+import "sort"
+
+// Stats summarizes the contents of the store
+type Stats struct {
+	// Documents counts stored metadata by lifecycle state
+	Documents map[State]int `json:"documents"`
+	// IndexTerms counts the distinct terms indexed for each attribute
+	IndexTerms map[string]int `json:"index_terms"`
+}
+
+// Stats returns a summary of the stored metadata and its index
+func (s *Storage) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := Stats{
+		Documents:  map[State]int{Active: 0, Deprecated: 0, Yanked: 0},
+		IndexTerms: map[string]int{},
+	}
+	for _, md := range s.documents {
+		stats.Documents[md.State]++
+	}
+	for attr, field := range s.index.fieldsByAttribute() {
+		stats.IndexTerms[attr] = len(field)
+	}
+	return stats
+}
+
+// IndexStats describes the size and contents of the index
+type IndexStats struct {
+	Analyzer  Analyzer `json:"analyzer"`
+	Documents int      `json:"documents"`
+	// Fields describes the index of each attribute, keyed by the attribute
+	Fields      map[string]FieldStats `json:"fields"`
+	Terms       int                   `json:"terms"`
+	Postings    int                   `json:"postings"`
+	MemoryBytes int                   `json:"memory_bytes"`
+}
+
+// FieldStats describes the index of a single attribute
+type FieldStats struct {
+	// Terms counts the distinct terms indexed, and Postings the references from terms to documents
+	Terms    int `json:"terms"`
+	Postings int `json:"postings"`
+	// MemoryBytes roughly estimates the memory used by the field's index, not counting the documents themselves
+	MemoryBytes int `json:"memory_bytes"`
+	// TopTerms are the terms referencing the most documents, most referenced first
+	TopTerms []TermCount `json:"top_terms"`
+}
+
+// TermCount is the number of documents an indexed term references
+type TermCount struct {
+	Term      string `json:"term"`
+	Documents int    `json:"documents"`
+}
+
+// the estimated sizes of the parts of an index field, on a 64 bit platform
+const (
+	// mapEntryBytes is the size of a key's string header and a value's slice header, plus a share of the map buckets
+	mapEntryBytes = 16 + 24 + 8
+	pointerBytes  = 8
+)
+
+// IndexStats describes the index of every attribute, listing up to top of the terms that reference the most
+// documents for each
+func (s *Storage) IndexStats(top int) IndexStats {
+	if top < 0 {
+		top = 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := IndexStats{
+		Analyzer:  s.index.analyzer,
+		Documents: len(s.documents),
+		Fields:    map[string]FieldStats{},
+	}
+	for attr, field := range s.index.fieldsByAttribute() {
+		fieldStats := FieldStats{Terms: len(field), TopTerms: []TermCount{}}
+		for term, documents := range field {
+			fieldStats.Postings += len(documents)
+			fieldStats.MemoryBytes += mapEntryBytes + len(term) + cap(documents)*pointerBytes
+			fieldStats.TopTerms = append(fieldStats.TopTerms, TermCount{Term: term, Documents: len(documents)})
+		}
+		sort.Slice(fieldStats.TopTerms, func(i, j int) bool {
+			a, b := fieldStats.TopTerms[i], fieldStats.TopTerms[j]
+			if a.Documents != b.Documents {
+				return a.Documents > b.Documents
+			}
+			return a.Term < b.Term
+		})
+		if len(fieldStats.TopTerms) > top {
+			fieldStats.TopTerms = fieldStats.TopTerms[:top]
+		}
+		stats.Fields[attr] = fieldStats
+		stats.Terms += fieldStats.Terms
+		stats.Postings += fieldStats.Postings
+		stats.MemoryBytes += fieldStats.MemoryBytes
+	}
+	return stats
+}
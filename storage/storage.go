@@ -1,22 +1,142 @@
 package storage
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net"
 	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/medhir/yaml-api/logging"
 )
 
 // Storage is the controller used to store, index, and lookup YAML documents
 type Storage struct {
-	index index
+	mu        sync.RWMutex
+	index     index
+	documents []*Metadata
+	byID      map[string]*Metadata
+	listeners []func(Change)
+
+	savedSearches map[string]*SavedSearch
+	mxObservers   []func(time.Duration, error)
+	// skipMX stops validation from requiring the domain of maintainer emails to have a mail server
+	skipMX bool
+	// reindexMu serializes rebuilds of the index, which are built without holding mu
+	reindexMu sync.Mutex
+	// generation changes with every write, which last happened at modified
+	generation uint64
+	modified   time.Time
+}
+
+// Option configures optional behavior of a store
+type Option func(*Storage)
+
+// WithAnalyzer sets how text is split into the terms that are indexed and searched
+func WithAnalyzer(analyzer Analyzer) Option {
+	return func(s *Storage) {
+		s.index.analyzer = analyzer
+	}
+}
+
+// WithMXCheck sets whether validation looks up the mail servers of maintainer email domains, which requires DNS
+func WithMXCheck(enabled bool) Option {
+	return func(s *Storage) {
+		s.skipMX = !enabled
+	}
+}
+
+// ErrNotFound is returned when no stored metadata matches a lookup
+var ErrNotFound = errors.New("metadata not found")
+
+// RevisionError is returned when stored metadata is not at the revision a write expected, because it was modified
+// in the meantime
+type RevisionError struct {
+	ID       string
+	Expected []int
+	Current  int
+}
+
+func (e *RevisionError) Error() string {
+	expected := []string{}
+	for _, revision := range e.Expected {
+		expected = append(expected, strconv.Itoa(revision))
+	}
+	return fmt.Sprintf("metadata %s is at revision %d, not %s", e.ID, e.Current, strings.Join(expected, " or "))
+}
+
+// Precondition must hold for stored metadata to be modified. Preconditions are checked while the store is locked, so
+// nothing can modify the metadata between the check and the write.
+type Precondition func(stored *Metadata) error
+
+// IfRevision requires stored metadata to be at one of the given revisions
+func IfRevision(revisions ...int) Precondition {
+	return func(stored *Metadata) error {
+		for _, revision := range revisions {
+			if stored.Revision == revision {
+				return nil
+			}
+		}
+		return &RevisionError{ID: stored.ID, Expected: revisions, Current: stored.Revision}
+	}
+}
+
+// IfOwnersAllow requires allow to accept the owners of the application stored metadata belongs to, and of every
+// other application named by titles, such as one the metadata is being renamed to. Owners are found as Owners finds
+// them, but while the store is locked, so ownership cannot change between the check and the write.
+func (s *Storage) IfOwnersAllow(allow func(owners []Maintainer, exists bool) error, titles ...string) Precondition {
+	return func(stored *Metadata) error {
+		err := allow(s.owners(stored.Title))
+		if err != nil {
+			return err
+		}
+		for _, title := range titles {
+			if strings.EqualFold(strings.TrimSpace(title), strings.TrimSpace(stored.Title)) {
+				continue
+			}
+			err = allow(s.owners(title))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 }
 
-// Metadata describes all the properties of the YAML metadata stored & indexed by the API
+// checkPreconditions returns the error of the first precondition that does not hold for stored metadata
+func checkPreconditions(stored *Metadata, preconditions []Precondition) error {
+	for _, precondition := range preconditions {
+		err := precondition(stored)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Metadata describes all the properties of the YAML metadata stored & indexed by the API.
+// ID, State, Deprecation, YankReason, the timestamps and the revision are managed by the API, and cannot be set from
+// submitted YAML.
 type Metadata struct {
+	ID          string       `yaml:"-" json:"id"`
+	State       State        `yaml:"-" json:"state"`
+	Deprecation *Deprecation `yaml:"-" json:"deprecation,omitempty"`
+	YankReason  string       `yaml:"-" json:"yank_reason,omitempty"`
+	// Created is when the metadata was first stored, and Updated when it was last modified, including changes to its
+	// lifecycle state
+	Created time.Time `yaml:"-" json:"created"`
+	Updated time.Time `yaml:"-" json:"updated"`
+	// Revision starts at 1, and increases with every modification, including changes to the lifecycle state
+	Revision    int          `yaml:"-" json:"revision"`
 	Title       string       `yaml:"title" json:"title"`
 	Version     string       `yaml:"version" json:"version"`
 	Maintainers []Maintainer `yaml:"maintainers" json:"maintainers"`
@@ -33,64 +153,326 @@ type Maintainer struct {
 	Email string `yaml:"email" json:"email"`
 }
 
+// State describes where stored metadata is in its publication lifecycle
+type State string
+
+const (
+	// Active metadata is returned by searches
+	Active = State("active")
+	// Deprecated metadata is still returned by searches, but carries a deprecation notice
+	Deprecated = State("deprecated")
+	// Yanked metadata is hidden from searches by default, but can still be fetched by its ID
+	Yanked = State("yanked")
+)
+
+// Deprecation describes why metadata was deprecated, and which metadata replaces it
+type Deprecation struct {
+	Message string `json:"message"`
+	// Replacement is the ID of the metadata that should be used instead, if any
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// clone returns a copy of the metadata that is safe to read without holding the storage lock
+func (md *Metadata) clone() *Metadata {
+	c := *md
+	if md.Maintainers != nil {
+		c.Maintainers = append([]Maintainer{}, md.Maintainers...)
+	}
+	if md.Deprecation != nil {
+		deprecation := *md.Deprecation
+		c.Deprecation = &deprecation
+	}
+	return &c
+}
+
+func cloneAll(documents []*Metadata) []*Metadata {
+	clones := make([]*Metadata, 0, len(documents))
+	for _, md := range documents {
+		clones = append(clones, md.clone())
+	}
+	return clones
+}
+
+func newID() (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate metadata ID: %s", err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // NewStorage initializes a new metadata store
-func NewStorage() *Storage {
-	return &Storage{
-		index: index{
-			title:           map[string][]*Metadata{},
-			version:         map[string][]*Metadata{},
-			maintainerName:  map[string][]*Metadata{},
-			maintainerEmail: map[string][]*Metadata{},
-			company:         map[string][]*Metadata{},
-			website:         map[string][]*Metadata{},
-			source:          map[string][]*Metadata{},
-			license:         map[string][]*Metadata{},
-			description:     map[string][]*Metadata{},
-		},
+func NewStorage(options ...Option) *Storage {
+	s := &Storage{
+		index:         newIndex(DefaultAnalyzer),
+		byID:          map[string]*Metadata{},
+		savedSearches: map[string]*SavedSearch{},
+		modified:      time.Now().UTC(),
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
 // AddMetadata indexes references a metadata object to the in-memory store by the values of every attribute.
-func (s *Storage) AddMetadata(metadata *Metadata) error {
-	err := indexField(metadata.Title, s.index.title, metadata, true)
+// The metadata is assigned a new ID, and starts out in the Active state. Every saved search is evaluated against it.
+// Failures are logged with the logger carried by ctx.
+func (s *Storage) AddMetadata(ctx context.Context, metadata *Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := newID()
 	if err != nil {
+		logging.FromContext(ctx).Error("could not generate metadata id", "error", err)
 		return err
 	}
-	err = indexField(metadata.Version, s.index.version, metadata, false)
+	metadata.ID = id
+	metadata.State = Active
+	metadata.Deprecation = nil
+	metadata.YankReason = ""
+	metadata.Created = time.Now().UTC()
+	metadata.Updated = metadata.Created
+	metadata.Revision = 1
+	// the store keeps its own copy, so that callers cannot modify indexed metadata
+	stored := metadata.clone()
+	err = s.index.add(stored)
 	if err != nil {
+		logging.FromContext(ctx).Error("could not index metadata", "title", metadata.Title, "version", metadata.Version, "error", err)
 		return err
 	}
-	for _, maintainer := range metadata.Maintainers {
-		err = indexField(maintainer.Name, s.index.maintainerName, metadata, true)
-		if err != nil {
-			return err
-		}
-		err = indexField(maintainer.Email, s.index.maintainerEmail, metadata, true)
-		if err != nil {
-			return err
-		}
+	s.documents = append(s.documents, stored)
+	s.byID[stored.ID] = stored
+	s.percolate(stored)
+	s.notify(ChangeCreate, stored)
+	return nil
+}
+
+// UpdateMetadata replaces the attributes of stored metadata, and re-indexes it, if every precondition holds.
+// The ID and lifecycle state of the stored metadata are kept.
+func (s *Storage) UpdateMetadata(id string, metadata *Metadata, preconditions ...Precondition) (*Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.byID[id]
+	if !ok {
+		return nil, ErrNotFound
 	}
-	err = indexField(metadata.Company, s.index.company, metadata, true)
+	err := checkPreconditions(stored, preconditions)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = indexField(metadata.Website, s.index.website, metadata, true)
+	updated := metadata.clone()
+	updated.ID = stored.ID
+	updated.State = stored.State
+	updated.Deprecation = stored.Deprecation
+	updated.YankReason = stored.YankReason
+	updated.Created = stored.Created
+	updated.Updated = time.Now().UTC()
+	updated.Revision = stored.Revision + 1
+	s.index.remove(stored)
+	err = s.index.add(updated)
 	if err != nil {
-		return err
+		// restore the previous version so the index stays consistent
+		s.index.add(stored)
+		return nil, err
+	}
+	for i, md := range s.documents {
+		if md == stored {
+			s.documents[i] = updated
+		}
 	}
-	err = indexField(metadata.Source, s.index.source, metadata, true)
+	s.byID[id] = updated
+	s.notify(ChangeUpdate, updated)
+	return updated.clone(), nil
+}
+
+// DeleteMetadata removes metadata from the store and its index, if every precondition holds
+func (s *Storage) DeleteMetadata(id string, preconditions ...Precondition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	err := checkPreconditions(stored, preconditions)
 	if err != nil {
 		return err
 	}
-	err = indexField(metadata.License, s.index.license, metadata, true)
+	s.index.remove(stored)
+	for i, md := range s.documents {
+		if md == stored {
+			s.documents = append(s.documents[:i], s.documents[i+1:]...)
+			break
+		}
+	}
+	delete(s.byID, id)
+	s.notify(ChangeDelete, stored)
+	return nil
+}
+
+// Owners returns the maintainers of an application, identified by its title, and whether the application exists.
+// An application is owned by the maintainers of its highest stored version. Yanked versions are only considered
+// when every version of the application has been yanked.
+func (s *Storage) Owners(title string) ([]Maintainer, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.owners(title)
+}
+
+// owners finds the maintainers of an application as Owners does. It must be called while holding the lock.
+func (s *Storage) owners(title string) ([]Maintainer, bool) {
+	var owner, yankedOwner *Metadata
+	var ownerVersion, yankedOwnerVersion *semver.Version
+	title = strings.TrimSpace(title)
+	for _, md := range s.documents {
+		if !strings.EqualFold(strings.TrimSpace(md.Title), title) {
+			continue
+		}
+		v, err := semver.NewVersion(md.Version)
+		if err != nil {
+			continue
+		}
+		if md.State == Yanked {
+			if yankedOwner == nil || !v.LessThan(yankedOwnerVersion) {
+				yankedOwner, yankedOwnerVersion = md, v
+			}
+			continue
+		}
+		if owner == nil || !v.LessThan(ownerVersion) {
+			owner, ownerVersion = md, v
+		}
+	}
+	if owner == nil {
+		owner = yankedOwner
+	}
+	if owner == nil {
+		return nil, false
+	}
+	return append([]Maintainer{}, owner.Maintainers...), true
+}
+
+// GetMetadata returns the metadata stored with the given ID, regardless of its lifecycle state
+func (s *Storage) GetMetadata(id string) (*Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	md, ok := s.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return md.clone(), nil
+}
+
+// DeprecateMetadata marks metadata as deprecated, if every precondition holds. Deprecated metadata is still
+// returned by searches. If a replacement is provided, it must be the ID of other stored metadata.
+func (s *Storage) DeprecateMetadata(id string, deprecation Deprecation, preconditions ...Precondition) (*Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md, ok := s.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	err := checkPreconditions(md, preconditions)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if md.State == Yanked {
+		return nil, errors.New("yanked metadata cannot be deprecated")
+	}
+	if strings.TrimSpace(deprecation.Message) == "" {
+		return nil, errors.New("deprecation must have a message")
+	}
+	if deprecation.Replacement != "" {
+		if deprecation.Replacement == id {
+			return nil, errors.New("metadata cannot be replaced by itself")
+		}
+		if _, ok := s.byID[deprecation.Replacement]; !ok {
+			return nil, fmt.Errorf("replacement %s does not exist", deprecation.Replacement)
+		}
+	}
+	md.State = Deprecated
+	md.Deprecation = &deprecation
+	md.Updated = time.Now().UTC()
+	md.Revision++
+	s.notify(ChangeDeprecate, md)
+	return md.clone(), nil
+}
+
+// YankMetadata hides metadata from searches, if every precondition holds. Yanked metadata can still be fetched by
+// its ID.
+func (s *Storage) YankMetadata(id string, reason string, preconditions ...Precondition) (*Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md, ok := s.byID[id]
+	if !ok {
+		return nil, ErrNotFound
 	}
-	err = indexField(metadata.Description, s.index.description, metadata, true)
+	err := checkPreconditions(md, preconditions)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	md.State = Yanked
+	md.YankReason = reason
+	md.Updated = time.Now().UTC()
+	md.Revision++
+	s.notify(ChangeYank, md)
+	return md.clone(), nil
+}
+
+// GetVersion returns the metadata stored for a specific version of an application, identified by its title.
+// Titles are matched case-insensitively, and versions are compared by semantic version precedence.
+// If the same version was stored more than once, the most recently stored document is returned.
+func (s *Storage) GetVersion(title string, version string) (*Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	want, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("version %q must follow the semantic versioning scheme: https://semver.org", version)
+	}
+	title = strings.TrimSpace(title)
+	for i := len(s.documents) - 1; i >= 0; i-- {
+		md := s.documents[i]
+		if !strings.EqualFold(strings.TrimSpace(md.Title), title) {
+			continue
+		}
+		v, err := semver.NewVersion(md.Version)
+		if err != nil {
+			continue
+		}
+		if v.Equal(want) {
+			return md.clone(), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Versions returns every stored version of an application, identified by its title, ordered by semantic version
+// precedence. Titles are matched case-insensitively, and yanked versions are included.
+func (s *Storage) Versions(title string) []*Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	title = strings.TrimSpace(title)
+	versions := []*Metadata{}
+	for _, md := range s.documents {
+		if strings.EqualFold(strings.TrimSpace(md.Title), title) {
+			versions = append(versions, md.clone())
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, erri := semver.NewVersion(versions[i].Version)
+		vj, errj := semver.NewVersion(versions[j].Version)
+		if erri != nil || errj != nil {
+			return versions[i].Version < versions[j].Version
+		}
+		return vi.LessThan(vj)
+	})
+	return versions
+}
+
+// AllMetadata returns every stored document, whatever its lifecycle state, in the order they were stored
+func (s *Storage) AllMetadata() []*Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return cloneAll(s.documents)
 }
 
 // retrieveDocuments returns all metadata that matches a search phrase in a specific attribute
@@ -99,7 +481,7 @@ func (s *Storage) retrieveDocuments(attr string, searchInput string) ([]*Metadat
 	resultSet := map[string][]*Metadata{}
 	switch attribute(attr) {
 	case Title:
-		err := getResultsByToken(searchInput, resultSet, s.index.title)
+		err := s.index.getResultsByToken(searchInput, resultSet, s.index.title)
 		if err != nil {
 			return nil, err
 		}
@@ -107,37 +489,37 @@ func (s *Storage) retrieveDocuments(attr string, searchInput string) ([]*Metadat
 		// do not tokenize version numbers (should include periods)
 		resultSet[searchInput] = append(resultSet[searchInput], s.index.version[searchInput]...)
 	case MaintainerName:
-		err := getResultsByToken(searchInput, resultSet, s.index.maintainerName)
+		err := s.index.getResultsByToken(searchInput, resultSet, s.index.maintainerName)
 		if err != nil {
 			return nil, err
 		}
 	case MaintainerEmail:
-		err := getResultsByToken(searchInput, resultSet, s.index.maintainerEmail)
+		err := s.index.getResultsByToken(searchInput, resultSet, s.index.maintainerEmail)
 		if err != nil {
 			return nil, err
 		}
 	case Company:
-		err := getResultsByToken(searchInput, resultSet, s.index.company)
+		err := s.index.getResultsByToken(searchInput, resultSet, s.index.company)
 		if err != nil {
 			return nil, err
 		}
 	case Website:
-		err := getResultsByToken(searchInput, resultSet, s.index.website)
+		err := s.index.getResultsByToken(searchInput, resultSet, s.index.website)
 		if err != nil {
 			return nil, err
 		}
 	case Source:
-		err := getResultsByToken(searchInput, resultSet, s.index.source)
+		err := s.index.getResultsByToken(searchInput, resultSet, s.index.source)
 		if err != nil {
 			return nil, err
 		}
 	case License:
-		err := getResultsByToken(searchInput, resultSet, s.index.license)
+		err := s.index.getResultsByToken(searchInput, resultSet, s.index.license)
 		if err != nil {
 			return nil, err
 		}
 	case Description:
-		err := getResultsByToken(searchInput, resultSet, s.index.description)
+		err := s.index.getResultsByToken(searchInput, resultSet, s.index.description)
 		if err != nil {
 			return nil, err
 		}
@@ -150,8 +532,8 @@ func (s *Storage) retrieveDocuments(attr string, searchInput string) ([]*Metadat
 	return resultsMatchingAllTerms, nil
 }
 
-func getResultsByToken(searchInput string, resultSet, field map[string][]*Metadata) error {
-	tokens, err := processText(searchInput)
+func (idx *index) getResultsByToken(searchInput string, resultSet, field map[string][]*Metadata) error {
+	tokens, err := idx.analyzer.process(searchInput)
 	if err != nil {
 		return err
 	}
@@ -162,17 +544,87 @@ func getResultsByToken(searchInput string, resultSet, field map[string][]*Metada
 	return nil
 }
 
-// LookupMetadata performs a search of all metadata by the desired attribute(s)
-func (s *Storage) LookupMetadata(attrsAndValues map[string]string) ([]*Metadata, error) {
+// LookupMetadata performs a search of all metadata by the desired attribute(s).
+// Yanked metadata is left out of the results unless includeYanked is set. Failures are logged with the logger carried by ctx.
+func (s *Storage) LookupMetadata(ctx context.Context, attrsAndValues map[string]string, includeYanked bool) ([]*Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	resultSet := map[string][]*Metadata{}
 	for k, v := range attrsAndValues {
 		result, err := s.retrieveDocuments(k, v)
 		if err != nil {
+			logging.FromContext(ctx).Warn("could not look up metadata", "attribute", k, "value", v, "error", err)
 			return nil, err
 		}
 		resultSet[k] = result
 	}
-	return matchAllTerms(resultSet), nil
+	results := []*Metadata{}
+	for _, md := range matchAllTerms(resultSet) {
+		if md.State == Yanked && !includeYanked {
+			continue
+		}
+		results = append(results, md.clone())
+	}
+	logging.FromContext(ctx).Debug("looked up metadata", "query", attrsAndValues, "results", len(results))
+	return results, nil
+}
+
+// Validation failure reasons, reported by ValidationError
+const (
+	ReasonMissing          = "missing"
+	ReasonInvalidVersion   = "invalid_version"
+	ReasonInvalidEmail     = "invalid_email"
+	ReasonUnresolvableMail = "unresolvable_email"
+	ReasonInvalidURL       = "invalid_url"
+)
+
+// ValidationError describes the first problem found with metadata by ValidateMetadata
+type ValidationError struct {
+	// Field is the YAML name of the invalid attribute, such as title or maintainers.email
+	Field string
+	// Index is the position of the invalid maintainer for maintainer fields, and -1 otherwise
+	Index  int
+	Reason string
+	msg    string
+}
+
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+func invalid(field string, reason string, msg string) *ValidationError {
+	return &ValidationError{Field: field, Index: -1, Reason: reason, msg: msg}
+}
+
+func invalidMaintainer(index int, field string, reason string, msg string) *ValidationError {
+	return &ValidationError{Field: field, Index: index, Reason: reason, msg: msg}
+}
+
+// OnMXLookup registers an observer that is called with the duration and outcome of every DNS lookup made to
+// validate maintainer emails
+func (s *Storage) OnMXLookup(observer func(duration time.Duration, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mxObservers = append(s.mxObservers, observer)
+}
+
+// Ready reports an error when the storage cannot serve reads within timeout, because writes are holding it.
+// A check that times out leaves a goroutine waiting for the storage, which exits once the storage is released.
+func (s *Storage) Ready(timeout time.Duration) error {
+	released := make(chan struct{})
+	go func() {
+		s.mu.RLock()
+		s.mu.RUnlock()
+		close(released)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-released:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("storage did not respond within %v", timeout)
+	}
 }
 
 // ValidateMetadata ensures that all metadata fields are formatted properly.
@@ -182,54 +634,56 @@ func (s *Storage) LookupMetadata(attrsAndValues map[string]string) ([]*Metadata,
 // Maintainers must be a slice of Maintainer structs, each of which as a Name and Email field. Email must be a valid email address.
 // Website and Source must use a properly formatted URL.
 // Description must be formatted using Markdown.
+// Any error returned is a *ValidationError.
 func (s *Storage) ValidateMetadata(metadata *Metadata) error {
 	if metadata.Title == "" {
-		return errors.New("metadata must have a title")
+		return invalid("title", ReasonMissing, "metadata must have a title")
 	}
 	if metadata.Version == "" {
-		return errors.New("metadata must have a version")
+		return invalid("version", ReasonMissing, "metadata must have a version")
 	}
 	_, err := semver.NewVersion(metadata.Version)
 	if err != nil {
-		return errors.New("version must follow the semantic versioning scheme: https://semver.org")
+		return invalid("version", ReasonInvalidVersion, "version must follow the semantic versioning scheme: https://semver.org")
 	}
 	if len(metadata.Maintainers) == 0 {
-		return errors.New("metadata must have a list of maintainers, each of which has a name and email attribute")
+		return invalid("maintainers", ReasonMissing, "metadata must have a list of maintainers, each of which has a name and email attribute")
 	}
-	for _, maintainer := range metadata.Maintainers {
+	for i, maintainer := range metadata.Maintainers {
 		if maintainer.Name == "" {
-			return errors.New("maintainer must have a name")
+			return invalidMaintainer(i, "maintainers.name", ReasonMissing, "maintainer must have a name")
 		}
 		if maintainer.Email == "" {
-			return errors.New("maintainer must have an email")
+			return invalidMaintainer(i, "maintainers.email", ReasonMissing, "maintainer must have an email")
 		}
-		err = validateEmail(maintainer.Email)
+		err := s.validateEmail(maintainer.Email)
 		if err != nil {
+			err.Index = i
 			return err
 		}
 	}
 	if metadata.Company == "" {
-		return errors.New("metadata must have a company")
+		return invalid("company", ReasonMissing, "metadata must have a company")
 	}
 	if metadata.Website == "" {
-		return errors.New("metadata must have a website")
+		return invalid("website", ReasonMissing, "metadata must have a website")
 	}
 	_, err = url.ParseRequestURI(metadata.Website)
 	if err != nil {
-		return errors.New("metadata must have a website with a valid URL")
+		return invalid("website", ReasonInvalidURL, "metadata must have a website with a valid URL")
 	}
 	if metadata.Source == "" {
-		return errors.New("metadata must have a source")
+		return invalid("source", ReasonMissing, "metadata must have a source")
 	}
 	_, err = url.ParseRequestURI(metadata.Source)
 	if err != nil {
-		return errors.New("metadata must have a source with a valid URL")
+		return invalid("source", ReasonInvalidURL, "metadata must have a source with a valid URL")
 	}
 	if metadata.License == "" {
-		return errors.New("metadata must have a license")
+		return invalid("license", ReasonMissing, "metadata must have a license")
 	}
 	if metadata.Description == "" {
-		return errors.New("metadata must have a description")
+		return invalid("description", ReasonMissing, "metadata must have a description")
 	}
 	// err = goldmark.Convert([]byte(metadata.Description), &bytes.Buffer{})
 	// if err != nil {
@@ -241,14 +695,24 @@ func (s *Storage) ValidateMetadata(metadata *Metadata) error {
 // from https://golangcode.com/validate-an-email-address/
 var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
-func validateEmail(e string) error {
+func (s *Storage) validateEmail(e string) *ValidationError {
 	if (len(e) < 3 && len(e) > 254) || !emailRegex.MatchString(e) {
-		return errors.New("email must be a properly formatted email address")
+		return invalidMaintainer(-1, "maintainers.email", ReasonInvalidEmail, "email must be a properly formatted email address")
+	}
+	if s.skipMX {
+		return nil
 	}
 	parts := strings.Split(e, "@")
+	start := time.Now()
 	mx, err := net.LookupMX(parts[1])
+	s.mu.RLock()
+	observers := s.mxObservers
+	s.mu.RUnlock()
+	for _, observer := range observers {
+		observer(time.Since(start), err)
+	}
 	if err != nil || len(mx) == 0 {
-		return errors.New("email must be a valid email address")
+		return invalidMaintainer(-1, "maintainers.email", ReasonUnresolvableMail, "email must be a valid email address")
 	}
 	return nil
 }
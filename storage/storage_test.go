@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -418,7 +422,7 @@ func Test_AddDocument(t *testing.T) {
 		Description: "# A main heading\n## A secondary heading\nA paragraph\n![an image](https://image.com/png)",
 	}
 
-	err := s.AddMetadata(md)
+	err := s.AddMetadata(context.Background(), md)
 	assert.NoError(t, err)
 	// check title
 	checkIndexForTokens(t, md.Title, md, s.index.title)
@@ -443,7 +447,7 @@ func Test_AddDocument(t *testing.T) {
 }
 
 func checkIndexForTokens(t *testing.T, text string, md *Metadata, field map[string][]*Metadata) {
-	tokens, err := processText(text)
+	tokens, err := DefaultAnalyzer.process(text)
 	assert.NoError(t, err)
 	for _, token := range tokens {
 		assert.NotEmpty(t, field[token])
@@ -494,7 +498,7 @@ func Test_retrieveDocuments(t *testing.T) {
 		},
 	}
 	for _, document := range documents {
-		err := s.AddMetadata(document)
+		err := s.AddMetadata(context.Background(), document)
 		assert.NoError(t, err)
 	}
 	// exercise search against titles
@@ -638,27 +642,468 @@ func Test_LookupMetadata(t *testing.T) {
 		},
 	}
 	for _, document := range documents {
-		err := s.AddMetadata(document)
+		err := s.AddMetadata(context.Background(), document)
 		assert.NoError(t, err)
 	}
-	results, err := s.LookupMetadata(map[string]string{
+	results, err := s.LookupMetadata(context.Background(), map[string]string{
 		"title":   "app title",
 		"company": "smallcorp",
-	})
+	}, false)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(results))
 	assert.Equal(t, documents[1], results[0])
-	results, err = s.LookupMetadata(map[string]string{
+	results, err = s.LookupMetadata(context.Background(), map[string]string{
 		"license": "apache",
-	})
+	}, false)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(results))
 	assert.Equal(t, documents[1], results[0])
-	results, err = s.LookupMetadata(map[string]string{
+	results, err = s.LookupMetadata(context.Background(), map[string]string{
 		"title":       "app title",
 		"license":     "apache",
 		"description": "no match",
-	})
+	}, false)
 	assert.NoError(t, err)
 	assert.Empty(t, results)
 }
+
+func Test_GetVersion(t *testing.T) {
+	s := NewStorage()
+	documents := []*Metadata{
+		{
+			Title:   "App title 1",
+			Version: "1.0.0",
+			License: "MIT",
+		},
+		{
+			Title:   "App title 1",
+			Version: "1.1.0",
+			License: "MIT",
+		},
+		{
+			Title:   "App title 2",
+			Version: "1.0.0",
+			License: "Apache-2.0",
+		},
+	}
+	for _, document := range documents {
+		err := s.AddMetadata(context.Background(), document)
+		assert.NoError(t, err)
+	}
+	md, err := s.GetVersion("app title 1", "1.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, documents[1], md)
+	md, err = s.GetVersion("App title 2", "1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, documents[2], md)
+	_, err = s.GetVersion("App title 2", "2.0.0")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = s.GetVersion("App title 3", "1.0.0")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = s.GetVersion("App title 1", "latest")
+	assert.Error(t, err)
+}
+
+func Test_Versions(t *testing.T) {
+	s := NewStorage()
+	for _, version := range []string{"1.10.0", "1.2.0", "1.2.0-beta.1"} {
+		assert.NoError(t, s.AddMetadata(context.Background(), &Metadata{Title: "App title 1", Version: version}))
+	}
+	assert.NoError(t, s.AddMetadata(context.Background(), &Metadata{Title: "App title 2", Version: "1.0.0"}))
+
+	versions := []string{}
+	for _, md := range s.Versions(" app TITLE 1") {
+		versions = append(versions, md.Version)
+	}
+	assert.Equal(t, []string{"1.2.0-beta.1", "1.2.0", "1.10.0"}, versions)
+	assert.Empty(t, s.Versions("App title 3"))
+	assert.Len(t, s.AllMetadata(), 4)
+}
+
+func Test_MetadataLifecycle(t *testing.T) {
+	s := NewStorage()
+	documents := []*Metadata{
+		{
+			Title:   "App title 1",
+			Version: "1.0.0",
+			License: "MIT",
+		},
+		{
+			Title:   "App title 1",
+			Version: "1.1.0",
+			License: "MIT",
+		},
+	}
+	for _, document := range documents {
+		err := s.AddMetadata(context.Background(), document)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, document.ID)
+		assert.Equal(t, Active, document.State)
+	}
+
+	t.Run("Deprecated metadata carries its notice and is still returned by searches", func(t *testing.T) {
+		md, err := s.DeprecateMetadata(documents[0].ID, Deprecation{Message: "use 1.1.0", Replacement: documents[1].ID})
+		assert.NoError(t, err)
+		assert.Equal(t, Deprecated, md.State)
+		assert.Equal(t, &Deprecation{Message: "use 1.1.0", Replacement: documents[1].ID}, md.Deprecation)
+		results, err := s.LookupMetadata(context.Background(), map[string]string{"license": "MIT"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(results))
+	})
+
+	t.Run("Deprecation requires a message and an existing replacement", func(t *testing.T) {
+		_, err := s.DeprecateMetadata(documents[1].ID, Deprecation{})
+		assert.EqualError(t, err, "deprecation must have a message")
+		_, err = s.DeprecateMetadata(documents[1].ID, Deprecation{Message: "gone", Replacement: "unknown"})
+		assert.EqualError(t, err, "replacement unknown does not exist")
+		_, err = s.DeprecateMetadata("unknown", Deprecation{Message: "gone"})
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("Yanked metadata is hidden from searches unless requested, but can be fetched by ID", func(t *testing.T) {
+		md, err := s.YankMetadata(documents[1].ID, "leaked credentials")
+		assert.NoError(t, err)
+		assert.Equal(t, Yanked, md.State)
+		results, err := s.LookupMetadata(context.Background(), map[string]string{"license": "MIT"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(results))
+		assert.Equal(t, documents[0].ID, results[0].ID)
+		results, err = s.LookupMetadata(context.Background(), map[string]string{"license": "MIT"}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(results))
+		md, err = s.GetMetadata(documents[1].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "leaked credentials", md.YankReason)
+		_, err = s.DeprecateMetadata(documents[1].ID, Deprecation{Message: "gone"})
+		assert.EqualError(t, err, "yanked metadata cannot be deprecated")
+	})
+
+	t.Run("Returned metadata cannot modify stored metadata", func(t *testing.T) {
+		md, err := s.GetMetadata(documents[0].ID)
+		assert.NoError(t, err)
+		md.Deprecation.Message = "changed"
+		md, err = s.GetMetadata(documents[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "use 1.1.0", md.Deprecation.Message)
+	})
+}
+
+func Test_UpdateAndDeleteMetadata(t *testing.T) {
+	s := NewStorage()
+	md := &Metadata{
+		Title:   "App title 1",
+		Version: "1.0.0",
+		Maintainers: []Maintainer{
+			{
+				Name:  "Bill Bob",
+				Email: "bill@gmail.com",
+			},
+		},
+		License: "MIT",
+	}
+	err := s.AddMetadata(context.Background(), md)
+	assert.NoError(t, err)
+	_, err = s.DeprecateMetadata(md.ID, Deprecation{Message: "old"})
+	assert.NoError(t, err)
+
+	t.Run("Updating replaces attributes and index references, but keeps the ID, lifecycle state and creation time", func(t *testing.T) {
+		updated, err := s.UpdateMetadata(md.ID, &Metadata{
+			Title:   "App title 1",
+			Version: "1.0.0",
+			License: "Apache-2.0",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, md.ID, updated.ID)
+		assert.Equal(t, Deprecated, updated.State)
+		assert.Equal(t, "Apache-2.0", updated.License)
+		assert.Equal(t, md.Created, updated.Created)
+		assert.False(t, updated.Updated.Before(md.Created))
+		results, err := s.LookupMetadata(context.Background(), map[string]string{"license": "MIT"}, false)
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+		results, err = s.LookupMetadata(context.Background(), map[string]string{"license": "apache"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(results))
+		assert.Empty(t, s.index.maintainerEmail)
+	})
+
+	t.Run("Deleting removes metadata and every index reference to it", func(t *testing.T) {
+		err := s.DeleteMetadata(md.ID)
+		assert.NoError(t, err)
+		_, err = s.GetMetadata(md.ID)
+		assert.Equal(t, ErrNotFound, err)
+		for _, field := range s.index.fields() {
+			assert.Empty(t, field)
+		}
+		assert.Equal(t, ErrNotFound, s.DeleteMetadata(md.ID))
+		_, err = s.UpdateMetadata(md.ID, &Metadata{})
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func Test_Owners(t *testing.T) {
+	s := NewStorage()
+	documents := []*Metadata{
+		{
+			Title:       "App title 1",
+			Version:     "1.0.0",
+			Maintainers: []Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}},
+		},
+		{
+			Title:       "App title 1",
+			Version:     "1.2.0",
+			Maintainers: []Maintainer{{Name: "Billy Bob", Email: "billy@gmail.com"}},
+		},
+		{
+			Title:       "App title 1",
+			Version:     "1.10.0",
+			Maintainers: []Maintainer{{Name: "Mallory", Email: "mallory@gmail.com"}},
+		},
+	}
+	for _, document := range documents {
+		err := s.AddMetadata(context.Background(), document)
+		assert.NoError(t, err)
+	}
+	owners, exists := s.Owners("app title 1")
+	assert.True(t, exists)
+	assert.Equal(t, documents[2].Maintainers, owners)
+	_, err := s.YankMetadata(documents[2].ID, "hijacked")
+	assert.NoError(t, err)
+	owners, exists = s.Owners("app title 1")
+	assert.True(t, exists)
+	assert.Equal(t, documents[1].Maintainers, owners)
+	_, exists = s.Owners("App title 2")
+	assert.False(t, exists)
+}
+
+func Test_IfOwnersAllow(t *testing.T) {
+	s := NewStorage()
+	first := &Metadata{Title: "App title 1", Version: "1.0.0", Maintainers: []Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}}}
+	other := &Metadata{Title: "App title 2", Version: "1.0.0", Maintainers: []Maintainer{{Name: "Mallory", Email: "mallory@gmail.com"}}}
+	for _, md := range []*Metadata{first, other} {
+		assert.NoError(t, s.AddMetadata(context.Background(), md))
+	}
+	errNotOwner := errors.New("not an owner")
+	checked := []string{}
+	ownedByBill := func(owners []Maintainer, exists bool) error {
+		for _, owner := range owners {
+			checked = append(checked, owner.Email)
+			if owner.Email == "bill@gmail.com" {
+				return nil
+			}
+		}
+		if !exists {
+			return nil
+		}
+		return errNotOwner
+	}
+
+	t.Run("Owners are checked when the metadata is written", func(t *testing.T) {
+		precondition := s.IfOwnersAllow(ownedByBill)
+		// ownership changes after the caller was authorized, but before the write
+		assert.NoError(t, s.AddMetadata(context.Background(), &Metadata{
+			Title:       "App title 1",
+			Version:     "2.0.0",
+			Maintainers: []Maintainer{{Name: "Mallory", Email: "mallory@gmail.com"}},
+		}))
+		assert.Equal(t, errNotOwner, s.DeleteMetadata(first.ID, precondition))
+		_, err := s.GetMetadata(first.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Owners of the applications metadata is renamed to are checked too", func(t *testing.T) {
+		checked = nil
+		md := &Metadata{Title: "App title 3", Version: "1.0.0", Maintainers: []Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}}}
+		assert.NoError(t, s.AddMetadata(context.Background(), md))
+		_, err := s.UpdateMetadata(md.ID, other, s.IfOwnersAllow(ownedByBill, other.Title))
+		assert.Equal(t, errNotOwner, err)
+		assert.Equal(t, []string{"bill@gmail.com", "mallory@gmail.com"}, checked)
+		_, err = s.UpdateMetadata(md.ID, &Metadata{Title: "app title 3 ", Version: "1.1.0"}, s.IfOwnersAllow(ownedByBill, "app title 3 "))
+		assert.NoError(t, err)
+	})
+}
+
+func Test_ValidationError(t *testing.T) {
+	s := NewStorage()
+	err := s.ValidateMetadata(&Metadata{
+		Title:   "App title 1",
+		Version: "1.0.0",
+		Maintainers: []Maintainer{
+			{
+				Name: "Medhir Bhargava",
+			},
+		},
+	})
+	validationErr, ok := err.(*ValidationError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "maintainers.email", validationErr.Field)
+		assert.Equal(t, 0, validationErr.Index)
+		assert.Equal(t, ReasonMissing, validationErr.Reason)
+	}
+	err = s.ValidateMetadata(&Metadata{Title: "App title 1", Version: "one"})
+	validationErr, ok = err.(*ValidationError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "version", validationErr.Field)
+		assert.Equal(t, -1, validationErr.Index)
+		assert.Equal(t, ReasonInvalidVersion, validationErr.Reason)
+	}
+}
+
+func Test_StorageOptions(t *testing.T) {
+	md := &Metadata{
+		Title:       "Jumping app",
+		Version:     "1.0.0",
+		Maintainers: []Maintainer{{Name: "Bill Bob", Email: "bill@example.invalid"}},
+		Company:     "BigCorp",
+		Website:     "https://www.wikipedia.com",
+		Source:      "https://github.com",
+		License:     "MIT",
+		Description: "The app for jumping",
+	}
+
+	t.Run("Maintainer email domains are not looked up when the MX check is disabled", func(t *testing.T) {
+		s := NewStorage(WithMXCheck(false))
+		assert.NoError(t, s.ValidateMetadata(md))
+	})
+
+	t.Run("The analyzer controls which terms match", func(t *testing.T) {
+		stemmed := NewStorage()
+		literal := NewStorage(WithAnalyzer(Analyzer{Language: "english"}))
+		for _, s := range []*Storage{stemmed, literal} {
+			assert.NoError(t, s.AddMetadata(context.Background(), md.clone()))
+		}
+		results, err := stemmed.LookupMetadata(context.Background(), map[string]string{"title": "jumped"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(results))
+		results, err = literal.LookupMetadata(context.Background(), map[string]string{"title": "jumped"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(results))
+		results, err = literal.LookupMetadata(context.Background(), map[string]string{"description": "the"}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(results))
+	})
+
+	t.Run("Analyzers must stem a supported language", func(t *testing.T) {
+		assert.NoError(t, DefaultAnalyzer.Validate())
+		assert.NoError(t, Analyzer{Language: "klingon"}.Validate())
+		assert.Error(t, Analyzer{Language: "klingon", Stemming: true}.Validate())
+	})
+}
+
+func Test_Generation(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	generations := map[uint64]bool{}
+	previous, _ := s.Generation()
+	generations[previous] = true
+	advanced := func(name string) {
+		generation, modified := s.Generation()
+		assert.False(t, generations[generation], "%s does not change the generation", name)
+		assert.False(t, modified.IsZero())
+		generations[generation] = true
+	}
+
+	md := &Metadata{Title: "Generation app", Version: "1.0.0"}
+	assert.NoError(t, s.AddMetadata(ctx, md))
+	advanced("storing")
+	_, err := s.UpdateMetadata(md.ID, &Metadata{Title: "Generation app", Version: "1.0.1"})
+	assert.NoError(t, err)
+	advanced("updating")
+	_, err = s.DeprecateMetadata(md.ID, Deprecation{Message: "old"})
+	assert.NoError(t, err)
+	advanced("deprecating")
+	_, err = s.YankMetadata(md.ID, "broken")
+	assert.NoError(t, err)
+	advanced("yanking")
+	_, err = s.Reindex(Analyzer{Language: "english"})
+	assert.NoError(t, err)
+	advanced("reindexing")
+	assert.NoError(t, s.DeleteMetadata(md.ID))
+	advanced("deleting")
+
+	current, _ := s.Generation()
+	_, err = s.LookupMetadata(ctx, map[string]string{"title": "generation"}, true)
+	assert.NoError(t, err)
+	assert.Error(t, s.DeleteMetadata(md.ID))
+	after, _ := s.Generation()
+	assert.Equal(t, current, after, "reads and failed writes do not change the generation")
+}
+
+func Test_Revisions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Every modification increases the revision", func(t *testing.T) {
+		s := NewStorage()
+		md := &Metadata{Title: "Revised app", Version: "1.0.0"}
+		assert.NoError(t, s.AddMetadata(ctx, md))
+		assert.Equal(t, 1, md.Revision)
+		updated, err := s.UpdateMetadata(md.ID, &Metadata{Title: "Revised app", Version: "1.0.1", Revision: 10}, IfRevision(1))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, updated.Revision)
+		deprecated, err := s.DeprecateMetadata(md.ID, Deprecation{Message: "old"}, IfRevision(2))
+		assert.NoError(t, err)
+		assert.Equal(t, 3, deprecated.Revision)
+		yanked, err := s.YankMetadata(md.ID, "broken", IfRevision(2, 3))
+		assert.NoError(t, err)
+		assert.Equal(t, 4, yanked.Revision)
+		assert.NoError(t, s.DeleteMetadata(md.ID, IfRevision(4)))
+	})
+
+	t.Run("Writes fail when the metadata is not at an expected revision, modifying nothing", func(t *testing.T) {
+		s := NewStorage()
+		md := &Metadata{Title: "Revised app", Version: "1.0.0"}
+		assert.NoError(t, s.AddMetadata(ctx, md))
+		expected := &RevisionError{ID: md.ID, Expected: []int{2}, Current: 1}
+		_, err := s.UpdateMetadata(md.ID, &Metadata{Title: "Other app", Version: "1.0.1"}, IfRevision(2))
+		assert.Equal(t, expected, err)
+		assert.EqualError(t, err, "metadata "+md.ID+" is at revision 1, not 2")
+		_, err = s.DeprecateMetadata(md.ID, Deprecation{Message: "old"}, IfRevision(2))
+		assert.Equal(t, expected, err)
+		_, err = s.YankMetadata(md.ID, "broken", IfRevision(2))
+		assert.Equal(t, expected, err)
+		assert.Equal(t, expected, s.DeleteMetadata(md.ID, IfRevision(2)))
+		stored, err := s.GetMetadata(md.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, md, stored)
+	})
+
+	t.Run("Only one of several concurrent writes expecting the same revision succeeds", func(t *testing.T) {
+		s := NewStorage()
+		md := &Metadata{Title: "Revised app", Version: "1.0.0"}
+		assert.NoError(t, s.AddMetadata(ctx, md))
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.UpdateMetadata(md.ID, &Metadata{Title: "Revised app", Version: "1.0.1"}, IfRevision(1))
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		succeeded := 0
+		for err := range errs {
+			if err == nil {
+				succeeded++
+			} else {
+				assert.IsType(t, &RevisionError{}, err)
+			}
+		}
+		assert.Equal(t, 1, succeeded)
+		stored, err := s.GetMetadata(md.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, stored.Revision)
+	})
+}
+
+func Test_Ready(t *testing.T) {
+	s := NewStorage()
+	assert.NoError(t, s.Ready(time.Second))
+
+	s.mu.Lock()
+	assert.EqualError(t, s.Ready(10*time.Millisecond), "storage did not respond within 10ms")
+	s.mu.Unlock()
+	assert.NoError(t, s.Ready(time.Second))
+}
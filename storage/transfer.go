@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Conflict chooses what happens when imported metadata has the ID of stored metadata
+type Conflict string
+
+const (
+	// ConflictFail rejects the whole import, storing nothing
+	ConflictFail = Conflict("fail")
+	// ConflictSkip keeps the stored metadata, and ignores the imported document
+	ConflictSkip = Conflict("skip")
+	// ConflictOverwrite replaces the stored metadata with the imported document
+	ConflictOverwrite = Conflict("overwrite")
+)
+
+// ImportResult counts what an import did with its documents
+type ImportResult struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+// ConflictError is returned when an import with ConflictFail has documents with the IDs of stored metadata
+type ConflictError struct {
+	// Documents are the positions of the conflicting documents in the import, and IDs their IDs
+	Documents []int
+	IDs       []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d imported documents have the IDs of stored metadata, such as %s", len(e.IDs), e.IDs[0])
+}
+
+// Import restores metadata exported from a store, keeping its IDs, lifecycle state, timestamps and revisions.
+// Documents without an ID are assigned one, documents without a state are active, missing timestamps are set to the
+// time of the import, and missing revisions start at 1. Overwritten metadata moves past the revision it had, so
+// writes expecting that revision fail. The index is rebuilt from scratch without blocking searches or writes, and
+// replaces the previous index only if it was built successfully, so failed imports leave the store untouched.
+// Documents are not validated.
+func (s *Storage) Import(documents []*Metadata, conflict Conflict) (ImportResult, error) {
+	result := ImportResult{}
+	if conflict != ConflictFail && conflict != ConflictSkip && conflict != ConflictOverwrite {
+		return result, fmt.Errorf("unknown conflict strategy %q, expected one of fail, skip or overwrite", conflict)
+	}
+	now := time.Now().UTC()
+	imported := make([]*Metadata, len(documents))
+	seen := map[string]int{}
+	for i, document := range documents {
+		md := document.clone()
+		if md.ID == "" {
+			id, err := newID()
+			if err != nil {
+				return result, err
+			}
+			md.ID = id
+		}
+		if first, ok := seen[md.ID]; ok {
+			return result, fmt.Errorf("documents %d and %d have the same ID %s", first, i, md.ID)
+		}
+		seen[md.ID] = i
+		err := normalizeLifecycle(md, now)
+		if err != nil {
+			return result, fmt.Errorf("document %d: %v", i, err)
+		}
+		imported[i] = md
+	}
+
+	// the new index is built without holding the lock, from a snapshot of the store with the documents laid over it,
+	// as Reindex does, and rebuilds are serialized so neither replaces the index the other built
+	s.reindexMu.Lock()
+	defer s.reindexMu.Unlock()
+	s.mu.RLock()
+	snapshot := append([]*Metadata{}, s.documents...)
+	analyzer := s.index.analyzer
+	s.mu.RUnlock()
+	merged, err := mergeImport(snapshot, imported, conflict)
+	if err != nil {
+		return result, err
+	}
+	idx, err := buildIndex(analyzer, merged.documents)
+	if err != nil {
+		return result, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the documents are laid over the store again, in case it changed while the index was built, and the index
+	// catches up with the difference. They are laid as the first merge left them, so documents that keep their
+	// revision are the ones already indexed.
+	current, err := mergeImport(s.documents, merged.imported, conflict)
+	if err != nil {
+		return result, err
+	}
+	_, err = applyChanges(&idx, merged.documents, current.documents)
+	if err != nil {
+		return result, err
+	}
+	s.documents, s.byID, s.index = current.documents, current.byID, idx
+
+	for _, md := range current.created {
+		s.percolate(md)
+		s.notify(ChangeCreate, md)
+	}
+	for _, md := range current.overwritten {
+		s.notify(ChangeUpdate, md)
+	}
+	result.Created, result.Overwritten, result.Skipped = len(current.created), len(current.overwritten), current.skipped
+	return result, nil
+}
+
+// mergedImport is the store once imported documents are laid over its documents
+type mergedImport struct {
+	documents []*Metadata
+	byID      map[string]*Metadata
+	// imported holds every imported document as it was laid over the store, with the revision it was given
+	imported    []*Metadata
+	created     []*Metadata
+	overwritten []*Metadata
+	skipped     int
+}
+
+// mergeImport lays imported documents over stored documents, or returns a *ConflictError if the conflict strategy
+// does not allow documents with the IDs of stored metadata. Neither are modified: an imported document that must move
+// past the revision of the document it overwrites is copied first.
+func mergeImport(documents, imported []*Metadata, conflict Conflict) (mergedImport, error) {
+	merged := mergedImport{
+		documents: append([]*Metadata{}, documents...),
+		byID:      map[string]*Metadata{},
+		imported:  make([]*Metadata, 0, len(imported)),
+	}
+	positions := map[string]int{}
+	for i, md := range merged.documents {
+		merged.byID[md.ID] = md
+		positions[md.ID] = i
+	}
+	if conflict == ConflictFail {
+		conflicts := &ConflictError{}
+		for i, md := range imported {
+			if _, ok := merged.byID[md.ID]; ok {
+				conflicts.Documents = append(conflicts.Documents, i)
+				conflicts.IDs = append(conflicts.IDs, md.ID)
+			}
+		}
+		if len(conflicts.IDs) > 0 {
+			return mergedImport{}, conflicts
+		}
+	}
+	for _, md := range imported {
+		position, exists := positions[md.ID]
+		switch {
+		case !exists:
+			positions[md.ID] = len(merged.documents)
+			merged.documents = append(merged.documents, md)
+			merged.created = append(merged.created, md)
+		case conflict == ConflictSkip:
+			merged.skipped++
+			merged.imported = append(merged.imported, md)
+			continue
+		default:
+			if md.Revision <= merged.documents[position].Revision {
+				md = md.clone()
+				md.Revision = merged.documents[position].Revision + 1
+			}
+			merged.documents[position] = md
+			merged.overwritten = append(merged.overwritten, md)
+		}
+		merged.imported = append(merged.imported, md)
+		merged.byID[md.ID] = md
+	}
+	return merged, nil
+}
+
+// normalizeLifecycle fills in the lifecycle state and timestamps of imported metadata, and checks that its state is
+// consistent
+func normalizeLifecycle(md *Metadata, now time.Time) error {
+	switch md.State {
+	case "":
+		md.State = Active
+	case Active, Deprecated, Yanked:
+	default:
+		return fmt.Errorf("unknown state %q", md.State)
+	}
+	if md.State == Deprecated && md.Deprecation == nil {
+		return fmt.Errorf("deprecated metadata %s must have a deprecation", md.ID)
+	}
+	if md.Created.IsZero() {
+		md.Created = now
+	}
+	if md.Updated.IsZero() {
+		md.Updated = md.Created
+	}
+	if md.Revision < 0 {
+		return fmt.Errorf("metadata %s has a negative revision", md.ID)
+	}
+	if md.Revision == 0 {
+		md.Revision = 1
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Import(t *testing.T) {
+	created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	exported := func() []*Metadata {
+		return []*Metadata{
+			{ID: "a", State: Active, Created: created, Updated: created, Revision: 1, Title: "Imported app", Version: "1.0.0", License: "MIT"},
+			{ID: "b", State: Deprecated, Deprecation: &Deprecation{Message: "use 2.0.0"}, Created: created, Updated: created, Revision: 2, Title: "Imported app", Version: "1.1.0", License: "MIT"},
+			{ID: "c", State: Yanked, YankReason: "broken", Created: created, Updated: created, Revision: 3, Title: "Imported app", Version: "1.2.0", License: "MIT"},
+		}
+	}
+	newStore := func(t *testing.T) *Storage {
+		s := NewStorage()
+		_, err := s.Import(exported(), ConflictFail)
+		assert.NoError(t, err)
+		return s
+	}
+
+	t.Run("Imported metadata keeps its ID, state, timestamps and revision, and is indexed", func(t *testing.T) {
+		s := newStore(t)
+		assert.Equal(t, exported(), s.AllMetadata())
+		results, err := s.LookupMetadata(context.Background(), map[string]string{"title": "imported", "license": "MIT"}, false)
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+	})
+
+	t.Run("Missing IDs, states, timestamps and revisions are filled in", func(t *testing.T) {
+		s := NewStorage()
+		result, err := s.Import([]*Metadata{{Title: "Bare app", Version: "1.0.0"}}, ConflictFail)
+		assert.NoError(t, err)
+		assert.Equal(t, ImportResult{Created: 1}, result)
+		md := s.AllMetadata()[0]
+		assert.NotEmpty(t, md.ID)
+		assert.Equal(t, Active, md.State)
+		assert.False(t, md.Created.IsZero())
+		assert.Equal(t, md.Created, md.Updated)
+		assert.Equal(t, 1, md.Revision)
+	})
+
+	t.Run("Import fails for conflicting IDs, storing nothing", func(t *testing.T) {
+		s := newStore(t)
+		documents := []*Metadata{{ID: "d", Title: "New app", Version: "1.0.0"}, {ID: "b", Title: "Changed app", Version: "1.1.0"}}
+		_, err := s.Import(documents, ConflictFail)
+		assert.Equal(t, &ConflictError{Documents: []int{1}, IDs: []string{"b"}}, err)
+		assert.Equal(t, exported(), s.AllMetadata())
+	})
+
+	t.Run("Conflicting documents are skipped", func(t *testing.T) {
+		s := newStore(t)
+		documents := []*Metadata{{ID: "d", Title: "New app", Version: "1.0.0"}, {ID: "b", Title: "Changed app", Version: "1.1.0"}}
+		result, err := s.Import(documents, ConflictSkip)
+		assert.NoError(t, err)
+		assert.Equal(t, ImportResult{Created: 1, Skipped: 1}, result)
+		md, err := s.GetMetadata("b")
+		assert.NoError(t, err)
+		assert.Equal(t, "Imported app", md.Title)
+	})
+
+	t.Run("Conflicting documents are overwritten, and the index is rebuilt", func(t *testing.T) {
+		s := newStore(t)
+		changes := []Change{}
+		s.OnChange(func(change Change) { changes = append(changes, change) })
+		documents := []*Metadata{{ID: "d", Title: "New app", Version: "1.0.0"}, {ID: "b", Title: "Changed app", Version: "1.1.0"}}
+		result, err := s.Import(documents, ConflictOverwrite)
+		assert.NoError(t, err)
+		assert.Equal(t, ImportResult{Created: 1, Overwritten: 1}, result)
+		md, err := s.GetMetadata("b")
+		assert.NoError(t, err)
+		assert.Equal(t, "Changed app", md.Title)
+		assert.Equal(t, Active, md.State)
+		// the overwritten metadata was at revision 2
+		assert.Equal(t, 3, md.Revision)
+
+		results, err := s.LookupMetadata(context.Background(), map[string]string{"title": "imported"}, true)
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		results, err = s.LookupMetadata(context.Background(), map[string]string{"title": "changed"}, false)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		if assert.Len(t, changes, 2) {
+			assert.Equal(t, ChangeCreate, changes[0].Type)
+			assert.Equal(t, ChangeUpdate, changes[1].Type)
+		}
+	})
+
+	t.Run("Import fails for inconsistent documents", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			documents []*Metadata
+			conflict  Conflict
+			err       string
+		}{
+			{
+				name:      "Import fails for an unknown conflict strategy",
+				documents: []*Metadata{{ID: "d"}},
+				conflict:  Conflict("merge"),
+				err:       `unknown conflict strategy "merge", expected one of fail, skip or overwrite`,
+			},
+			{
+				name:      "Import fails for duplicate IDs",
+				documents: []*Metadata{{ID: "d"}, {ID: "d"}},
+				conflict:  ConflictSkip,
+				err:       "documents 0 and 1 have the same ID d",
+			},
+			{
+				name:      "Import fails for an unknown state",
+				documents: []*Metadata{{ID: "d", State: State("archived")}},
+				conflict:  ConflictSkip,
+				err:       `document 0: unknown state "archived"`,
+			},
+			{
+				name:      "Import fails for a negative revision",
+				documents: []*Metadata{{ID: "d", Revision: -1}},
+				conflict:  ConflictSkip,
+				err:       "document 0: metadata d has a negative revision",
+			},
+			{
+				name:      "Import fails for deprecated metadata without a deprecation",
+				documents: []*Metadata{{ID: "d", State: Deprecated}},
+				conflict:  ConflictSkip,
+				err:       "document 0: deprecated metadata d must have a deprecation",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				s := newStore(t)
+				_, err := s.Import(tt.documents, tt.conflict)
+				assert.EqualError(t, err, tt.err)
+				assert.Equal(t, exported(), s.AllMetadata())
+			})
+		}
+	})
+
+	t.Run("Metadata written during an import is indexed along with the import", func(t *testing.T) {
+		s := NewStorage(WithMXCheck(false))
+		documents := []*Metadata{}
+		for i := 0; i < 200; i++ {
+			documents = append(documents, &Metadata{Title: "Imported app", Version: fmt.Sprintf("1.0.%d", i)})
+		}
+		written := make(chan error)
+		go func() {
+			written <- s.AddMetadata(context.Background(), &Metadata{Title: "Concurrent app", Version: "1.0.0"})
+		}()
+		_, err := s.Import(documents, ConflictFail)
+		assert.NoError(t, err)
+		assert.NoError(t, <-written)
+		for title, count := range map[string]int{"imported": 200, "concurrent": 1} {
+			found, err := s.LookupMetadata(context.Background(), map[string]string{"title": title}, false)
+			assert.NoError(t, err)
+			assert.Len(t, found, count, title)
+		}
+	})
+}
+
+func Test_mergeImport(t *testing.T) {
+	stored := []*Metadata{{ID: "a", Revision: 2, Title: "Stored app", Version: "1.0.0"}}
+	imported := []*Metadata{
+		{ID: "a", Revision: 1, Title: "Imported app", Version: "1.0.0"},
+		{ID: "b", Revision: 1, Title: "Imported app", Version: "1.1.0"},
+	}
+
+	t.Run("Neither stored nor imported documents are modified", func(t *testing.T) {
+		merged, err := mergeImport(stored, imported, ConflictOverwrite)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, imported[0].Revision)
+		assert.Equal(t, 2, stored[0].Revision)
+		assert.Equal(t, 3, merged.byID["a"].Revision)
+		assert.Equal(t, []*Metadata{merged.byID["a"], imported[1]}, merged.imported)
+	})
+
+	t.Run("Merging again keeps the documents that already have the right revision", func(t *testing.T) {
+		first, err := mergeImport(stored, imported, ConflictOverwrite)
+		assert.NoError(t, err)
+		second, err := mergeImport(stored, first.imported, ConflictOverwrite)
+		assert.NoError(t, err)
+		assert.Equal(t, first.documents, second.documents)
+		for i := range first.documents {
+			assert.Same(t, first.documents[i], second.documents[i])
+		}
+	})
+
+	t.Run("Conflicts leave imported documents as they were", func(t *testing.T) {
+		_, err := mergeImport(stored, imported, ConflictFail)
+		assert.Equal(t, &ConflictError{Documents: []int{0}, IDs: []string{"a"}}, err)
+		assert.Equal(t, 1, imported[0].Revision)
+	})
+}
@@ -0,0 +1,33 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit describe the build, and are set at build time with
+// -ldflags "-X github.com/medhir/yaml-api/version.Version=1.2.0 -X github.com/medhir/yaml-api/version.Commit=abc123"
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info. When the version is not set at build time, the module version recorded by the Go
+// toolchain is used, which is "(devel)" for builds from a working tree.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+	if info.Version == "" {
+		info.Version = "unknown"
+		if build, ok := debug.ReadBuildInfo(); ok && build.Main.Version != "" {
+			info.Version = build.Main.Version
+		}
+	}
+	return info
+}
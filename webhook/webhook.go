@@ -0,0 +1,629 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/medhir/yaml-api/logging"
+	"github.com/medhir/yaml-api/storage"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 signature of a delivery's body, computed with the subscription's secret
+	SignatureHeader = "X-Webhook-Signature-256"
+	// EventHeader carries the type of change a delivery describes
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader carries the ID of a delivery, which stays the same across retries
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+// ErrNotFound is returned when no subscription exists with a given ID
+var ErrNotFound = errors.New("webhook subscription not found")
+
+// Subscription describes where, and for which changes, webhooks are delivered
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Owner is the name of the principal that created the subscription, which only it, and admins, can see
+	Owner string `json:"owner,omitempty"`
+	// Filter restricts deliveries to metadata matching every attribute pattern in a query string, such as
+	// license=GPL*&title=app*. Patterns are case-insensitive, and may use * and ? wildcards.
+	Filter string `json:"filter,omitempty"`
+	// Events restricts deliveries to the listed change types. Every change type is delivered when empty.
+	Events []string `json:"events,omitempty"`
+	// Secret signs every delivery, and is never returned once the subscription is created
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created"`
+
+	patterns map[string]string
+}
+
+// Attempt records the outcome of one attempt to deliver a webhook
+type Attempt struct {
+	Time       time.Time     `json:"time"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// DeliveryStatus describes whether a webhook has been delivered
+type DeliveryStatus string
+
+const (
+	// Pending deliveries are waiting for their next attempt
+	Pending = DeliveryStatus("pending")
+	// Delivered webhooks were accepted by the receiver with a 2xx response
+	Delivered = DeliveryStatus("delivered")
+	// Failed deliveries ran out of attempts, and were moved to the dead letter list
+	Failed = DeliveryStatus("failed")
+)
+
+// Delivery describes a webhook sent, or being sent, for a single change
+type Delivery struct {
+	ID             string         `json:"id"`
+	SubscriptionID string         `json:"subscription_id"`
+	Event          string         `json:"event"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       []Attempt      `json:"attempts"`
+	Created        time.Time      `json:"created"`
+
+	url    string
+	secret string
+	owner  string
+	body   []byte
+	timer  *time.Timer
+}
+
+// Payload is the JSON body of every delivery
+type Payload struct {
+	DeliveryID string            `json:"delivery_id"`
+	Event      string            `json:"event"`
+	Time       time.Time         `json:"time"`
+	Metadata   *storage.Metadata `json:"metadata"`
+}
+
+// Options configure how webhooks are delivered
+type Options struct {
+	// Client sends deliveries, and defaults to a client with a 10 second timeout that refuses to connect to internal
+	// addresses. A custom client is trusted to make its own decisions about the addresses it connects to.
+	Client *http.Client
+	// AllowInternalURLs allows subscriptions to loopback, link-local and private addresses, which are refused by
+	// default so that webhooks cannot be used to probe the network the server runs in
+	AllowInternalURLs bool
+	// Logger logs deliveries that cannot be created, or that are abandoned on shutdown
+	Logger *logging.Logger
+	// MaxAttempts is the number of times a delivery is attempted before it is dead-lettered
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, which doubles with every retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Workers is the number of deliveries attempted concurrently
+	Workers int
+	// LogSize is the number of recent deliveries, and dead letters, kept for inspection
+	LogSize int
+}
+
+// DefaultOptions are used for any unset options
+var DefaultOptions = Options{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     5 * time.Minute,
+	Workers:        4,
+	LogSize:        1000,
+}
+
+// Dispatcher delivers signed webhooks to subscriptions matching changes to stored metadata.
+// Failed deliveries are retried with exponential backoff, and moved to a dead letter list once they run out of
+// attempts.
+type Dispatcher struct {
+	options       Options
+	mu            sync.Mutex
+	subscriptions map[string]*Subscription
+	deliveries    []*Delivery
+	deadLetters   []*Delivery
+	queue         chan *Delivery
+	closed        bool
+	wg            sync.WaitGroup
+}
+
+// NewDispatcher starts the workers delivering webhooks
+func NewDispatcher(options Options) *Dispatcher {
+	if options.Client == nil {
+		options.Client = newClient(options.AllowInternalURLs)
+	}
+	if options.Logger == nil {
+		options.Logger = logging.New(os.Stderr, logging.LevelInfo)
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = DefaultOptions.InitialBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultOptions.MaxBackoff
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultOptions.Workers
+	}
+	if options.LogSize <= 0 {
+		options.LogSize = DefaultOptions.LogSize
+	}
+	d := &Dispatcher{
+		options:       options,
+		subscriptions: map[string]*Subscription{},
+		queue:         make(chan *Delivery, options.LogSize),
+	}
+	for i := 0; i < options.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Subscribe validates and registers a subscription, returning it with its assigned ID
+func (d *Dispatcher) Subscribe(sub Subscription) (Subscription, error) {
+	u, err := url.ParseRequestURI(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, errors.New("webhook must have a valid http or https URL")
+	}
+	// host names are checked again once resolved, when deliveries connect
+	if !d.options.AllowInternalURLs && (isInternal(net.ParseIP(u.Hostname())) || strings.EqualFold(u.Hostname(), "localhost")) {
+		return Subscription{}, fmt.Errorf("webhook URL %s must not be an internal address", sub.URL)
+	}
+	if sub.Secret == "" {
+		return Subscription{}, errors.New("webhook must have a secret used to sign deliveries")
+	}
+	sub.patterns, err = parseFilter(sub.Filter)
+	if err != nil {
+		return Subscription{}, err
+	}
+	for _, event := range sub.Events {
+		switch storage.ChangeType(event) {
+		case storage.ChangeCreate, storage.ChangeUpdate, storage.ChangeDelete, storage.ChangeDeprecate, storage.ChangeYank:
+		default:
+			return Subscription{}, fmt.Errorf("unknown webhook event %q, must be one of create, update, delete, deprecate or yank", event)
+		}
+	}
+	sub.ID, err = newID()
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub.Created = time.Now().UTC()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscriptions[sub.ID] = &sub
+	return sub.redacted(), nil
+}
+
+// Unsubscribe removes a subscription. Deliveries already pending for it are still attempted.
+func (d *Dispatcher) Unsubscribe(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subscriptions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(d.subscriptions, id)
+	return nil
+}
+
+// Subscription returns the subscription with the given ID, without its secret
+func (d *Dispatcher) Subscription(id string) (Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sub, ok := d.subscriptions[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	return sub.redacted(), nil
+}
+
+// Subscriptions returns the subscriptions of an owner, or every subscription if owner is empty, without their
+// secrets
+func (d *Dispatcher) Subscriptions(owner string) []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	subs := []Subscription{}
+	for _, sub := range d.subscriptions {
+		if owner == "" || sub.Owner == owner {
+			subs = append(subs, sub.redacted())
+		}
+	}
+	return subs
+}
+
+func (sub *Subscription) redacted() Subscription {
+	c := *sub
+	c.Secret = ""
+	c.Events = append([]string{}, sub.Events...)
+	return c
+}
+
+// Dispatch queues a delivery to every subscription matching a change. It never blocks, so it is safe to call from
+// a storage change listener.
+func (d *Dispatcher) Dispatch(change storage.Change) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	for _, sub := range d.subscriptions {
+		if !sub.matches(change) {
+			continue
+		}
+		delivery, err := d.newDelivery(sub, change)
+		if err != nil {
+			d.options.Logger.Error("could not create webhook delivery", "subscription", sub.ID, "error", err)
+			continue
+		}
+		d.record(delivery)
+		select {
+		case d.queue <- delivery:
+		default:
+			delivery.Attempts = append(delivery.Attempts, Attempt{Time: time.Now().UTC(), Error: "delivery queue is full"})
+			d.deadLetter(delivery)
+		}
+	}
+}
+
+func (d *Dispatcher) newDelivery(sub *Subscription, change storage.Change) (*Delivery, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	body, err := json.Marshal(Payload{DeliveryID: id, Event: string(change.Type), Time: now, Metadata: change.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	return &Delivery{
+		ID:             id,
+		SubscriptionID: sub.ID,
+		Event:          string(change.Type),
+		Status:         Pending,
+		Attempts:       []Attempt{},
+		Created:        now,
+		url:            sub.URL,
+		secret:         sub.Secret,
+		owner:          sub.Owner,
+		body:           body,
+	}, nil
+}
+
+// record adds a delivery to the log of recent deliveries, and must be called while holding the lock
+func (d *Dispatcher) record(delivery *Delivery) {
+	d.deliveries = append(d.deliveries, delivery)
+	if len(d.deliveries) > d.options.LogSize {
+		d.deliveries = d.deliveries[len(d.deliveries)-d.options.LogSize:]
+	}
+}
+
+// deadLetter gives up on a delivery, and must be called while holding the lock
+func (d *Dispatcher) deadLetter(delivery *Delivery) {
+	delivery.Status = Failed
+	d.deadLetters = append(d.deadLetters, delivery)
+	if len(d.deadLetters) > d.options.LogSize {
+		d.deadLetters = d.deadLetters[len(d.deadLetters)-d.options.LogSize:]
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for delivery := range d.queue {
+		d.attempt(delivery)
+	}
+}
+
+// attempt sends a delivery once, and schedules a retry if it fails
+func (d *Dispatcher) attempt(delivery *Delivery) {
+	attempt := Attempt{Time: time.Now().UTC()}
+	req, err := http.NewRequest(http.MethodPost, delivery.url, bytes.NewReader(delivery.body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "yaml-api-webhooks")
+		req.Header.Set(EventHeader, delivery.Event)
+		req.Header.Set(DeliveryHeader, delivery.ID)
+		req.Header.Set(SignatureHeader, Sign(delivery.secret, delivery.body))
+		var resp *http.Response
+		resp, err = d.options.Client.Do(req)
+		if err == nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			attempt.StatusCode = resp.StatusCode
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("receiver responded with %s", resp.Status)
+			}
+		}
+	}
+	attempt.Duration = time.Since(attempt.Time)
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if err == nil {
+		delivery.Status = Delivered
+		return
+	}
+	if len(delivery.Attempts) >= d.options.MaxAttempts {
+		d.deadLetter(delivery)
+		return
+	}
+	if d.closed {
+		// pending retries were already attempted early by Close, so this was the last chance to deliver
+		d.options.Logger.Warn("abandoned webhook delivery on shutdown", "delivery", delivery.ID, "subscription", delivery.SubscriptionID, "attempts", len(delivery.Attempts), "error", err)
+		return
+	}
+	delivery.timer = time.AfterFunc(d.backoff(len(delivery.Attempts)), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.closed {
+			// the timer fired while Close was stopping it, too late to be queued
+			d.options.Logger.Warn("abandoned webhook delivery on shutdown", "delivery", delivery.ID, "subscription", delivery.SubscriptionID, "attempts", len(delivery.Attempts), "error", err)
+			return
+		}
+		select {
+		case d.queue <- delivery:
+		default:
+			delivery.Attempts = append(delivery.Attempts, Attempt{Time: time.Now().UTC(), Error: "delivery queue is full"})
+			d.deadLetter(delivery)
+		}
+	})
+}
+
+// backoff returns the delay before the retry following the given number of attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.options.InitialBackoff
+	for i := 1; i < attempts && delay < d.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.options.MaxBackoff {
+		delay = d.options.MaxBackoff
+	}
+	return delay
+}
+
+// Deliveries returns the recent deliveries to a subscription, most recent first
+func (d *Dispatcher) Deliveries(subscriptionID string) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deliveries := []Delivery{}
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		if d.deliveries[i].SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, d.deliveries[i].copy())
+		}
+	}
+	return deliveries
+}
+
+// DeadLetters returns the deliveries to the subscriptions of an owner, or to every subscription if owner is empty,
+// that ran out of attempts, most recent first
+func (d *Dispatcher) DeadLetters(owner string) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deliveries := []Delivery{}
+	for i := len(d.deadLetters) - 1; i >= 0; i-- {
+		if owner == "" || d.deadLetters[i].owner == owner {
+			deliveries = append(deliveries, d.deadLetters[i].copy())
+		}
+	}
+	return deliveries
+}
+
+func (delivery *Delivery) copy() Delivery {
+	c := *delivery
+	c.Attempts = append([]Attempt{}, delivery.Attempts...)
+	return c
+}
+
+// Close stops queueing deliveries and scheduling retries, and waits for queued deliveries to be attempted until
+// ctx is done. Deliveries waiting to be retried are attempted once more right away, rather than after their backoff.
+// Deliveries that still fail, or are not attempted before ctx is done, are logged.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for _, delivery := range d.deliveries {
+		if delivery.timer == nil || !delivery.timer.Stop() {
+			continue
+		}
+		select {
+		case d.queue <- delivery:
+		default:
+			d.options.Logger.Warn("abandoned webhook delivery on shutdown", "delivery", delivery.ID, "subscription", delivery.SubscriptionID, "attempts", len(delivery.Attempts), "error", "delivery queue is full")
+		}
+	}
+	close(d.queue)
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.options.Logger.Warn("abandoned webhook deliveries on shutdown", "queued", len(d.queue), "error", ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// newClient returns the client deliveries are sent with by default. Unless internal URLs are allowed, it refuses to
+// connect to internal addresses, which also covers host names resolving to them and redirects to them. Deliveries
+// are then sent directly rather than through a proxy from the environment, since only the proxy's address could be
+// checked.
+func newClient(allowInternal bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowInternal {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if isInternal(net.ParseIP(host)) {
+				return fmt.Errorf("refusing to deliver a webhook to the internal address %s", host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	if !allowInternal {
+		transport.Proxy = nil
+	}
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// privateNetworks are reserved for private use by RFC 1918, RFC 6598 and RFC 4193
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// isInternal reports whether ip is a loopback, link-local, private, multicast or unspecified address
+func isInternal(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature of a delivery body, as sent in the SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether a signature sent in the SignatureHeader matches a delivery body
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func (sub *Subscription) matches(change storage.Change) bool {
+	if len(sub.Events) > 0 {
+		found := false
+		for _, event := range sub.Events {
+			if event == string(change.Type) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for attr, pattern := range sub.patterns {
+		values, _ := change.Metadata.AttributeValues(attr)
+		matched := false
+		for _, value := range values {
+			if matchPattern(pattern, strings.ToLower(value)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFilter parses a filter query string into lowercase patterns keyed by attribute
+func parseFilter(filter string) (map[string]string, error) {
+	values, err := url.ParseQuery(filter)
+	if err != nil {
+		return nil, fmt.Errorf("webhook filter must be formatted as a query string, such as license=GPL*: %s", err.Error())
+	}
+	patterns := map[string]string{}
+	for attr, v := range values {
+		if _, ok := (&storage.Metadata{}).AttributeValues(attr); !ok {
+			return nil, fmt.Errorf("cannot filter webhooks by unknown attribute %q", attr)
+		}
+		if len(v) != 1 {
+			return nil, fmt.Errorf("webhook filter must have a single pattern for %s", attr)
+		}
+		patterns[attr] = strings.ToLower(v[0])
+	}
+	return patterns, nil
+}
+
+// matchPattern reports whether s matches a pattern, where * matches any sequence of characters and ? matches any
+// single character
+func matchPattern(pattern, s string) bool {
+	p, str := []rune(pattern), []rune(s)
+	// star and match record the position of the last * to backtrack to
+	star, match := -1, 0
+	i, j := 0, 0
+	for j < len(str) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == str[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, match = i, j
+			i++
+		case star != -1:
+			i = star + 1
+			match++
+			j = match
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate webhook ID: %s", err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,233 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/medhir/yaml-api/logging"
+	"github.com/medhir/yaml-api/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver is a local webhook receiver that fails the first failures requests it receives
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newReceiver(failures int) (*receiver, *httptest.Server) {
+	rec := &receiver{failures: failures, received: make(chan struct{}, 100)}
+	return rec, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		fail := len(rec.requests) <= rec.failures
+		rec.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		rec.received <- struct{}{}
+	}))
+}
+
+func (rec *receiver) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-rec.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for delivery %d", i+1)
+		}
+	}
+}
+
+// testOptions allow internal URLs, since test receivers listen on loopback addresses
+var testOptions = Options{
+	AllowInternalURLs: true,
+	Logger:            logging.New(ioutil.Discard, logging.LevelError),
+	MaxAttempts:       3,
+	InitialBackoff:    time.Millisecond,
+	MaxBackoff:        4 * time.Millisecond,
+	Workers:           2,
+}
+
+func gplMetadata() *storage.Metadata {
+	return &storage.Metadata{
+		ID:          "abc",
+		Title:       "App title 1",
+		Version:     "1.0.0",
+		Maintainers: []storage.Maintainer{{Name: "Bill Bob", Email: "bill@gmail.com"}},
+		License:     "GPL-3.0",
+	}
+}
+
+func Test_Dispatcher(t *testing.T) {
+	t.Run("Matching changes are delivered with a verifiable signature", func(t *testing.T) {
+		rec, ts := newReceiver(0)
+		defer ts.Close()
+		d := NewDispatcher(testOptions)
+		sub, err := d.Subscribe(Subscription{URL: ts.URL, Filter: "license=gpl*&maintainer_email=*@gmail.com", Secret: "s3cret"})
+		assert.NoError(t, err)
+		assert.Empty(t, sub.Secret)
+		d.Dispatch(storage.Change{Type: storage.ChangeCreate, Metadata: &storage.Metadata{License: "MIT"}})
+		d.Dispatch(storage.Change{Type: storage.ChangeCreate, Metadata: gplMetadata()})
+		rec.wait(t, 1)
+		assert.NoError(t, d.Close(context.Background()))
+
+		assert.Equal(t, 1, len(rec.requests))
+		r := rec.requests[0]
+		assert.Equal(t, "create", r.Header.Get(EventHeader))
+		assert.True(t, Verify("s3cret", rec.bodies[0], r.Header.Get(SignatureHeader)))
+		assert.False(t, Verify("guess", rec.bodies[0], r.Header.Get(SignatureHeader)))
+		payload := Payload{}
+		assert.NoError(t, json.Unmarshal(rec.bodies[0], &payload))
+		assert.Equal(t, r.Header.Get(DeliveryHeader), payload.DeliveryID)
+		assert.Equal(t, gplMetadata(), payload.Metadata)
+
+		deliveries := d.Deliveries(sub.ID)
+		assert.Equal(t, 1, len(deliveries))
+		assert.Equal(t, Delivered, deliveries[0].Status)
+		assert.Equal(t, http.StatusOK, deliveries[0].Attempts[0].StatusCode)
+	})
+
+	t.Run("Failed deliveries are retried with backoff", func(t *testing.T) {
+		rec, ts := newReceiver(2)
+		defer ts.Close()
+		d := NewDispatcher(testOptions)
+		sub, err := d.Subscribe(Subscription{URL: ts.URL, Events: []string{"deprecate"}, Secret: "s3cret"})
+		assert.NoError(t, err)
+		d.Dispatch(storage.Change{Type: storage.ChangeCreate, Metadata: gplMetadata()})
+		d.Dispatch(storage.Change{Type: storage.ChangeDeprecate, Metadata: gplMetadata()})
+		rec.wait(t, 3)
+		assert.NoError(t, d.Close(context.Background()))
+
+		deliveries := d.Deliveries(sub.ID)
+		assert.Equal(t, 1, len(deliveries))
+		assert.Equal(t, Delivered, deliveries[0].Status)
+		assert.Equal(t, 3, len(deliveries[0].Attempts))
+		assert.Equal(t, "receiver responded with 503 Service Unavailable", deliveries[0].Attempts[0].Error)
+		assert.Equal(t, rec.requests[0].Header.Get(DeliveryHeader), rec.requests[2].Header.Get(DeliveryHeader))
+		assert.Empty(t, d.DeadLetters(""))
+	})
+
+	t.Run("Deliveries that run out of attempts are dead-lettered", func(t *testing.T) {
+		rec, ts := newReceiver(100)
+		defer ts.Close()
+		d := NewDispatcher(testOptions)
+		_, err := d.Subscribe(Subscription{URL: ts.URL, Secret: "s3cret"})
+		assert.NoError(t, err)
+		d.Dispatch(storage.Change{Type: storage.ChangeYank, Metadata: gplMetadata()})
+		rec.wait(t, 3)
+		assert.Eventually(t, func() bool { return len(d.DeadLetters("")) == 1 }, 5*time.Second, time.Millisecond)
+		assert.NoError(t, d.Close(context.Background()))
+		assert.Equal(t, Failed, d.DeadLetters("")[0].Status)
+		assert.Equal(t, 3, len(d.DeadLetters("")[0].Attempts))
+	})
+
+	t.Run("Invalid subscriptions are rejected", func(t *testing.T) {
+		d := NewDispatcher(testOptions)
+		defer d.Close(context.Background())
+		_, err := d.Subscribe(Subscription{URL: "ftp://example.com", Secret: "s3cret"})
+		assert.EqualError(t, err, "webhook must have a valid http or https URL")
+		_, err = d.Subscribe(Subscription{URL: "https://example.com"})
+		assert.EqualError(t, err, "webhook must have a secret used to sign deliveries")
+		_, err = d.Subscribe(Subscription{URL: "https://example.com", Secret: "s3cret", Filter: "colour=blue"})
+		assert.EqualError(t, err, `cannot filter webhooks by unknown attribute "colour"`)
+		_, err = d.Subscribe(Subscription{URL: "https://example.com", Secret: "s3cret", Events: []string{"publish"}})
+		assert.EqualError(t, err, `unknown webhook event "publish", must be one of create, update, delete, deprecate or yank`)
+		assert.Empty(t, d.Subscriptions(""))
+	})
+
+	t.Run("Subscriptions to internal addresses are rejected", func(t *testing.T) {
+		options := testOptions
+		options.AllowInternalURLs = false
+		d := NewDispatcher(options)
+		defer d.Close(context.Background())
+		for _, target := range []string{"http://127.0.0.1:8080", "http://localhost/hook", "http://169.254.169.254/latest", "https://10.1.2.3", "http://[::1]/", "http://[fd00::1]/"} {
+			_, err := d.Subscribe(Subscription{URL: target, Secret: "s3cret"})
+			assert.EqualError(t, err, "webhook URL "+target+" must not be an internal address")
+		}
+		_, err := d.Subscribe(Subscription{URL: "https://203.0.113.10/hook", Secret: "s3cret"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Deliveries are not sent to host names resolving to internal addresses", func(t *testing.T) {
+		_, ts := newReceiver(0)
+		defer ts.Close()
+		_, err := newClient(false).Post(strings.Replace(ts.URL, "127.0.0.1", "localhost", 1), "application/json", nil)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "refusing to deliver a webhook to the internal address")
+		}
+		resp, err := newClient(true).Post(ts.URL, "application/json", nil)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	})
+
+	t.Run("Deliveries are not sent through a proxy, which would hide their target from the check", func(t *testing.T) {
+		assert.Nil(t, newClient(false).Transport.(*http.Transport).Proxy)
+		assert.NotNil(t, newClient(true).Transport.(*http.Transport).Proxy)
+	})
+
+	t.Run("Subscriptions and dead letters are listed by owner", func(t *testing.T) {
+		rec, ts := newReceiver(100)
+		defer ts.Close()
+		d := NewDispatcher(testOptions)
+		alice, err := d.Subscribe(Subscription{URL: ts.URL, Owner: "alice", Secret: "s3cret"})
+		assert.NoError(t, err)
+		_, err = d.Subscribe(Subscription{URL: ts.URL, Owner: "bob", Secret: "s3cret", Events: []string{"delete"}})
+		assert.NoError(t, err)
+		assert.Equal(t, []Subscription{alice}, d.Subscriptions("alice"))
+		assert.Len(t, d.Subscriptions(""), 2)
+
+		d.Dispatch(storage.Change{Type: storage.ChangeYank, Metadata: gplMetadata()})
+		rec.wait(t, 3)
+		assert.Eventually(t, func() bool { return len(d.DeadLetters("alice")) == 1 }, 5*time.Second, time.Millisecond)
+		assert.NoError(t, d.Close(context.Background()))
+		assert.Empty(t, d.DeadLetters("bob"))
+	})
+
+	t.Run("Retries are attempted right away on shutdown", func(t *testing.T) {
+		rec, ts := newReceiver(1)
+		defer ts.Close()
+		options := testOptions
+		options.InitialBackoff = time.Hour
+		d := NewDispatcher(options)
+		sub, err := d.Subscribe(Subscription{URL: ts.URL, Secret: "s3cret"})
+		assert.NoError(t, err)
+		d.Dispatch(storage.Change{Type: storage.ChangeCreate, Metadata: gplMetadata()})
+		rec.wait(t, 1)
+		assert.Eventually(t, func() bool { return len(d.Deliveries(sub.ID)[0].Attempts) == 1 }, 5*time.Second, time.Millisecond)
+		assert.NoError(t, d.Close(context.Background()))
+		deliveries := d.Deliveries(sub.ID)
+		assert.Equal(t, Delivered, deliveries[0].Status)
+		assert.Len(t, deliveries[0].Attempts, 2)
+	})
+}
+
+func Test_backoff(t *testing.T) {
+	d := &Dispatcher{options: Options{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	assert.Equal(t, time.Second, d.backoff(1))
+	assert.Equal(t, 2*time.Second, d.backoff(2))
+	assert.Equal(t, 4*time.Second, d.backoff(3))
+	assert.Equal(t, 5*time.Second, d.backoff(4))
+}
+
+func Test_matchPattern(t *testing.T) {
+	assert.True(t, matchPattern("gpl*", "gpl-3.0"))
+	assert.True(t, matchPattern("*gpl*", "agpl-3.0"))
+	assert.True(t, matchPattern("https://github.com/*", "https://github.com/random/repo"))
+	assert.True(t, matchPattern("?pl", "gpl"))
+	assert.False(t, matchPattern("gpl*", "agpl-3.0"))
+	assert.False(t, matchPattern("mit", "mit-0"))
+}